@@ -0,0 +1,253 @@
+/*
+Appliance profiles with non-flat load curves. Like washing machine with heating phase at start.
+Planner finds start time (15 minute steps) that minimizes cost with known hour prices
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"time"
+)
+
+const SLOTDURATION = 15 * time.Minute //Resolution of load curves
+
+type ApplianceProfile struct {
+	Name          string    `json:"name"`
+	Curve         []float64 `json:"curve"`                   //Power in kW for each 15 minutes of program
+	EarliestStart string    `json:"earliestStart,omitempty"` //HH:MM finnish time, optional
+	LatestFinish  string    `json:"latestFinish,omitempty"`  //HH:MM finnish time, optional
+}
+
+type AppliancePlan struct {
+	Name         string
+	Start        time.Time
+	End          time.Time
+	EnergyKWh    float64
+	CostEur      float64
+	NowAvailable bool //Is starting now possible with known prices and constraints
+	NowCostEur   float64
+	SavingsEur   float64 //Versus starting now
+}
+
+//parseClock parses HH:MM
+func parseClock(s string) (int, int, error) {
+	t, errParse := time.Parse("15:04", s)
+	if errParse != nil {
+		return 0, 0, fmt.Errorf("invalid time %s, use HH:MM", s)
+	}
+	return t.Hour(), t.Minute(), nil
+}
+
+//nextClock is first time after t when finnish clock shows HH:MM
+func nextClock(t time.Time, clock string) (time.Time, error) {
+	h, m, errClock := parseClock(clock)
+	if errClock != nil {
+		return t, errClock
+	}
+	lt, errLt := TimeInFinland(t)
+	if errLt != nil {
+		return t, errLt
+	}
+	result := time.Date(lt.Year(), lt.Month(), lt.Day(), h, m, 0, 0, lt.Location())
+	if !result.After(t) {
+		result = time.Date(lt.Year(), lt.Month(), lt.Day()+1, h, m, 0, 0, lt.Location())
+	}
+	return result, nil
+}
+
+func (p *ApplianceProfile) CheckErr() error {
+	if p.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if len(p.Curve) == 0 {
+		return fmt.Errorf("%s has empty curve", p.Name)
+	}
+	for _, clock := range []string{p.EarliestStart, p.LatestFinish} {
+		if clock == "" {
+			continue
+		}
+		_, _, errClock := parseClock(clock)
+		if errClock != nil {
+			return fmt.Errorf("%s %v", p.Name, errClock.Error())
+		}
+	}
+	return nil
+}
+
+func (p *ApplianceProfile) Duration() time.Duration {
+	return time.Duration(len(p.Curve)) * SLOTDURATION
+}
+
+/*
+allowedPeriod is period when program may run. If clock is between earliest start and latest finish,
+period starts now. Earliest start without latest finish allows starting now until midnight. Zero end means no limit
+*/
+func (p *ApplianceProfile) allowedPeriod(tNow time.Time) (time.Time, time.Time, error) {
+	if p.EarliestStart == "" {
+		if p.LatestFinish == "" {
+			return tNow, time.Time{}, nil
+		}
+		end, errEnd := nextClock(tNow, p.LatestFinish)
+		return tNow, end, errEnd
+	}
+	nextStart, errStart := nextClock(tNow, p.EarliestStart)
+	if errStart != nil {
+		return tNow, tNow, errStart
+	}
+	prevStart := nextStart.AddDate(0, 0, -1)
+	if p.LatestFinish == "" {
+		lt, _ := TimeInFinland(prevStart)
+		midnight := time.Date(lt.Year(), lt.Month(), lt.Day()+1, 0, 0, 0, 0, lt.Location())
+		if tNow.Before(midnight) {
+			return tNow, time.Time{}, nil
+		}
+		return nextStart, time.Time{}, nil
+	}
+	prevEnd, errPrevEnd := nextClock(prevStart, p.LatestFinish)
+	if errPrevEnd != nil {
+		return tNow, tNow, errPrevEnd
+	}
+	if tNow.Before(prevEnd) { //Going on now
+		return tNow, prevEnd, nil
+	}
+	nextEnd, errNextEnd := nextClock(nextStart, p.LatestFinish)
+	return nextStart, nextEnd, errNextEnd
+}
+
+//costAt is cost in cents when starting at start. False if prices are not known for whole run
+func (p *ApplianceProfile) costAt(prices map[int64]float64, start time.Time) (float64, bool) {
+	result := float64(0)
+	for i, kw := range p.Curve {
+		price, known := prices[start.Add(time.Duration(i)*SLOTDURATION).Truncate(time.Hour).Unix()]
+		if !known {
+			return 0, false
+		}
+		result += kw * SLOTDURATION.Hours() * price
+	}
+	return result, true
+}
+
+//Plan finds cheapest start. Prices are hour prices in VATTENFALLEXPECTED_UNIT
+func (p *ApplianceProfile) Plan(prices []HourPrice, tNow time.Time) (AppliancePlan, error) {
+	result := AppliancePlan{Name: p.Name}
+	if len(prices) == 0 {
+		return result, fmt.Errorf("no prices")
+	}
+	priceMap := make(map[int64]float64)
+	for _, hp := range prices {
+		priceMap[hp.Start.Unix()] = hp.Price
+	}
+	for _, kw := range p.Curve {
+		result.EnergyKWh += kw * SLOTDURATION.Hours()
+	}
+
+	periodStart, periodEnd, errPeriod := p.allowedPeriod(tNow)
+	if errPeriod != nil {
+		return result, errPeriod
+	}
+
+	nowCost, nowKnown := p.costAt(priceMap, tNow)
+	nowEnd := tNow.Add(p.Duration())
+	result.NowAvailable = nowKnown && !tNow.Before(periodStart) && (periodEnd.IsZero() || !periodEnd.Before(nowEnd))
+	result.NowCostEur = nowCost / 100
+
+	bestCost := math.Inf(1)
+	start := periodStart.Truncate(SLOTDURATION)
+	if start.Before(periodStart) {
+		start = start.Add(SLOTDURATION)
+	}
+	for ; ; start = start.Add(SLOTDURATION) {
+		end := start.Add(p.Duration())
+		if !periodEnd.IsZero() && periodEnd.Before(end) {
+			break
+		}
+		cost, known := p.costAt(priceMap, start)
+		if !known {
+			if prices[len(prices)-1].Start.Before(start) {
+				break //Past known prices
+			}
+			continue
+		}
+		if cost < bestCost {
+			bestCost = cost
+			result.Start = start
+			result.End = end
+		}
+	}
+	if math.IsInf(bestCost, 1) {
+		return result, fmt.Errorf("%s does not fit in known prices and constraints", p.Name)
+	}
+	result.CostEur = bestCost / 100
+	if result.NowAvailable {
+		result.SavingsEur = result.NowCostEur - result.CostEur
+	}
+	return result, nil
+}
+
+func (p *AppliancePlan) String() string {
+	start, _ := TimeInFinland(p.Start)
+	end, _ := TimeInFinland(p.End)
+	result := fmt.Sprintf("%s: start %s %s, ready %s %s, %.2f kWh, cost %.2f EUR", p.Name,
+		FinnishWeekDayName(start), start.Format("15:04"), FinnishWeekDayName(end), end.Format("15:04"), p.EnergyKWh, p.CostEur)
+	if p.NowAvailable {
+		result += fmt.Sprintf(", starting now %.2f EUR, saves %.2f EUR", p.NowCostEur, p.SavingsEur)
+	}
+	return result
+}
+
+//PlanAppliances plans all from current hour onwards
+func (p *PriceStore) PlanAppliances(appliances []ApplianceProfile, tNow time.Time) ([]AppliancePlan, error) {
+	horizon, errHorizon := p.ShownHorizon(tNow)
+	if errHorizon != nil {
+		return nil, errHorizon
+	}
+	result := []AppliancePlan{}
+	for _, appliance := range appliances {
+		plan, errPlan := appliance.Plan(horizon, tNow)
+		if errPlan != nil {
+			return result, errPlan
+		}
+		result = append(result, plan)
+	}
+	return result, nil
+}
+
+//cmdPlan is "plan" subcommand
+func cmdPlan(args []string) error {
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	pConfigFileName := fs.String("config", "spotview.json", "configuration file with appliance profiles")
+	pName := fs.String("name", "", "plan only this appliance (default all)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	getVatMode := vatFlags(fs)
+	fs.Parse(args)
+
+	conf, errConf := LoadConfig(*pConfigFileName)
+	if errConf != nil {
+		return errConf
+	}
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		return errVat
+	}
+	appliances := []ApplianceProfile{}
+	for _, appliance := range conf.Appliances {
+		if *pName == "" || *pName == appliance.Name {
+			appliances = append(appliances, appliance)
+		}
+	}
+	if len(appliances) == 0 {
+		return fmt.Errorf("no appliances %s in %s", *pName, *pConfigFileName)
+	}
+
+	waitClock()
+
+	store := NewPriceStore(*pCacheDirName)
+	store.Vat = vatMode
+	plans, errPlans := store.PlanAppliances(appliances, time.Now())
+	for _, plan := range plans {
+		fmt.Printf("%s\n", plan.String())
+	}
+	return errPlans
+}
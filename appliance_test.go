@@ -0,0 +1,75 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+//testClock is finnish time HH:MM of 2026-10-19
+func testClock(t *testing.T, clock string) time.Time {
+	t.Helper()
+	h, m, errClock := parseClock(clock)
+	if errClock != nil {
+		t.Fatal(errClock)
+	}
+	lt, _ := TimeInFinland(testDate(t, "2026-10-19"))
+	return time.Date(lt.Year(), lt.Month(), lt.Day(), h, m, 0, 0, lt.Location())
+}
+
+func TestAllowedPeriodEarliestOnly(t *testing.T) {
+	appliance := ApplianceProfile{Name: "sauna", Curve: []float64{6}, EarliestStart: "20:00"}
+	cases := []struct {
+		now   string
+		start string
+	}{
+		{"10:00", "20:00"}, //Before earliest start, wait for it
+		{"00:30", "20:00"},
+		{"20:00", "20:00"},
+		{"21:15", "21:15"}, //After earliest start, can start now
+	}
+	for _, c := range cases {
+		tNow := testClock(t, c.now)
+		start, end, errPeriod := appliance.allowedPeriod(tNow)
+		if errPeriod != nil {
+			t.Fatal(errPeriod)
+		}
+		if !start.Equal(testClock(t, c.start)) || !end.IsZero() {
+			t.Errorf("at %s period %v - %v, wanted start %s without end", c.now, start, end, c.start)
+		}
+	}
+}
+
+func TestAllowedPeriodOvernight(t *testing.T) {
+	appliance := ApplianceProfile{Name: "washer", Curve: []float64{2}, EarliestStart: "20:00", LatestFinish: "07:00"}
+	start, end, _ := appliance.allowedPeriod(testClock(t, "03:00"))
+	if !start.Equal(testClock(t, "03:00")) || !end.Equal(testClock(t, "07:00")) {
+		t.Errorf("going on period %v - %v", start, end)
+	}
+	start, end, _ = appliance.allowedPeriod(testClock(t, "12:00"))
+	if !start.Equal(testClock(t, "20:00")) || !end.Equal(testClock(t, "07:00").AddDate(0, 0, 1)) {
+		t.Errorf("next period %v - %v", start, end)
+	}
+}
+
+func TestAppliancePlan(t *testing.T) {
+	today := rampPrices(20, 0)
+	today[22], today[23] = 5, 1
+	store := testStore(t, "2026-10-19", today, rampPrices(2, 1))
+	tNow := testClock(t, "21:10")
+	horizon, _ := store.Horizon(tNow)
+
+	washer := ApplianceProfile{Name: "washer", Curve: []float64{2, 2, 0.3, 0.3, 0.3, 0.3, 0.6, 0.6}}
+	plan, errPlan := washer.Plan(horizon, tNow)
+	if errPlan != nil {
+		t.Fatal(errPlan)
+	}
+	if !plan.Start.Equal(testClock(t, "23:00")) {
+		t.Errorf("washer starts %v, wanted heating phase at cheapest hour 23:00", plan.Start)
+	}
+	if 1e-9 < plan.EnergyKWh-1.6 || 1e-9 < 1.6-plan.EnergyKWh {
+		t.Errorf("energy %v kWh, wanted 1.6", plan.EnergyKWh)
+	}
+	if !plan.NowAvailable || plan.SavingsEur <= 0 {
+		t.Errorf("starting now should be possible and more expensive %+v", plan)
+	}
+}
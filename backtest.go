@@ -0,0 +1,319 @@
+/*
+backtest subcommand. Replays cached days through control policies (fixed night, cheapest-N, threshold,
+contiguous window) with same daily energy need, and compares costs to uncontrolled baseline load profile.
+Reads only cache, result depends only on cached data and options
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type BacktestLoad struct {
+	EnergyKWh float64     //Needed energy per day
+	PowerKW   float64     //Power of controlled device
+	Profile   [24]float64 //Baseline weights by hour of day, when energy is used without control
+}
+
+//hoursNeeded is full hours device runs per day
+func (p *BacktestLoad) hoursNeeded() int {
+	return int(math.Ceil(p.EnergyKWh/p.PowerKW - 1e-9))
+}
+
+//BacktestPolicy gives energy (kWh) for each hour of day
+type BacktestPolicy struct {
+	Name     string
+	Schedule func(day []HourPrice, load BacktestLoad) []float64
+}
+
+type BacktestResult struct {
+	Policy         string  `json:"policy"`
+	Days           int     `json:"days"`
+	EnergyKWh      float64 `json:"energyKWh"`
+	FallbackKWh    float64 `json:"fallbackKWh"` //Energy that policy did not cover and was taken from cheapest remaining hours
+	CostEur        float64 `json:"costEur"`
+	AveragePrice   float64 `json:"averagePrice"` //Paid c/kWh
+	SavingsEur     float64 `json:"savingsEur"`   //Versus baseline
+	SavingsPercent float64 `json:"savingsPercent"`
+}
+
+//fillHours runs device at full power in given hour order until energy is covered
+func fillHours(day []HourPrice, order []int, load BacktestLoad, result []float64) float64 {
+	remaining := load.EnergyKWh
+	for _, i := range order {
+		if remaining <= 1e-9 {
+			break
+		}
+		energy := math.Min(load.PowerKW-result[i], remaining)
+		if 0 < energy {
+			result[i] += energy
+			remaining -= energy
+		}
+	}
+	return remaining
+}
+
+//priceOrder is indexes of day from cheapest, ties by time
+func priceOrder(day []HourPrice) []int {
+	result := make([]int, len(day))
+	for i := range result {
+		result[i] = i
+	}
+	sort.SliceStable(result, func(a, b int) bool { return day[result[a]].Price < day[result[b]].Price })
+	return result
+}
+
+func baselinePolicy() BacktestPolicy {
+	return BacktestPolicy{Name: "baseline", Schedule: func(day []HourPrice, load BacktestLoad) []float64 {
+		result := make([]float64, len(day))
+		sum := float64(0)
+		for _, hp := range day {
+			lt, _ := TimeInFinland(hp.Start)
+			sum += load.Profile[lt.Hour()]
+		}
+		for i, hp := range day {
+			lt, _ := TimeInFinland(hp.Start)
+			result[i] = load.EnergyKWh * load.Profile[lt.Hour()] / sum
+		}
+		return result
+	}}
+}
+
+//nightPolicy runs from start of range until energy is covered, like timer controlled boiler
+func nightPolicy(night HourRange) BacktestPolicy {
+	return BacktestPolicy{Name: fmt.Sprintf("night %02d-%02d", night.Start, night.End), Schedule: func(day []HourPrice, load BacktestLoad) []float64 {
+		order := []int{}
+		for step := 0; step < 24; step++ {
+			h := (night.Start + step) % 24
+			if !night.Contains(h) {
+				break
+			}
+			for i, hp := range day {
+				lt, _ := TimeInFinland(hp.Start)
+				if lt.Hour() == h {
+					order = append(order, i)
+				}
+			}
+		}
+		result := make([]float64, len(day))
+		fillHours(day, order, load, result)
+		return result
+	}}
+}
+
+func cheapestPolicy(n int) BacktestPolicy {
+	return BacktestPolicy{Name: fmt.Sprintf("cheapest %d", n), Schedule: func(day []HourPrice, load BacktestLoad) []float64 {
+		order := priceOrder(day)
+		if n < len(order) {
+			order = order[:n]
+		}
+		result := make([]float64, len(day))
+		fillHours(day, order, load, result)
+		return result
+	}}
+}
+
+//thresholdPolicy runs in time order when price is below threshold
+func thresholdPolicy(threshold float64) BacktestPolicy {
+	return BacktestPolicy{Name: fmt.Sprintf("threshold %v", threshold), Schedule: func(day []HourPrice, load BacktestLoad) []float64 {
+		order := []int{}
+		for i, hp := range day {
+			if hp.Price < threshold {
+				order = append(order, i)
+			}
+		}
+		result := make([]float64, len(day))
+		fillHours(day, order, load, result)
+		return result
+	}}
+}
+
+func windowPolicy(hours int) BacktestPolicy {
+	return BacktestPolicy{Name: fmt.Sprintf("window %dh", hours), Schedule: func(day []HourPrice, load BacktestLoad) []float64 {
+		result := make([]float64, len(day))
+		window, errWindow := CheapestWindow(day, hours, nil)
+		if errWindow != nil {
+			return result
+		}
+		order := []int{}
+		for i, hp := range day {
+			if !hp.Start.Before(window.Start) && hp.Start.Before(window.End()) {
+				order = append(order, i)
+			}
+		}
+		fillHours(day, order, load, result)
+		return result
+	}}
+}
+
+/*
+Backtest replays days through policies. First result is baseline. Energy that policy leaves uncovered
+is taken from cheapest remaining hours of day, so all policies deliver same energy
+*/
+func Backtest(days [][]HourPrice, load BacktestLoad, policies []BacktestPolicy) []BacktestResult {
+	all := append([]BacktestPolicy{baselinePolicy()}, policies...)
+	result := make([]BacktestResult, len(all))
+	for i, policy := range all {
+		result[i].Policy = policy.Name
+		for _, day := range days {
+			schedule := policy.Schedule(day, load)
+			used := float64(0)
+			for _, energy := range schedule {
+				used += energy
+			}
+			if used < load.EnergyKWh-1e-9 {
+				rest := load
+				rest.EnergyKWh = load.EnergyKWh - used
+				left := fillHours(day, priceOrder(day), rest, schedule)
+				result[i].FallbackKWh += rest.EnergyKWh - left
+			}
+			for h, energy := range schedule {
+				result[i].EnergyKWh += energy
+				result[i].CostEur += energy * day[h].Price / 100
+			}
+			result[i].Days++
+		}
+		if 0 < result[i].EnergyKWh {
+			result[i].AveragePrice = 100 * result[i].CostEur / result[i].EnergyKWh
+		}
+	}
+	for i := range result {
+		result[i].SavingsEur = result[0].CostEur - result[i].CostEur
+		if result[0].CostEur != 0 {
+			result[i].SavingsPercent = 100 * result[i].SavingsEur / result[0].CostEur
+		}
+	}
+	return result
+}
+
+//parseProfile parses 24 comma separated weights. Empty is flat profile
+func parseProfile(s string) ([24]float64, error) {
+	result := [24]float64{}
+	if strings.TrimSpace(s) == "" {
+		for i := range result {
+			result[i] = 1
+		}
+		return result, nil
+	}
+	parts := strings.Split(s, ",")
+	if len(parts) != 24 {
+		return result, fmt.Errorf("profile must have 24 values, got %d", len(parts))
+	}
+	sum := float64(0)
+	for i, part := range parts {
+		v, errParse := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if errParse != nil || v < 0 {
+			return result, fmt.Errorf("invalid profile value %s", part)
+		}
+		result[i] = v
+		sum += v
+	}
+	if sum <= 0 {
+		return result, fmt.Errorf("profile sum must be positive")
+	}
+	return result, nil
+}
+
+//cachedDayPrices reads valid days between from and to. Missing days are skipped
+func cachedDayPrices(cachedir string, from time.Time, to time.Time) [][]HourPrice {
+	result := [][]HourPrice{}
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		data, errData := ReadCachedVattenfallData(day, cachedir)
+		if errData != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", day.Format("2006-01-02"), errData.Error())
+			continue
+		}
+		prices, errPrices := data.HourPrices()
+		if errPrices != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", day.Format("2006-01-02"), errPrices.Error())
+			continue
+		}
+		result = append(result, prices)
+	}
+	return result
+}
+
+//cmdBacktest is "backtest" subcommand
+func cmdBacktest(args []string) error {
+	fs := flag.NewFlagSet("backtest", flag.ExitOnError)
+	pFrom := fs.String("from", "", "first day YYYY-MM-DD")
+	pTo := fs.String("to", "", "last day YYYY-MM-DD (default today)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	pEnergy := fs.Float64("energy", 10, "energy needed per day, kWh")
+	pPower := fs.Float64("power", 3, "power of controlled device, kW")
+	pProfile := fs.String("profile", "", "baseline load profile, 24 comma separated weights by hour (default flat)")
+	pNight := fs.String("night", "22-07", "hour range of fixed night-time policy")
+	pCheapest := fs.Int("cheapest", 0, "hours of cheapest-N policy (default hours needed by energy and power)")
+	pThreshold := fs.Float64("threshold", 5, "price limit of threshold policy, c/kWh")
+	pWindow := fs.Int("window", 0, "length of contiguous window policy (default hours needed by energy and power)")
+	pFormat := fs.String("format", "text", "output format text or json")
+	fs.Parse(args)
+
+	from, errFrom := ParseDateInFinland(*pFrom)
+	if errFrom != nil {
+		return fmt.Errorf("-from %v", errFrom.Error())
+	}
+	to, errTo := NoonInFinland(time.Now())
+	if *pTo != "" {
+		to, errTo = ParseDateInFinland(*pTo)
+	}
+	if errTo != nil {
+		return fmt.Errorf("-to %v", errTo.Error())
+	}
+	if *pEnergy <= 0 || *pPower <= 0 {
+		return fmt.Errorf("energy and power must be positive")
+	}
+	profile, errProfile := parseProfile(*pProfile)
+	if errProfile != nil {
+		return errProfile
+	}
+	nights, errNight := ParseHourRanges(*pNight)
+	if errNight != nil || len(nights) != 1 {
+		return fmt.Errorf("-night must be one hour range like 22-07")
+	}
+	load := BacktestLoad{EnergyKWh: *pEnergy, PowerKW: *pPower, Profile: profile}
+	cheapestN, windowHours := *pCheapest, *pWindow
+	if cheapestN == 0 {
+		cheapestN = load.hoursNeeded()
+	}
+	if windowHours == 0 {
+		windowHours = load.hoursNeeded()
+	}
+
+	days := cachedDayPrices(*pCacheDirName, from, to)
+	if len(days) == 0 {
+		return fmt.Errorf("no cached days between %s and %s", from.Format("2006-01-02"), to.Format("2006-01-02"))
+	}
+	results := Backtest(days, load, []BacktestPolicy{
+		nightPolicy(nights[0]),
+		cheapestPolicy(cheapestN),
+		thresholdPolicy(*pThreshold),
+		windowPolicy(windowHours),
+	})
+
+	switch *pFormat {
+	case "json":
+		content, errMarshal := json.MarshalIndent(results, "", "  ")
+		if errMarshal != nil {
+			return errMarshal
+		}
+		fmt.Printf("%s\n", content)
+	case "text":
+		fmt.Printf("%d days, %.1f kWh/day with %.1f kW\n", len(days), load.EnergyKWh, load.PowerKW)
+		fmt.Printf("%-14s %10s %10s %10s %10s %8s %10s\n", "policy", "kWh", "cost EUR", "c/kWh", "saves EUR", "saves %", "fallback")
+		for _, r := range results {
+			fmt.Printf("%-14s %10.1f %10.2f %10.2f %10.2f %8.1f %10.1f\n", r.Policy, r.EnergyKWh, r.CostEur, r.AveragePrice, r.SavingsEur, r.SavingsPercent, r.FallbackKWh)
+		}
+	default:
+		return fmt.Errorf("unknown format %s", *pFormat)
+	}
+	return nil
+}
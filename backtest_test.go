@@ -0,0 +1,84 @@
+package main
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+//backtestFixture is two cached days and one missing day between them
+func backtestFixture(t *testing.T) [][]HourPrice {
+	dayA := rampPrices(10, 0)
+	dayA[2], dayA[3], dayA[18] = 1, 1, 30
+	store := testStore(t, "2026-10-17", dayA)
+	writeTestDay(t, store.CacheDir, testDate(t, "2026-10-19"), rampPrices(1, 1))
+	return cachedDayPrices(store.CacheDir, testDate(t, "2026-10-17"), testDate(t, "2026-10-19"))
+}
+
+func TestBacktest(t *testing.T) {
+	days := backtestFixture(t)
+	if len(days) != 2 {
+		t.Fatalf("got %d fixture days, wanted 2", len(days))
+	}
+	load := BacktestLoad{EnergyKWh: 6, PowerKW: 3}
+	load.Profile, _ = parseProfile("")
+	night, _ := ParseHourRanges("22-07")
+	policies := []BacktestPolicy{nightPolicy(night[0]), cheapestPolicy(load.hoursNeeded()), thresholdPolicy(5), windowPolicy(load.hoursNeeded()), thresholdPolicy(0.5)}
+	results := Backtest(days, load, policies)
+
+	wanted := []struct {
+		policy   string
+		cost     float64
+		fallback float64
+	}{
+		{"baseline", 1.355, 0}, //Flat profile, quarter of price sums 242 and 300
+		{"night 22-07", 2.01, 0},
+		{"cheapest 2", 0.15, 0},
+		{"threshold 5", 0.15, 0},
+		{"window 2h", 0.15, 0},
+		{"threshold 0.5", 0.15, 12}, //No hour under threshold, everything from fallback
+	}
+	if len(results) != len(wanted) {
+		t.Fatalf("got %d results", len(results))
+	}
+	for i, w := range wanted {
+		r := results[i]
+		if r.Policy != w.policy || 1e-9 < math.Abs(r.CostEur-w.cost) || 1e-9 < math.Abs(r.FallbackKWh-w.fallback) {
+			t.Errorf("result %+v, wanted %+v", r, w)
+		}
+		if r.Days != 2 || 1e-9 < math.Abs(r.EnergyKWh-12) {
+			t.Errorf("%s used %v kWh in %d days, wanted same energy as baseline", r.Policy, r.EnergyKWh, r.Days)
+		}
+		if 1e-9 < math.Abs(r.SavingsEur-(1.355-w.cost)) {
+			t.Errorf("%s savings %v", r.Policy, r.SavingsEur)
+		}
+	}
+	if 1e-9 < math.Abs(results[2].AveragePrice-1.25) {
+		t.Errorf("average price paid %v, wanted 1.25", results[2].AveragePrice)
+	}
+
+	again := Backtest(backtestFixture(t), load, policies)
+	if !reflect.DeepEqual(results, again) {
+		t.Errorf("backtest is not deterministic")
+	}
+}
+
+func TestBacktestProfile(t *testing.T) {
+	days := backtestFixture(t)[1:]
+	profile := "0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,1,1,0,0,0,0" //Evening use 18-19
+	load := BacktestLoad{EnergyKWh: 6, PowerKW: 3}
+	var errProfile error
+	load.Profile, errProfile = parseProfile(profile)
+	if errProfile != nil {
+		t.Fatal(errProfile)
+	}
+	results := Backtest(days, load, nil)
+	if 1e-9 < math.Abs(results[0].CostEur-1.17) {
+		t.Errorf("baseline cost %v, wanted 3 kWh at 19 and 20 c/kWh", results[0].CostEur)
+	}
+	for _, invalid := range []string{"1,2", "0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0", "a,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0,0"} {
+		if _, errParse := parseProfile(invalid); errParse == nil {
+			t.Errorf("profile %s should be invalid", invalid)
+		}
+	}
+}
@@ -0,0 +1,204 @@
+/*
+Home battery arbitrage planner. Dynamic programming over discretized state of charge finds hourly
+charge/discharge plan that minimizes energy cost with capacity, power and efficiency limits.
+Battery ends horizon at least at starting state of charge, so stored energy is not counted as profit
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"time"
+)
+
+const BATTERYDEFAULT_STEPS = 50
+
+type BatteryConfig struct {
+	CapacityKWh  float64 `json:"capacityKWh"`
+	PowerKW      float64 `json:"powerKW"`      //Max charge and discharge power
+	Efficiency   float64 `json:"efficiency"`   //Round trip efficiency 0-1, split equally to charge and discharge
+	MinSoc       float64 `json:"minSoc"`       //Percent
+	MaxSoc       float64 `json:"maxSoc"`       //Percent, default 100
+	Soc          float64 `json:"soc"`          //Percent, state of charge when not given in request. Default minSoc
+	HorizonHours int     `json:"horizonHours"` //24 or 48 (default, as far as prices are known)
+	Steps        int     `json:"steps,omitempty"`
+}
+
+func (p *BatteryConfig) CheckErr() error {
+	if p.CapacityKWh <= 0 || p.PowerKW <= 0 {
+		return fmt.Errorf("capacityKWh and powerKW must be positive")
+	}
+	if p.Efficiency <= 0 || 1 < p.Efficiency {
+		return fmt.Errorf("efficiency must be over 0 and at most 1")
+	}
+	if p.MinSoc < 0 || 100 < p.maxSoc() || p.maxSoc() <= p.MinSoc {
+		return fmt.Errorf("minSoc must be under maxSoc (default 100), both 0-100")
+	}
+	if p.HorizonHours != 0 && p.HorizonHours != 24 && p.HorizonHours != 48 {
+		return fmt.Errorf("horizonHours must be 24 or 48")
+	}
+	if p.Steps < 0 {
+		return fmt.Errorf("steps must not be negative")
+	}
+	if p.PowerKW+1e-9 < p.stepKWh() {
+		return fmt.Errorf("powerKW %v is less than one soc step %.3f kWh, increase steps", p.PowerKW, p.stepKWh())
+	}
+	return nil
+}
+
+func (p *BatteryConfig) maxSoc() float64 {
+	if p.MaxSoc == 0 {
+		return 100
+	}
+	return p.MaxSoc
+}
+
+func (p *BatteryConfig) steps() int {
+	if p.Steps == 0 {
+		return BATTERYDEFAULT_STEPS
+	}
+	return p.Steps
+}
+
+//stepKWh is energy between discrete soc levels
+func (p *BatteryConfig) stepKWh() float64 {
+	return p.CapacityKWh * (p.maxSoc() - p.MinSoc) / 100 / float64(p.steps())
+}
+
+type BatteryHour struct {
+	Start   time.Time
+	Price   float64
+	GridKWh float64 //Positive is charging from grid, negative is discharging
+	SocKWh  float64 //At end of hour
+}
+
+type BatteryPlan struct {
+	StartSocKWh float64
+	Hours       []BatteryHour
+	CostEur     float64 //Negative is profit
+}
+
+/*
+PlanBattery plans hours from prices. Soc is percent. State of charge is discretized to steps levels
+between min and max, ties are resolved toward idle so result is deterministic
+*/
+func (p *BatteryConfig) PlanBattery(prices []HourPrice, soc float64) (BatteryPlan, error) {
+	errCheck := p.CheckErr()
+	if errCheck != nil {
+		return BatteryPlan{}, errCheck
+	}
+	steps := p.steps()
+	minKWh := p.CapacityKWh * p.MinSoc / 100
+	stepKWh := p.stepKWh()
+	startLevel := int(math.Round((p.CapacityKWh*soc/100 - minKWh) / stepKWh))
+	if startLevel < 0 {
+		startLevel = 0
+	}
+	if steps < startLevel {
+		startLevel = steps
+	}
+	result := BatteryPlan{StartSocKWh: minKWh + float64(startLevel)*stepKWh, Hours: []BatteryHour{}}
+	if len(prices) == 0 {
+		return result, fmt.Errorf("no prices")
+	}
+	maxMove := int(math.Floor(p.PowerKW/stepKWh + 1e-9))
+	etaCharge := math.Sqrt(p.Efficiency)
+	etaDischarge := math.Sqrt(p.Efficiency)
+	gridKWh := func(move int) float64 {
+		if 0 < move {
+			return float64(move) * stepKWh / etaCharge
+		}
+		return float64(move) * stepKWh * etaDischarge
+	}
+	//Moves in preference order for ties: idle first, then smaller moves
+	moves := []int{0}
+	for m := 1; m <= maxMove; m++ {
+		moves = append(moves, m, -m)
+	}
+
+	//value[t][level] is minimum cost from hour t onwards, best[t][level] is move taken
+	value := make([][]float64, len(prices)+1)
+	best := make([][]int, len(prices))
+	value[len(prices)] = make([]float64, steps+1)
+	for level := range value[len(prices)] {
+		if level < startLevel {
+			value[len(prices)][level] = math.Inf(1)
+		}
+	}
+	for t := len(prices) - 1; 0 <= t; t-- {
+		value[t] = make([]float64, steps+1)
+		best[t] = make([]int, steps+1)
+		for level := 0; level <= steps; level++ {
+			value[t][level] = math.Inf(1)
+			for _, move := range moves {
+				next := level + move
+				if next < 0 || steps < next {
+					continue
+				}
+				cost := gridKWh(move)*prices[t].Price + value[t+1][next]
+				if cost < value[t][level]-1e-9 {
+					value[t][level] = cost
+					best[t][level] = move
+				}
+			}
+		}
+	}
+
+	level := startLevel
+	for t, hp := range prices {
+		move := best[t][level]
+		level += move
+		result.Hours = append(result.Hours, BatteryHour{Start: hp.Start, Price: hp.Price, GridKWh: gridKWh(move), SocKWh: minKWh + float64(level)*stepKWh})
+	}
+	result.CostEur = value[0][startLevel] / 100
+	return result, nil
+}
+
+//horizonPrices picks upcoming prices limited to horizon hours, 0 is all known
+func horizonPrices(prices []HourPrice, tNow time.Time, hours int) []HourPrice {
+	result := upcomingPrices(prices, tNow)
+	if 0 < hours && hours < len(result) {
+		result = result[:hours]
+	}
+	return result
+}
+
+//PlanBattery plans from current hour onwards. Soc is percent
+func (p *PriceStore) PlanBattery(conf BatteryConfig, tNow time.Time, soc float64, horizonHours int) (BatteryPlan, error) {
+	horizon, errHorizon := p.ShownHorizon(tNow)
+	if errHorizon != nil {
+		return BatteryPlan{}, errHorizon
+	}
+	return conf.PlanBattery(horizonPrices(horizon, tNow, horizonHours), soc)
+}
+
+//defaultSoc is configured soc, or min soc
+func (p *BatteryConfig) defaultSoc() float64 {
+	if p.Soc == 0 {
+		return p.MinSoc
+	}
+	return p.Soc
+}
+
+//ShowBattery adds arrow strip of battery plan under bars. Failed plan is not fatal for view
+func (p *PriceStore) ShowBattery(pw *PriceView, conf BatteryConfig, tNow time.Time) {
+	plan, errPlan := p.PlanBattery(conf, tNow, conf.defaultSoc(), conf.HorizonHours)
+	if errPlan != nil {
+		fmt.Printf("battery planning failed %v\n", errPlan.Error())
+		return
+	}
+	strip := ChartStrip{Label: "BAT"}
+	for _, hour := range plan.Hours {
+		index, onChart := pw.ChartIndex(hour.Start)
+		if !onChart {
+			continue
+		}
+		switch {
+		case 0 < hour.GridKWh:
+			strip.Marks[index] = STRIPUP
+		case hour.GridKWh < 0:
+			strip.Marks[index] = STRIPDOWN
+		}
+	}
+	pw.Strips = append(pw.Strips, strip)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestBatteryCheckErr(t *testing.T) {
+	invalid := []BatteryConfig{
+		{CapacityKWh: 10, PowerKW: 5, Efficiency: 0.9, MinSoc: 100},             //maxSoc defaults to 100
+		{CapacityKWh: 10, PowerKW: 5, Efficiency: 0.9, MinSoc: 50, MaxSoc: 50},  //No usable capacity
+		{CapacityKWh: 100, PowerKW: 1, Efficiency: 0.9, Steps: 10},              //Step is 10 kWh, over power
+		{CapacityKWh: 10, PowerKW: 5, Efficiency: 0.9, MinSoc: 20, MaxSoc: 120}, //Over 100
+	}
+	for _, conf := range invalid {
+		if conf.CheckErr() == nil {
+			t.Errorf("%+v should be invalid", conf)
+		}
+		_, errPlan := conf.PlanBattery([]HourPrice{{Price: 1}}, 50)
+		if errPlan == nil {
+			t.Errorf("%+v should not be planned", conf)
+		}
+	}
+	valid := BatteryConfig{CapacityKWh: 10, PowerKW: 0.2, Efficiency: 0.9, MinSoc: 0, Steps: 50}
+	if errCheck := valid.CheckErr(); errCheck != nil {
+		t.Errorf("power of exactly one step should be valid, %v", errCheck)
+	}
+}
+
+func TestBatteryArbitrage(t *testing.T) {
+	prices := rampPrices(10, 0)
+	prices[3], prices[18] = 1, 40
+	store := testStore(t, "2026-10-19", prices)
+	day, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	conf := BatteryConfig{CapacityKWh: 10, PowerKW: 5, Efficiency: 0.81, MinSoc: 10, MaxSoc: 90, Steps: 16}
+	plan, errPlan := conf.PlanBattery(day, 10)
+	if errPlan != nil {
+		t.Fatal(errPlan)
+	}
+	if plan.Hours[3].GridKWh <= 0 || 0 <= plan.Hours[18].GridKWh {
+		t.Errorf("should charge at 03 and discharge at 18, got %v and %v", plan.Hours[3].GridKWh, plan.Hours[18].GridKWh)
+	}
+	if 0 <= plan.CostEur {
+		t.Errorf("arbitrage should be profitable, cost %v", plan.CostEur)
+	}
+	last := plan.Hours[len(plan.Hours)-1]
+	if last.SocKWh < plan.StartSocKWh-1e-9 {
+		t.Errorf("battery ends at %v kWh, below start %v", last.SocKWh, plan.StartSocKWh)
+	}
+}
@@ -0,0 +1,112 @@
+/*
+Optional JSON configuration file (-config) for things that do not fit on command line
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+)
+
+type Config struct {
+	Appliances []ApplianceProfile `json:"appliances"`
+	Relays     []RelayConfig      `json:"relays"`
+	SgReady    *SgReadyConfig     `json:"sgReady,omitempty"` //Optional SG-Ready heat pump control
+	Plugs      []PlugConfig       `json:"plugs"`
+	Modbus     []ModbusDevice     `json:"modbus"`
+	Ocpp       *OcppConfig        `json:"ocpp,omitempty"`    //Optional OCPP central system for one charger
+	Battery    *BatteryConfig     `json:"battery,omitempty"` //Optional home battery arbitrage planning
+	Heating    *HeatingConfig     `json:"heating,omitempty"` //Optional space heating planning
+	Webhooks   []WebhookConfig    `json:"webhooks"`
+	WebhookLog string             `json:"webhookLog,omitempty"` //Fired webhook events, default webhooks.log in cache dir
+	Notify     *NotifyConfig      `json:"notify,omitempty"`     //Optional price alerts and evening digest
+	Tariff     *TariffConfig      `json:"tariff,omitempty"`     //Optional transfer, tax and fees for total price
+}
+
+func LoadConfig(filename string) (Config, error) {
+	result := Config{}
+	if len(filename) == 0 {
+		return result, nil
+	}
+	content, errRead := os.ReadFile(filename)
+	if errRead != nil {
+		return result, fmt.Errorf("config read error %v", errRead.Error())
+	}
+	errUnmarshal := json.Unmarshal(content, &result)
+	if errUnmarshal != nil {
+		return result, fmt.Errorf("config %v parse error %v", filename, errUnmarshal.Error())
+	}
+	for i, appliance := range result.Appliances {
+		errCheck := appliance.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config appliance #%d %v", i, errCheck.Error())
+		}
+	}
+	for i, relay := range result.Relays {
+		errCheck := relay.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config relay #%d %v", i, errCheck.Error())
+		}
+	}
+	for i, plug := range result.Plugs {
+		errCheck := plug.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config plug #%d %v", i, errCheck.Error())
+		}
+	}
+	for i, device := range result.Modbus {
+		errCheck := device.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config modbus #%d %v", i, errCheck.Error())
+		}
+	}
+	hookNames := make(map[string]bool)
+	for i, hook := range result.Webhooks {
+		errCheck := hook.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config webhook #%d %v", i, errCheck.Error())
+		}
+		if hookNames[hook.Name] {
+			return result, fmt.Errorf("config webhook #%d name %s used twice, name is key of fired log", i, hook.Name)
+		}
+		hookNames[hook.Name] = true
+	}
+	if result.Tariff != nil {
+		errCheck := result.Tariff.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config tariff %v", errCheck.Error())
+		}
+	}
+	if result.Notify != nil {
+		errCheck := result.Notify.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config notify %v", errCheck.Error())
+		}
+	}
+	if result.Ocpp != nil {
+		errCheck := result.Ocpp.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config ocpp %v", errCheck.Error())
+		}
+	}
+	if result.Battery != nil {
+		errCheck := result.Battery.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config battery %v", errCheck.Error())
+		}
+	}
+	if result.Heating != nil {
+		errCheck := result.Heating.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config heating %v", errCheck.Error())
+		}
+	}
+	if result.SgReady != nil {
+		errCheck := result.SgReady.CheckErr()
+		if errCheck != nil {
+			return result, fmt.Errorf("config sgReady %v", errCheck.Error())
+		}
+	}
+	return result, nil
+}
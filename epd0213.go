@@ -249,10 +249,18 @@ func (p *Epd0213) waitIdle() error {
 		}
 		time.Sleep(time.Millisecond * 100)
 	}
+	metrics.EpdIdleTimeout()
 	return fmt.Errorf("waitIdle timeout after %v", time.Since(tStart))
 }
 
 func (p *Epd0213) Draw(blackData []byte, redData []byte) error {
+	tStart := time.Now()
+	err := p.draw(blackData, redData)
+	metrics.EpdRefreshDone(time.Since(tStart), err)
+	return err
+}
+
+func (p *Epd0213) draw(blackData []byte, redData []byte) error {
 	if len(blackData) == 0 && len(redData) == 0 {
 		return p.clear()
 	}
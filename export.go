@@ -0,0 +1,170 @@
+/*
+export subcommand. Writes cached prices as CSV or JSON, spreadsheets can not read raw vattenfall files
+Reads only cache, use fetch subcommand first for filling cache
+*/
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"time"
+)
+
+const EXPORTDEFAULT_AREA = "FI" //Vattenfall data has empty price area, it is finnish area
+
+type ExportRow struct {
+	Local string  `json:"local"` //RFC3339 in finnish time
+	Utc   string  `json:"utc"`
+	Price float64 `json:"price"`
+	Unit  string  `json:"unit"`
+	Area  string  `json:"area"`
+}
+
+//aggregateKeys are functions for grouping start of period
+var aggregateKeys = map[string]func(t time.Time) time.Time{
+	"hourly": func(t time.Time) time.Time { return t },
+	"daily": func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	},
+	"monthly": func(t time.Time) time.Time {
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	},
+}
+
+//ExportRows collects rows of shown prices from cache, averaged over aggregation periods. Missing days are skipped
+func ExportRows(cachedir string, vat *VatMode, from time.Time, to time.Time, aggregation string) ([]ExportRow, error) {
+	keyFunc, haveKey := aggregateKeys[aggregation]
+	if !haveKey {
+		return nil, fmt.Errorf("unknown aggregation %s", aggregation)
+	}
+
+	result := []ExportRow{}
+	var periodStart time.Time
+	var sum float64
+	count := 0
+	area := EXPORTDEFAULT_AREA
+	flush := func() {
+		if count == 0 {
+			return
+		}
+		result = append(result, ExportRow{
+			Local: periodStart.Format(time.RFC3339),
+			Utc:   periodStart.UTC().Format(time.RFC3339),
+			Price: sum / float64(count),
+			Unit:  VATTENFALLEXPECTED_UNIT,
+			Area:  area,
+		})
+		sum, count = 0, 0
+	}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		data, errData := ReadCachedVattenfallData(day, cachedir)
+		if errData != nil {
+			fmt.Fprintf(os.Stderr, "skipping %s: %v\n", day.Format("2006-01-02"), errData.Error())
+			continue
+		}
+		data = vat.Convert(data)
+		prices, errPrices := data.HourPrices()
+		if errPrices != nil {
+			return nil, errPrices
+		}
+		if data[0].PriceArea != "" {
+			area = data[0].PriceArea
+		}
+		for _, hp := range prices {
+			key := keyFunc(hp.Start)
+			if !key.Equal(periodStart) {
+				flush()
+				periodStart = key
+			}
+			sum += hp.Price
+			count++
+		}
+	}
+	flush()
+	return result, nil
+}
+
+func writeExportCsv(w io.Writer, rows []ExportRow) error {
+	cw := csv.NewWriter(w)
+	cw.Write([]string{"local", "utc", "price", "unit", "area"})
+	for _, row := range rows {
+		cw.Write([]string{row.Local, row.Utc, strconv.FormatFloat(row.Price, 'f', -1, 64), row.Unit, row.Area})
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeExportJson(w io.Writer, rows []ExportRow) error {
+	content, errMarshal := json.MarshalIndent(rows, "", "  ")
+	if errMarshal != nil {
+		return errMarshal
+	}
+	_, errWrite := w.Write(append(content, '\n'))
+	return errWrite
+}
+
+func cmdExport(args []string) error {
+	fs := flag.NewFlagSet("export", flag.ExitOnError)
+	pFrom := fs.String("from", "", "first day YYYY-MM-DD")
+	pTo := fs.String("to", "", "last day YYYY-MM-DD (default today)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	pFormat := fs.String("format", "csv", "output format csv or json")
+	pAggregate := fs.String("aggregate", "hourly", "hourly, daily (average) or monthly (average)")
+	pOutputFileName := fs.String("o", "", "output filename, default stdout")
+	getVatMode := vatFlags(fs)
+	fs.Parse(args)
+
+	from, errFrom := ParseDateInFinland(*pFrom)
+	if errFrom != nil {
+		return fmt.Errorf("-from %v", errFrom.Error())
+	}
+	to, errTo := NoonInFinland(time.Now())
+	if *pTo != "" {
+		to, errTo = ParseDateInFinland(*pTo)
+	}
+	if errTo != nil {
+		return fmt.Errorf("-to %v", errTo.Error())
+	}
+	if to.Before(from) {
+		return fmt.Errorf("-to %s is before -from %s", to.Format("2006-01-02"), *pFrom)
+	}
+
+	writers := map[string]func(w io.Writer, rows []ExportRow) error{
+		"csv":  writeExportCsv,
+		"json": writeExportJson,
+	}
+	writeFunc, haveWriter := writers[*pFormat]
+	if !haveWriter {
+		return fmt.Errorf("unknown format %s", *pFormat)
+	}
+
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		return errVat
+	}
+
+	rows, errRows := ExportRows(*pCacheDirName, vatMode, from, to, *pAggregate)
+	if errRows != nil {
+		return errRows
+	}
+
+	if *pOutputFileName == "" {
+		return writeFunc(os.Stdout, rows)
+	}
+	out, errCreate := os.Create(*pOutputFileName)
+	if errCreate != nil {
+		return fmt.Errorf("err creating %v %v", *pOutputFileName, errCreate.Error())
+	}
+	errWrite := writeFunc(out, rows)
+	if errWrite != nil {
+		out.Close()
+		return fmt.Errorf("error writing %v err=%v", *pOutputFileName, errWrite.Error())
+	}
+	return out.Close()
+}
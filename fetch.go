@@ -0,0 +1,84 @@
+/*
+fetch subcommand, fills cache for date range. Days already cached and valid are skipped,
+so interrupted run can be resumed just by running same command again
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"time"
+)
+
+type fetchSummary struct {
+	Total      int
+	Cached     int
+	Downloaded int
+	Failed     []string
+}
+
+func cmdFetch(args []string) error {
+	fs := flag.NewFlagSet("fetch", flag.ExitOnError)
+	pFrom := fs.String("from", "", "first day YYYY-MM-DD")
+	pTo := fs.String("to", "", "last day YYYY-MM-DD (default today)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	pDelay := fs.Duration("delay", 2*time.Second, "wait between downloads, do not hammer vattenfall")
+	pMaxFailures := fs.Int("maxfailures", 5, "stop after this many failed downloads in row")
+	fs.Parse(args)
+
+	from, errFrom := ParseDateInFinland(*pFrom)
+	if errFrom != nil {
+		return fmt.Errorf("-from %v", errFrom.Error())
+	}
+	to, errTo := NoonInFinland(time.Now())
+	if *pTo != "" {
+		to, errTo = ParseDateInFinland(*pTo)
+	}
+	if errTo != nil {
+		return fmt.Errorf("-to %v", errTo.Error())
+	}
+	if to.Before(from) {
+		return fmt.Errorf("-to %s is before -from %s", *pTo, *pFrom)
+	}
+
+	waitClock()
+
+	summary := fetchSummary{}
+	failuresInRow := 0
+	days := int(to.Sub(from).Hours()/24) + 1
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		summary.Total++
+		date := day.Format("2006-01-02")
+		_, errCached := ReadCachedVattenfallData(day, *pCacheDirName)
+		if errCached == nil {
+			summary.Cached++
+			continue
+		}
+		if 0 < summary.Downloaded+len(summary.Failed) {
+			time.Sleep(*pDelay)
+		}
+		data, errGet := GetVattenfallData(day, *pCacheDirName)
+		if errGet == nil {
+			errGet = data.CheckErr(day)
+		}
+		if errGet != nil {
+			fmt.Printf("[%d/%d] %s failed %v\n", summary.Total, days, date, errGet.Error())
+			summary.Failed = append(summary.Failed, date)
+			failuresInRow++
+			if *pMaxFailures <= failuresInRow {
+				fmt.Printf("stopping after %d failures in row\n", failuresInRow)
+				break
+			}
+			continue
+		}
+		failuresInRow = 0
+		summary.Downloaded++
+		fmt.Printf("[%d/%d] %s downloaded\n", summary.Total, days, date)
+	}
+
+	fmt.Printf("days %d: already cached %d, downloaded %d, failed %d\n", days, summary.Cached, summary.Downloaded, len(summary.Failed))
+	if 0 < len(summary.Failed) {
+		return fmt.Errorf("failed days %v, run again to retry", summary.Failed)
+	}
+	return nil
+}
@@ -51,21 +51,11 @@ func GetVattenfallData(t time.Time, cachedir string) (VattenfallData, error) {
 
 	fmt.Printf("Getting cached data from file %s\n", cachefilename)
 	if fileExists(cachefilename) { //Good, get that
-		content, readErr := os.ReadFile(cachefilename)
-		if readErr == nil {
-			errUnmarshal := json.Unmarshal(content, &result)
-			if errUnmarshal == nil {
-				contentErr := result.CheckErr(t)
-				if contentErr == nil {
-					return result, nil //Got valid data from cache
-				}
-				fmt.Printf("Content error %v\n", contentErr.Error())
-			} else {
-				fmt.Printf("Unmarshall err from cache %v\n", errUnmarshal.Error())
-			}
-		} else {
-			fmt.Printf("Read error %v\n", readErr.Error())
+		cached, cacheErr := ReadCachedVattenfallData(t, cachedir)
+		if cacheErr == nil {
+			return cached, nil //Got valid data from cache
 		}
+		fmt.Printf("%v\n", cacheErr.Error())
 	}
 	fmt.Printf("Downloading fresh from vattenfall\n")
 	content, dlErr := downloadVattenfall(t)
@@ -94,6 +84,49 @@ func GetVattenfallData(t time.Time, cachedir string) (VattenfallData, error) {
 	return result, nil
 }
 
+//ReadCachedVattenfallData reads valid day from cache only, never downloads
+func ReadCachedVattenfallData(t time.Time, cachedir string) (VattenfallData, error) {
+	result := VattenfallData{}
+	cachefilenameonly, errName := vattenfallCacheFileName(t)
+	if errName != nil {
+		return result, fmt.Errorf("timerr %v", errName.Error())
+	}
+	content, readErr := os.ReadFile(path.Join(cachedir, cachefilenameonly))
+	if readErr != nil {
+		return result, fmt.Errorf("Read error %v", readErr.Error())
+	}
+	errUnmarshal := json.Unmarshal(content, &result)
+	if errUnmarshal != nil {
+		return result, fmt.Errorf("Unmarshall err from cache %v", errUnmarshal.Error())
+	}
+	contentErr := result.CheckErr(t)
+	if contentErr != nil {
+		return result, fmt.Errorf("Content error %v", contentErr.Error())
+	}
+	return result, nil
+}
+
+//CachedDays lists days (noon in finnish time) that have file in cache, sorted
+func CachedDays(cachedir string) ([]time.Time, error) {
+	loc, errloc := time.LoadLocation("Europe/Helsinki")
+	if errloc != nil {
+		return nil, errloc
+	}
+	entries, errDir := os.ReadDir(cachedir)
+	if errDir != nil {
+		return nil, fmt.Errorf("error reading cache dir %v err %v", cachedir, errDir.Error())
+	}
+	result := []time.Time{}
+	for _, entry := range entries { //ReadDir returns sorted by name
+		day, errParse := time.ParseInLocation("2006-01-02.json", entry.Name(), loc)
+		if errParse != nil || entry.IsDir() {
+			continue
+		}
+		result = append(result, day.Add(12*time.Hour)) //No DST changes at midnight
+	}
+	return result, nil
+}
+
 func vattenfallCacheFileName(t time.Time) (string, error) {
 	lt, ltErr := TimeInFinland(t)
 	if ltErr != nil {
@@ -113,7 +146,14 @@ func vattenfallUrl(t time.Time) (string, error) {
 	), nil
 }
 
+//downloadVattenfall counts transport and http errors as failed fetch. Day that is not published yet is not failure, store retries it often
 func downloadVattenfall(t time.Time) ([]byte, error) {
+	content, err := downloadVattenfallRaw(t)
+	metrics.FetchDone(err)
+	return content, err
+}
+
+func downloadVattenfallRaw(t time.Time) ([]byte, error) {
 	url, urlErr := vattenfallUrl(t)
 	if urlErr != nil {
 		return nil, urlErr
@@ -134,6 +174,9 @@ func downloadVattenfall(t time.Time) ([]byte, error) {
 	}
 
 	defer response.Body.Close()
+	if response.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %v %v", url, response.Status)
+	}
 	return io.ReadAll(response.Body)
 }
 
@@ -185,13 +228,43 @@ func (p *VattenfallData) GetHourPrices(t time.Time) ([24]float64, error) {
 	return result, nil
 }
 
+//HourPrice is price of one hour starting at Start, in VATTENFALLEXPECTED_UNIT
+type HourPrice struct {
+	Start time.Time
+	Price float64
+}
+
+//HourPrices converts to timestamped prices in finnish time
+func (p *VattenfallData) HourPrices() ([]HourPrice, error) {
+	loc, errloc := time.LoadLocation("Europe/Helsinki")
+	if errloc != nil {
+		return nil, errloc
+	}
+	result := make([]HourPrice, len(*p))
+	for i, itm := range *p {
+		t, errParse := time.ParseInLocation("2006-01-02T15:04:05", itm.TimeStamp, loc)
+		if errParse != nil {
+			return nil, fmt.Errorf("invalid timestamp %s err %v", itm.TimeStamp, errParse.Error())
+		}
+		result[i] = HourPrice{Start: t, Price: itm.Value}
+	}
+	return result, nil
+}
+
 /*
 Main routine for downloading from vattenfall net or cache
 */
 
 func GetPriceViewVattenfall(tNow time.Time, cachedir string) (PriceView, error) {
+	return getPriceView(tNow, func(t time.Time) (VattenfallData, error) {
+		return GetVattenfallData(t, cachedir)
+	})
+}
+
+//getPriceView picks today and tomorrow (or yesterday if tomorrow is not published yet) from data getter
+func getPriceView(tNow time.Time, getData func(t time.Time) (VattenfallData, error)) (PriceView, error) {
 	result := PriceView{}
-	dataNow, errDataNow := GetVattenfallData(tNow, cachedir)
+	dataNow, errDataNow := getData(tNow)
 	if errDataNow != nil {
 		return PriceView{}, fmt.Errorf("Todays data fail %v", errDataNow)
 	}
@@ -203,14 +276,16 @@ func GetPriceViewVattenfall(tNow time.Time, cachedir string) (PriceView, error)
 
 	tTomorrow := tNow.Add(time.Hour * 24)
 
-	dataTomorrow, errDataTomorrow := GetVattenfallData(tTomorrow, cachedir)
+	dataTomorrow, errDataTomorrow := getData(tTomorrow)
 	if errDataTomorrow == nil { //Good, today is first, then tomorrow
 		tomorrowPrices, errTomorrowPrices := dataTomorrow.GetHourPrices(tTomorrow)
 		if errTomorrowPrices == nil {
 			return PriceView{
 				FirstName: FinnishWeekDayName(tNow),
+				FirstDay:  tNow,
 				FirstData: nowPrices,
 				LastName:  FinnishWeekDayName(tTomorrow),
+				LastDay:   tTomorrow,
 				LastData:  tomorrowPrices}, nil
 		}
 		fmt.Printf("tomorrow data err %v, get yesterday\n", errTomorrowPrices.Error())
@@ -218,7 +293,7 @@ func GetPriceViewVattenfall(tNow time.Time, cachedir string) (PriceView, error)
 	//tomorrow prices are not available yet. use yesterday and today
 	fmt.Printf("tomorrow prices not available yet\n")
 	tYesteday := tNow.Add(-time.Hour * 24)
-	dataYesterday, errDataYesterday := GetVattenfallData(tYesteday, cachedir)
+	dataYesterday, errDataYesterday := getData(tYesteday)
 	if errDataYesterday != nil {
 		return result, errDataYesterday
 	}
@@ -229,8 +304,10 @@ func GetPriceViewVattenfall(tNow time.Time, cachedir string) (PriceView, error)
 	}
 	return PriceView{
 		FirstName: FinnishWeekDayName(tYesteday),
+		FirstDay:  tYesteday,
 		FirstData: yesterdayPrices,
 		LastName:  FinnishWeekDayName(tNow),
+		LastDay:   tNow,
 		LastData:  nowPrices}, nil
 
 }
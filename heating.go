@@ -0,0 +1,290 @@
+/*
+Space heating optimizer. House is simple first-order RC model (heat loss coefficient and thermal mass)
+with on/off heater. Dynamic programming over discretized indoor temperature chooses heater hours that
+minimize cost while indoor temperature stays in comfort band. Outdoor temperature series is read from file or local endpoint
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HEATINGDEFAULT_STEPS = 100    //Temperature levels in comfort band
+	HEATINGMARGIN        = 5.0    //Celsius outside band that is still modeled
+	HEATINGPENALTY       = 1000.0 //Cents per degree-hour outside comfort band
+)
+
+type HeatingConfig struct {
+	HeatLossWPerK      float64  `json:"heatLossWPerK"`      //Heat loss coefficient (UA) of house
+	ThermalMassKWhPerK float64  `json:"thermalMassKWhPerK"` //Heat capacity of house
+	HeaterPowerKW      float64  `json:"heaterPowerKW"`
+	MinTemp            float64  `json:"minTemp"` //Comfort band, celsius
+	MaxTemp            float64  `json:"maxTemp"`
+	IndoorTemp         *float64 `json:"indoorTemp,omitempty"`  //Indoor temperature when not given in request. Default middle of band
+	OutdoorFile        string   `json:"outdoorFile,omitempty"` //Outdoor temperature series as csv (time,temp) or json
+	OutdoorUrl         string   `json:"outdoorUrl,omitempty"`  //Local endpoint giving same format as file
+	OutdoorTemp        float64  `json:"outdoorTemp"`           //Used when there is no series
+	HorizonHours       int      `json:"horizonHours"`          //24 or 48 (default, as far as prices are known)
+	Steps              int      `json:"steps,omitempty"`
+}
+
+func (p *HeatingConfig) CheckErr() error {
+	if p.HeatLossWPerK <= 0 || p.ThermalMassKWhPerK <= 0 || p.HeaterPowerKW <= 0 {
+		return fmt.Errorf("heatLossWPerK, thermalMassKWhPerK and heaterPowerKW must be positive")
+	}
+	if p.MaxTemp <= p.MinTemp {
+		return fmt.Errorf("maxTemp must be over minTemp")
+	}
+	if p.HorizonHours != 0 && p.HorizonHours != 24 && p.HorizonHours != 48 {
+		return fmt.Errorf("horizonHours must be 24 or 48")
+	}
+	if p.OutdoorFile != "" && p.OutdoorUrl != "" {
+		return fmt.Errorf("give only outdoorFile or outdoorUrl")
+	}
+	if p.Steps < 0 {
+		return fmt.Errorf("steps must not be negative")
+	}
+	return nil
+}
+
+//NextTemp is indoor temperature after dt with constant outdoor temperature and heater state. Exact solution of first-order model
+func (p *HeatingConfig) NextTemp(indoor float64, outdoor float64, on bool, dt time.Duration) float64 {
+	ua := p.HeatLossWPerK / 1000 //kW/K
+	tau := p.ThermalMassKWhPerK / ua
+	steady := outdoor
+	if on {
+		steady += p.HeaterPowerKW / ua
+	}
+	return steady + (indoor-steady)*math.Exp(-dt.Hours()/tau)
+}
+
+func (p *HeatingConfig) defaultIndoor() float64 {
+	if p.IndoorTemp != nil {
+		return *p.IndoorTemp
+	}
+	return (p.MinTemp + p.MaxTemp) / 2
+}
+
+type OutdoorSample struct {
+	Time time.Time `json:"time"`
+	Temp float64   `json:"temp"`
+}
+
+//ParseOutdoorSeries parses json array of {"time","temp"} or csv lines time,temp. Time is RFC3339. Result is sorted by time
+func ParseOutdoorSeries(content []byte) ([]OutdoorSample, error) {
+	result := []OutdoorSample{}
+	if bytes.HasPrefix(bytes.TrimSpace(content), []byte("[")) {
+		errParse := json.Unmarshal(content, &result)
+		if errParse != nil {
+			return nil, fmt.Errorf("outdoor series json err %v", errParse.Error())
+		}
+	} else {
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		for lineNumber := 1; scanner.Scan(); lineNumber++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "time") {
+				continue
+			}
+			fields := strings.Split(line, ",")
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("outdoor series line %d invalid, use time,temp", lineNumber)
+			}
+			t, errTime := time.Parse(time.RFC3339, strings.TrimSpace(fields[0]))
+			temp, errTemp := strconv.ParseFloat(strings.TrimSpace(fields[1]), 64)
+			if errTime != nil || errTemp != nil {
+				return nil, fmt.Errorf("outdoor series line %d invalid time or temperature", lineNumber)
+			}
+			result = append(result, OutdoorSample{Time: t, Temp: temp})
+		}
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Time.Before(result[j].Time) })
+	return result, nil
+}
+
+//LoadOutdoorSeries reads series from configured file or url. No source is empty series
+func (p *HeatingConfig) LoadOutdoorSeries() ([]OutdoorSample, error) {
+	if p.OutdoorFile != "" {
+		content, errRead := os.ReadFile(p.OutdoorFile)
+		if errRead != nil {
+			return nil, fmt.Errorf("outdoor file err %v", errRead.Error())
+		}
+		return ParseOutdoorSeries(content)
+	}
+	if p.OutdoorUrl != "" {
+		client := &http.Client{Timeout: 10 * time.Second}
+		resp, errGet := client.Get(p.OutdoorUrl)
+		if errGet != nil {
+			return nil, fmt.Errorf("outdoor url err %v", errGet.Error())
+		}
+		defer resp.Body.Close()
+		content, errRead := io.ReadAll(resp.Body)
+		if errRead != nil {
+			return nil, fmt.Errorf("outdoor url read err %v", errRead.Error())
+		}
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("outdoor url %v", resp.Status)
+		}
+		return ParseOutdoorSeries(content)
+	}
+	return []OutdoorSample{}, nil
+}
+
+//outdoorAt is latest sample at or before t, first sample before series and fallback without series
+func outdoorAt(series []OutdoorSample, t time.Time, fallback float64) float64 {
+	if len(series) == 0 {
+		return fallback
+	}
+	result := series[0].Temp
+	for _, sample := range series {
+		if t.Before(sample.Time) {
+			break
+		}
+		result = sample.Temp
+	}
+	return result
+}
+
+type HeatingHour struct {
+	Start       time.Time
+	Price       float64
+	On          bool
+	OutdoorTemp float64
+	IndoorTemp  float64 //At end of hour, from model
+}
+
+type HeatingPlan struct {
+	StartTemp float64
+	Hours     []HeatingHour
+	EnergyKWh float64
+	CostEur   float64
+	Comfort   bool //Stays in comfort band whole horizon
+}
+
+/*
+PlanHeating chooses heater state for each hour. Temperatures are snapped to grid of steps levels over comfort band
+(and HEATINGMARGIN outside it). Hours ending outside band are penalized, so plan exists even when band can not be kept
+*/
+func (p *HeatingConfig) PlanHeating(prices []HourPrice, series []OutdoorSample, startTemp float64) (HeatingPlan, error) {
+	result := HeatingPlan{StartTemp: startTemp, Hours: []HeatingHour{}, Comfort: true}
+	errCheck := p.CheckErr()
+	if errCheck != nil {
+		return result, errCheck
+	}
+	if len(prices) == 0 {
+		return result, fmt.Errorf("no prices")
+	}
+	steps := p.Steps
+	if steps == 0 {
+		steps = HEATINGDEFAULT_STEPS
+	}
+	stepTemp := (p.MaxTemp - p.MinTemp) / float64(steps)
+	low := p.MinTemp - HEATINGMARGIN
+	levels := int(math.Round((p.MaxTemp+HEATINGMARGIN-low)/stepTemp)) + 1
+	levelOf := func(temp float64) int {
+		level := int(math.Round((temp - low) / stepTemp))
+		if level < 0 {
+			return 0
+		}
+		if levels-1 < level {
+			return levels - 1
+		}
+		return level
+	}
+	tempOf := func(level int) float64 { return low + float64(level)*stepTemp }
+	penalty := func(temp float64) float64 {
+		if temp < p.MinTemp-1e-9 {
+			return (p.MinTemp - temp) * HEATINGPENALTY
+		}
+		if p.MaxTemp+1e-9 < temp {
+			return (temp - p.MaxTemp) * HEATINGPENALTY
+		}
+		return 0
+	}
+	outdoor := make([]float64, len(prices))
+	for t, hp := range prices {
+		outdoor[t] = outdoorAt(series, hp.Start, p.OutdoorTemp)
+	}
+
+	//value[t][level] is minimum cost from hour t onwards. Ties go to heater off
+	value := make([][]float64, len(prices)+1)
+	heat := make([][]bool, len(prices))
+	value[len(prices)] = make([]float64, levels)
+	for t := len(prices) - 1; 0 <= t; t-- {
+		value[t] = make([]float64, levels)
+		heat[t] = make([]bool, levels)
+		for level := 0; level < levels; level++ {
+			offTemp := p.NextTemp(tempOf(level), outdoor[t], false, time.Hour)
+			onTemp := p.NextTemp(tempOf(level), outdoor[t], true, time.Hour)
+			offCost := penalty(offTemp) + value[t+1][levelOf(offTemp)]
+			onCost := p.HeaterPowerKW*prices[t].Price + penalty(onTemp) + value[t+1][levelOf(onTemp)]
+			value[t][level] = offCost
+			if onCost < offCost-1e-9 {
+				value[t][level] = onCost
+				heat[t][level] = true
+			}
+		}
+	}
+
+	//Simulate chosen states with exact model, decisions are taken from nearest grid level
+	temp := startTemp
+	for t, hp := range prices {
+		on := heat[t][levelOf(temp)]
+		//Snapping error may lead just outside band, switch if other state is better for comfort
+		other := p.NextTemp(temp, outdoor[t], !on, time.Hour)
+		if penalty(other) < penalty(p.NextTemp(temp, outdoor[t], on, time.Hour)) {
+			on = !on
+		}
+		temp = p.NextTemp(temp, outdoor[t], on, time.Hour)
+		if on {
+			result.EnergyKWh += p.HeaterPowerKW
+			result.CostEur += p.HeaterPowerKW * hp.Price / 100
+		}
+		if 0 < penalty(temp) {
+			result.Comfort = false
+		}
+		result.Hours = append(result.Hours, HeatingHour{Start: hp.Start, Price: hp.Price, On: on, OutdoorTemp: outdoor[t], IndoorTemp: temp})
+	}
+	return result, nil
+}
+
+//PlanHeating plans from current hour onwards with configured outdoor series
+func (p *PriceStore) PlanHeating(conf HeatingConfig, tNow time.Time, startTemp float64, horizonHours int) (HeatingPlan, error) {
+	horizon, errHorizon := p.ShownHorizon(tNow)
+	if errHorizon != nil {
+		return HeatingPlan{}, errHorizon
+	}
+	series, errSeries := conf.LoadOutdoorSeries()
+	if errSeries != nil {
+		return HeatingPlan{}, errSeries
+	}
+	return conf.PlanHeating(horizonPrices(horizon, tNow, horizonHours), series, startTemp)
+}
+
+//ShowHeating adds strip of heater hours under bars. Failed plan is not fatal for view
+func (p *PriceStore) ShowHeating(pw *PriceView, conf HeatingConfig, tNow time.Time) {
+	plan, errPlan := p.PlanHeating(conf, tNow, conf.defaultIndoor(), conf.HorizonHours)
+	if errPlan != nil {
+		fmt.Printf("heating planning failed %v\n", errPlan.Error())
+		return
+	}
+	strip := ChartStrip{Label: "HEAT"}
+	for _, hour := range plan.Hours {
+		index, onChart := pw.ChartIndex(hour.Start)
+		if onChart && hour.On {
+			strip.Marks[index] = STRIPHIGH
+		}
+	}
+	pw.Strips = append(pw.Strips, strip)
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+func testHeatingConfig() HeatingConfig {
+	return HeatingConfig{HeatLossWPerK: 150, ThermalMassKWhPerK: 15, HeaterPowerKW: 6, MinTemp: 20, MaxTemp: 22.5, OutdoorTemp: -5}
+}
+
+func TestHeatingNextTemp(t *testing.T) {
+	conf := testHeatingConfig()
+	//Time constant is 15 kWh/K / 0.15 kW/K = 100 h
+	cooled := conf.NextTemp(21, -5, false, 100*time.Hour)
+	if wanted := -5 + 26*math.Exp(-1); 1e-9 < math.Abs(cooled-wanted) {
+		t.Errorf("cooled to %v, wanted %v", cooled, wanted)
+	}
+	//Heater 6 kW keeps steady state 40 K over outdoor
+	if steady := conf.NextTemp(35, -5, true, time.Hour); 1e-9 < math.Abs(steady-35) {
+		t.Errorf("steady state moved to %v", steady)
+	}
+}
+
+func TestHeatingCheckErr(t *testing.T) {
+	conf := testHeatingConfig()
+	conf.Steps = -1
+	if conf.CheckErr() == nil {
+		t.Errorf("negative steps should be invalid")
+	}
+	_, errPlan := conf.PlanHeating([]HourPrice{{Price: 1}}, nil, 21)
+	if errPlan == nil {
+		t.Errorf("negative steps should not be planned")
+	}
+}
+
+func TestHeatingPlan(t *testing.T) {
+	prices := rampPrices(20, 0)
+	for hour := 0; hour < 6; hour++ {
+		prices[hour] = 2
+	}
+	store := testStore(t, "2026-10-19", prices)
+	day, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	conf := testHeatingConfig()
+	plan, errPlan := conf.PlanHeating(day, nil, 21)
+	if errPlan != nil {
+		t.Fatal(errPlan)
+	}
+	if !plan.Comfort {
+		t.Errorf("plan should keep comfort band")
+	}
+	cheapOn := 0
+	for i, hour := range plan.Hours {
+		if hour.IndoorTemp < conf.MinTemp-1e-6 || conf.MaxTemp+1e-6 < hour.IndoorTemp {
+			t.Errorf("hour %d indoor %v outside band", i, hour.IndoorTemp)
+		}
+		if hour.On && i < 6 {
+			cheapOn++
+		}
+	}
+	if cheapOn < 3 {
+		t.Errorf("heat should be stored during cheap night, only %d cheap hours on", cheapOn)
+	}
+	//Same plan again, planner is deterministic
+	again, _ := conf.PlanHeating(day, nil, 21)
+	if again.CostEur != plan.CostEur || again.EnergyKWh != plan.EnergyKWh {
+		t.Errorf("planner is not deterministic")
+	}
+	flat, _ := conf.PlanHeating(day[6:], nil, 21)
+	if plan.CostEur/plan.EnergyKWh >= flat.CostEur/flat.EnergyKWh {
+		t.Errorf("average price paid %v should be below flat price %v", plan.CostEur/plan.EnergyKWh, flat.CostEur/flat.EnergyKWh)
+	}
+}
+
+func TestHeatingOutdoorSeries(t *testing.T) {
+	csv := "time,temp\n2026-10-19T03:00:00+03:00,-10\n2026-10-19T00:00:00+03:00,-2\n"
+	series, errCsv := ParseOutdoorSeries([]byte(csv))
+	if errCsv != nil {
+		t.Fatal(errCsv)
+	}
+	fromJson, errJson := ParseOutdoorSeries([]byte(`[{"time":"2026-10-19T00:00:00+03:00","temp":-2},{"time":"2026-10-19T03:00:00+03:00","temp":-10}]`))
+	if errJson != nil {
+		t.Fatal(errJson)
+	}
+	for _, s := range [][]OutdoorSample{series, fromJson} {
+		if outdoorAt(s, testClock(t, "02:00"), 0) != -2 || outdoorAt(s, testClock(t, "04:00"), 0) != -10 {
+			t.Errorf("unexpected series %v", s)
+		}
+	}
+	if _, errBad := ParseOutdoorSeries([]byte("2026-10-19T00:00:00Z;5\n")); errBad == nil {
+		t.Errorf("invalid line should fail")
+	}
+
+	//Colder series needs more heating energy
+	store := testStore(t, "2026-10-19", rampPrices(10, 0))
+	day, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	conf := testHeatingConfig()
+	mild, _ := conf.PlanHeating(day, []OutdoorSample{{Time: day[0].Start, Temp: 5}}, 21)
+	cold, _ := conf.PlanHeating(day, []OutdoorSample{{Time: day[0].Start, Temp: -20}}, 21)
+	if cold.EnergyKWh <= mild.EnergyKWh {
+		t.Errorf("cold %v kWh should be more than mild %v kWh", cold.EnergyKWh, mild.EnergyKWh)
+	}
+}
@@ -0,0 +1,230 @@
+/*
+iCalendar (.ics) feed of cheapest contiguous window of each day and expensive periods (red hours on chart)
+Phones can subscribe to it from server (/calendar.ics) or file written by ics subcommand
+*/
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	ICSDEFAULT_WINDOW = 3  //Hours
+	ICSDEFAULT_DAYS   = 7  //Past days included in feed
+	ICSMAX_DAYS       = 31 //Limit of past days, missing days are downloaded
+	ICSLINELEN        = 75
+	ICSTZID           = "Europe/Helsinki"
+)
+
+//icsTimezone is VTIMEZONE of finnish time, EU rules since 1996
+var icsTimezone = []string{
+	"BEGIN:VTIMEZONE",
+	"TZID:" + ICSTZID,
+	"BEGIN:DAYLIGHT",
+	"TZOFFSETFROM:+0200",
+	"TZOFFSETTO:+0300",
+	"TZNAME:EEST",
+	"DTSTART:19960331T030000",
+	"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU",
+	"END:DAYLIGHT",
+	"BEGIN:STANDARD",
+	"TZOFFSETFROM:+0300",
+	"TZOFFSETTO:+0200",
+	"TZNAME:EET",
+	"DTSTART:19961027T040000",
+	"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU",
+	"END:STANDARD",
+	"END:VTIMEZONE",
+}
+
+type CalendarEvent struct {
+	Uid         string
+	Start       time.Time
+	End         time.Time
+	Summary     string
+	Description string
+}
+
+//icsEscape escapes TEXT value
+func icsEscape(s string) string {
+	return strings.NewReplacer("\\", "\\\\", ";", "\\;", ",", "\\,", "\n", "\\n").Replace(s)
+}
+
+//icsFold splits line to max ICSLINELEN octets, without breaking utf-8 characters
+func icsFold(line string) string {
+	var buf bytes.Buffer
+	count := 0
+	for _, r := range line {
+		size := len(string(r))
+		if ICSLINELEN < count+size {
+			buf.WriteString("\r\n ")
+			count = 1
+		}
+		buf.WriteRune(r)
+		count += size
+	}
+	return buf.String()
+}
+
+func icsLocalTime(t time.Time) string {
+	lt, _ := TimeInFinland(t)
+	return lt.Format("20060102T150405")
+}
+
+//clockRange is like 02–05 in finnish time
+func clockRange(start time.Time, end time.Time) string {
+	ls, _ := TimeInFinland(start)
+	le, _ := TimeInFinland(end)
+	return fmt.Sprintf("%02d–%02d", ls.Hour(), le.Hour())
+}
+
+func averagePrice(prices []HourPrice) float64 {
+	sum := float64(0)
+	for _, hp := range prices {
+		sum += hp.Price
+	}
+	return sum / float64(len(prices))
+}
+
+//DayCalendarEvents has cheapest window and expensive periods (n most expensive hours, merged when contiguous) of one day
+func DayCalendarEvents(day []HourPrice, windowHours int, allowed []HourRange, expensiveN int) []CalendarEvent {
+	result := []CalendarEvent{}
+	window, errWindow := CheapestWindow(day, windowHours, allowed)
+	if errWindow == nil {
+		result = append(result, CalendarEvent{
+			Uid:         fmt.Sprintf("cheap-%s-%dh@spotview", window.Start.UTC().Format("20060102T15"), windowHours),
+			Start:       window.Start,
+			End:         window.End(),
+			Summary:     "cheap electricity " + clockRange(window.Start, window.End()),
+			Description: fmt.Sprintf("cheapest %dh window, average %.2f %s", windowHours, window.Average, VATTENFALLEXPECTED_UNIT),
+		})
+	}
+
+	flags := expensiveFlags(day, expensiveN)
+	for first := 0; first < len(day); first++ {
+		if !flags[first] {
+			continue
+		}
+		last := first
+		for last+1 < len(day) && flags[last+1] && day[last+1].Start.Equal(day[last].Start.Add(time.Hour)) {
+			last++
+		}
+		start, end := day[first].Start, day[last].Start.Add(time.Hour)
+		result = append(result, CalendarEvent{
+			Uid:         fmt.Sprintf("expensive-%s@spotview", start.UTC().Format("20060102T15")),
+			Start:       start,
+			End:         end,
+			Summary:     "expensive electricity " + clockRange(start, end),
+			Description: fmt.Sprintf("average %.2f %s", averagePrice(day[first:last+1]), VATTENFALLEXPECTED_UNIT),
+		})
+		first = last
+	}
+	return result
+}
+
+//WriteIcs writes calendar. Stamp is DTSTAMP of events
+func WriteIcs(w io.Writer, events []CalendarEvent, stamp time.Time) error {
+	lines := []string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//spotview//price calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Electricity prices",
+		"X-WR-TIMEZONE:" + ICSTZID,
+		"REFRESH-INTERVAL;VALUE=DURATION:PT1H",
+		"X-PUBLISHED-TTL:PT1H",
+	}
+	lines = append(lines, icsTimezone...)
+	for _, event := range events {
+		lines = append(lines,
+			"BEGIN:VEVENT",
+			"UID:"+event.Uid,
+			"DTSTAMP:"+stamp.UTC().Format("20060102T150405Z"),
+			"DTSTART;TZID="+ICSTZID+":"+icsLocalTime(event.Start),
+			"DTEND;TZID="+ICSTZID+":"+icsLocalTime(event.End),
+			"SUMMARY:"+icsEscape(event.Summary),
+			"DESCRIPTION:"+icsEscape(event.Description),
+			"TRANSP:TRANSPARENT",
+			"END:VEVENT")
+	}
+	lines = append(lines, "END:VCALENDAR")
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(icsFold(line))
+		buf.WriteString("\r\n")
+	}
+	_, errWrite := w.Write(buf.Bytes())
+	return errWrite
+}
+
+//CalendarEvents collects events from past days until tomorrow (if published). Missing days are skipped
+func (p *PriceStore) CalendarEvents(tNow time.Time, pastDays int, windowHours int, allowed []HourRange, expensiveN int) ([]CalendarEvent, error) {
+	result := []CalendarEvent{}
+	haveDays := false
+	for d := -pastDays; d <= 1; d++ {
+		day, errDay := p.ShownDayPrices(tNow.AddDate(0, 0, d))
+		if errDay != nil {
+			continue
+		}
+		haveDays = true
+		result = append(result, DayCalendarEvents(day, windowHours, allowed, expensiveN)...)
+	}
+	if !haveDays {
+		return result, fmt.Errorf("no prices for calendar")
+	}
+	return result, nil
+}
+
+//cmdIcs is "ics" subcommand
+func cmdIcs(args []string) error {
+	fs := flag.NewFlagSet("ics", flag.ExitOnError)
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	pHours := fs.Int("hours", ICSDEFAULT_WINDOW, "length of cheapest window in hours")
+	pAllowed := fs.String("allowed", "", "allowed hour ranges of window like 22-07,10-14 (default all day)")
+	pNumberOfExpensiveHours := fs.Int("e", EXPENSIVEHOURCOUNT, "number of expensive hours per 24h")
+	pDays := fs.Int("days", ICSDEFAULT_DAYS, fmt.Sprintf("past days included, at most %d", ICSMAX_DAYS))
+	pOutputFileName := fs.String("o", "", "output filename, default stdout")
+	getVatMode := vatFlags(fs)
+	fs.Parse(args)
+
+	if *pDays < 0 || ICSMAX_DAYS < *pDays {
+		return fmt.Errorf("-days must be 0-%d", ICSMAX_DAYS)
+	}
+	allowed, errAllowed := ParseHourRanges(*pAllowed)
+	if errAllowed != nil {
+		return errAllowed
+	}
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		return errVat
+	}
+	waitClock()
+	tNow := time.Now()
+	store := NewPriceStore(*pCacheDirName)
+	store.Vat = vatMode
+	events, errEvents := store.CalendarEvents(tNow, *pDays, *pHours, allowed, *pNumberOfExpensiveHours)
+	if errEvents != nil {
+		return errEvents
+	}
+	if *pOutputFileName == "" {
+		return WriteIcs(os.Stdout, events, tNow)
+	}
+	out, errCreate := os.Create(*pOutputFileName)
+	if errCreate != nil {
+		return fmt.Errorf("err creating %v %v", *pOutputFileName, errCreate.Error())
+	}
+	errWrite := WriteIcs(out, events, tNow)
+	if errWrite != nil {
+		out.Close()
+		return fmt.Errorf("error writing %v err=%v", *pOutputFileName, errWrite.Error())
+	}
+	return out.Close()
+}
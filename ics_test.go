@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestIcsFold(t *testing.T) {
+	tests := []string{
+		"SUMMARY:short",
+		"SUMMARY:" + strings.Repeat("a", 66) + "–bc", //Dash would be split at 75 octets
+		"DESCRIPTION:" + strings.Repeat("äö–", 40),
+		strings.Repeat("x", 200),
+	}
+	for _, line := range tests {
+		folded := icsFold(line)
+		physical := strings.Split(folded, "\r\n")
+		for i, part := range physical {
+			if ICSLINELEN < len(part) || !utf8.ValidString(part) {
+				t.Errorf("line %d of %q is %d octets or splits character: %q", i, line, len(part), part)
+			}
+			if 0 < i && !strings.HasPrefix(part, " ") {
+				t.Errorf("continuation %q does not start with space", part)
+			}
+		}
+		if strings.ReplaceAll(folded, "\r\n ", "") != line {
+			t.Errorf("unfolded %q differs from %q", folded, line)
+		}
+	}
+	if physical := strings.Split(icsFold(tests[1]), "\r\n"); len(physical[0]) != 74 || physical[1] != " –bc" {
+		t.Errorf("dash was not moved to next line: %q", physical)
+	}
+}
+
+func TestIcsEscape(t *testing.T) {
+	got := icsEscape("a;b,c\\d\nnext")
+	if got != `a\;b\,c\\d\nnext` {
+		t.Errorf("escaped %q", got)
+	}
+}
+
+func TestDayCalendarEvents(t *testing.T) {
+	prices := rampPrices(10, 0)
+	prices[2], prices[3], prices[4] = 1, 1, 1
+	prices[8], prices[9], prices[18] = 30, 30, 30
+	store := testStore(t, "2026-10-19", prices)
+	day, _ := store.DayPrices(testDate(t, "2026-10-19"))
+
+	events := DayCalendarEvents(day, 3, nil, 3)
+	wanted := []struct {
+		summary    string
+		start, end string
+	}{
+		{"cheap electricity 02–05", "02:00", "05:00"},
+		{"expensive electricity 08–10", "08:00", "10:00"}, //Contiguous hours are merged
+		{"expensive electricity 18–19", "18:00", "19:00"},
+	}
+	if len(events) != len(wanted) {
+		t.Fatalf("%d events %v", len(events), events)
+	}
+	for i, w := range wanted {
+		if events[i].Summary != w.summary || !events[i].Start.Equal(testClock(t, w.start)) || !events[i].End.Equal(testClock(t, w.end)) {
+			t.Errorf("event %d %q %v-%v, wanted %q", i, events[i].Summary, events[i].Start, events[i].End, w.summary)
+		}
+	}
+	if !strings.HasPrefix(events[1].Description, "average 30.00") {
+		t.Errorf("description %q", events[1].Description)
+	}
+}
+
+func TestWriteIcs(t *testing.T) {
+	events := []CalendarEvent{{
+		Uid:         "cheap-20261018T23-3h@spotview",
+		Start:       testClock(t, "02:00"),
+		End:         testClock(t, "05:00"),
+		Summary:     "cheap electricity 02–05",
+		Description: "cheapest 3h window, average 1.00",
+	}}
+	var buf bytes.Buffer
+	errWrite := WriteIcs(&buf, events, time.Date(2026, 10, 19, 9, 0, 0, 0, time.UTC))
+	if errWrite != nil {
+		t.Fatal(errWrite)
+	}
+	golden := strings.Join([]string{
+		"BEGIN:VCALENDAR",
+		"VERSION:2.0",
+		"PRODID:-//spotview//price calendar//EN",
+		"CALSCALE:GREGORIAN",
+		"METHOD:PUBLISH",
+		"X-WR-CALNAME:Electricity prices",
+		"X-WR-TIMEZONE:Europe/Helsinki",
+		"REFRESH-INTERVAL;VALUE=DURATION:PT1H",
+		"X-PUBLISHED-TTL:PT1H",
+		"BEGIN:VTIMEZONE",
+		"TZID:Europe/Helsinki",
+		"BEGIN:DAYLIGHT",
+		"TZOFFSETFROM:+0200",
+		"TZOFFSETTO:+0300",
+		"TZNAME:EEST",
+		"DTSTART:19960331T030000",
+		"RRULE:FREQ=YEARLY;BYMONTH=3;BYDAY=-1SU",
+		"END:DAYLIGHT",
+		"BEGIN:STANDARD",
+		"TZOFFSETFROM:+0300",
+		"TZOFFSETTO:+0200",
+		"TZNAME:EET",
+		"DTSTART:19961027T040000",
+		"RRULE:FREQ=YEARLY;BYMONTH=10;BYDAY=-1SU",
+		"END:STANDARD",
+		"END:VTIMEZONE",
+		"BEGIN:VEVENT",
+		"UID:cheap-20261018T23-3h@spotview",
+		"DTSTAMP:20261019T090000Z",
+		"DTSTART;TZID=Europe/Helsinki:20261019T020000",
+		"DTEND;TZID=Europe/Helsinki:20261019T050000",
+		"SUMMARY:cheap electricity 02–05",
+		`DESCRIPTION:cheapest 3h window\, average 1.00`,
+		"TRANSP:TRANSPARENT",
+		"END:VEVENT",
+		"END:VCALENDAR",
+	}, "\r\n") + "\r\n"
+	if buf.String() != golden {
+		t.Errorf("output differs from golden:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,197 @@
+/*
+Writes hour prices to InfluxDB v2 compatible /api/v2/write endpoint in line protocol.
+Backfills all valid days from cache dir on first run, after that new cached days are pushed.
+Days already written are kept in state file at cache dir
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	INFLUXSTATEFILE     = "influxwritten.txt"
+	INFLUXBATCHLINES    = 5000
+	INFLUXSYNC_INTERVAL = 15 * time.Minute
+	INFLUXSOURCE        = "vattenfall"
+)
+
+type InfluxWriter struct {
+	Url         string //Base url like http://localhost:8086
+	Org         string
+	Bucket      string
+	Token       string
+	Measurement string
+	CacheDir    string
+	Tariff      *TariffConfig //Optional, total price of hour is written as total field
+
+	written map[string]bool //Dates already written
+	client  *http.Client
+}
+
+func (p *InfluxWriter) stateFileName() string {
+	return path.Join(p.CacheDir, INFLUXSTATEFILE)
+}
+
+func (p *InfluxWriter) loadState() error {
+	p.written = make(map[string]bool)
+	f, errOpen := os.Open(p.stateFileName())
+	if os.IsNotExist(errOpen) {
+		return nil //First run
+	}
+	if errOpen != nil {
+		return errOpen
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		date := strings.TrimSpace(scanner.Text())
+		if date != "" {
+			p.written[date] = true
+		}
+	}
+	return scanner.Err()
+}
+
+func (p *InfluxWriter) saveState() error {
+	dates := make([]string, 0, len(p.written))
+	for date := range p.written {
+		dates = append(dates, date)
+	}
+	sort.Strings(dates)
+	return os.WriteFile(p.stateFileName(), []byte(strings.Join(dates, "\n")+"\n"), 0666)
+}
+
+//influxEscape escapes tag keys and values
+func influxEscape(s string) string {
+	return strings.NewReplacer(",", "\\,", "=", "\\=", " ", "\\ ").Replace(s)
+}
+
+//InfluxLines creates line protocol lines of one day. Precision is seconds
+func (p *InfluxWriter) InfluxLines(prices []HourPrice) []string {
+	result := make([]string, len(prices))
+	for i, hp := range prices {
+		fields := fmt.Sprintf("price=%v", hp.Price)
+		if p.Tariff != nil {
+			cost := p.Tariff.HourCost(hp, nil) //Cache has prices of source
+			fields += fmt.Sprintf(",total=%v", cost.Total)
+		}
+		result[i] = fmt.Sprintf("%s,source=%s,unit=%s %s %d",
+			influxEscape(p.Measurement), INFLUXSOURCE, influxEscape(VATTENFALLEXPECTED_UNIT), fields, hp.Start.Unix())
+	}
+	return result
+}
+
+func (p *InfluxWriter) post(lines []string) error {
+	if p.client == nil {
+		p.client = &http.Client{Timeout: 60 * time.Second}
+	}
+	query := url.Values{}
+	query.Set("org", p.Org)
+	query.Set("bucket", p.Bucket)
+	query.Set("precision", "s")
+	writeUrl := strings.TrimSuffix(p.Url, "/") + "/api/v2/write?" + query.Encode()
+
+	req, errReq := http.NewRequest("POST", writeUrl, bytes.NewBufferString(strings.Join(lines, "\n")+"\n"))
+	if errReq != nil {
+		return fmt.Errorf("influx request err %v", errReq.Error())
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Token "+p.Token)
+	}
+	resp, errDo := p.client.Do(req)
+	if errDo != nil {
+		return fmt.Errorf("influx write err %v", errDo.Error())
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("influx write failed %v %s", resp.Status, body)
+	}
+	return nil
+}
+
+//Sync writes all cached days that are not written yet
+func (p *InfluxWriter) Sync() error {
+	if p.written == nil {
+		errState := p.loadState()
+		if errState != nil {
+			return fmt.Errorf("influx state file err %v", errState.Error())
+		}
+	}
+	days, errDays := CachedDays(p.CacheDir)
+	if errDays != nil {
+		return errDays
+	}
+
+	lines := []string{}
+	pending := []string{}
+	for _, day := range days {
+		date := day.Format("2006-01-02")
+		if p.written[date] {
+			continue
+		}
+		data, errData := ReadCachedVattenfallData(day, p.CacheDir)
+		if errData != nil {
+			fmt.Printf("influx skipping %s: %v\n", date, errData.Error())
+			continue
+		}
+		prices, errPrices := data.HourPrices()
+		if errPrices != nil {
+			fmt.Printf("influx skipping %s: %v\n", date, errPrices.Error())
+			continue
+		}
+		lines = append(lines, p.InfluxLines(prices)...)
+		pending = append(pending, date)
+
+		if INFLUXBATCHLINES <= len(lines) {
+			errFlush := p.flush(lines, pending)
+			if errFlush != nil {
+				return errFlush
+			}
+			lines, pending = []string{}, []string{}
+		}
+	}
+	return p.flush(lines, pending)
+}
+
+func (p *InfluxWriter) flush(lines []string, dates []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	errPost := p.post(lines)
+	if errPost != nil {
+		return errPost
+	}
+	for _, date := range dates {
+		p.written[date] = true
+	}
+	fmt.Printf("influx wrote %v days\n", len(dates))
+	return p.saveState()
+}
+
+//Run syncs at hour boundaries and between. Never returns
+func (p *InfluxWriter) Run() {
+	for {
+		errSync := p.Sync()
+		if errSync != nil {
+			fmt.Printf("influx sync failed %v\n", errSync.Error())
+		}
+		wait := untilNextHour(time.Now())
+		if INFLUXSYNC_INTERVAL < wait {
+			wait = INFLUXSYNC_INTERVAL
+		}
+		time.Sleep(wait + 5*time.Second) //After others have fetched
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestInfluxSync(t *testing.T) {
+	received := []string{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/api/v2/write" || r.URL.Query().Get("bucket") != "prices" || r.Header.Get("Authorization") != "Token secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		received = append(received, strings.Split(strings.TrimSpace(string(body)), "\n")...)
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	store := testStore(t, "2026-10-18", rampPrices(1, 1), rampPrices(2, 1))
+	writer := InfluxWriter{Url: srv.URL, Org: "home", Bucket: "prices", Token: "secret", Measurement: "spot price", CacheDir: store.CacheDir,
+		Tariff: &TariffConfig{Transfer: 4, TaxClass: 0}}
+	errSync := writer.Sync()
+	if errSync != nil {
+		t.Fatal(errSync)
+	}
+	if len(received) != 48 {
+		t.Fatalf("got %d lines, wanted 48", len(received))
+	}
+	if !strings.HasPrefix(received[0], `spot\ price,source=vattenfall,unit=snt/kWh price=1,total=`) {
+		t.Errorf("unexpected line %s", received[0])
+	}
+
+	//Second sync writes only new day
+	received = []string{}
+	writeTestDay(t, store.CacheDir, testDate(t, "2026-10-20"), rampPrices(3, 1))
+	errSync = writer.Sync()
+	if errSync != nil {
+		t.Fatal(errSync)
+	}
+	if len(received) != 24 {
+		t.Fatalf("got %d lines after new day, wanted 24", len(received))
+	}
+	state, _ := os.ReadFile(path.Join(store.CacheDir, INFLUXSTATEFILE))
+	if string(state) != "2026-10-18\n2026-10-19\n2026-10-20\n" {
+		t.Errorf("unexpected state file %q", state)
+	}
+}
@@ -0,0 +1,191 @@
+/*
+Prometheus/OpenMetrics text exporter. Counters are collected from fetch and e-paper code,
+price gauges are calculated from store at scrape time
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+)
+
+type Metrics struct {
+	mutex sync.Mutex
+
+	FetchAttempts uint64
+	FetchFailures uint64
+
+	EpdRefreshes       uint64
+	EpdFailures        uint64
+	EpdIdleTimeouts    uint64
+	EpdLastDurationSec float64
+	EpdLastRefresh     time.Time
+}
+
+//Global, collected from everywhere
+var metrics Metrics
+
+func (p *Metrics) FetchDone(err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.FetchAttempts++
+	if err != nil {
+		p.FetchFailures++
+	}
+}
+
+func (p *Metrics) EpdRefreshDone(dur time.Duration, err error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.EpdRefreshes++
+	p.EpdLastDurationSec = dur.Seconds()
+	if err != nil {
+		p.EpdFailures++
+		return
+	}
+	p.EpdLastRefresh = time.Now()
+}
+
+func (p *Metrics) EpdIdleTimeout() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.EpdIdleTimeouts++
+}
+
+func (p *Metrics) snapshot() Metrics {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return Metrics{
+		FetchAttempts:      p.FetchAttempts,
+		FetchFailures:      p.FetchFailures,
+		EpdRefreshes:       p.EpdRefreshes,
+		EpdFailures:        p.EpdFailures,
+		EpdIdleTimeouts:    p.EpdIdleTimeouts,
+		EpdLastDurationSec: p.EpdLastDurationSec,
+		EpdLastRefresh:     p.EpdLastRefresh,
+	}
+}
+
+type metricFamily struct {
+	help       string
+	metricType string
+	samples    []string
+}
+
+//metricsWriter groups samples by metric name, exposition format requires families to be contiguous
+type metricsWriter struct {
+	order    []string
+	families map[string]*metricFamily
+}
+
+func (p *metricsWriter) write(name string, metricType string, help string, labels string, value float64) {
+	if p.families == nil {
+		p.families = make(map[string]*metricFamily)
+	}
+	family, haveFamily := p.families[name]
+	if !haveFamily {
+		family = &metricFamily{help: help, metricType: metricType}
+		p.families[name] = family
+		p.order = append(p.order, name)
+	}
+	if labels != "" {
+		labels = "{" + labels + "}"
+	}
+	family.samples = append(family.samples, fmt.Sprintf("%s%s %v", name, labels, value))
+}
+
+func (p *metricsWriter) Bytes() []byte {
+	var buf bytes.Buffer
+	for _, name := range p.order {
+		family := p.families[name]
+		fmt.Fprintf(&buf, "# HELP %s %s\n# TYPE %s %s\n", name, family.help, name, family.metricType)
+		for _, sample := range family.samples {
+			buf.WriteString(sample + "\n")
+		}
+	}
+	return buf.Bytes()
+}
+
+func (p *metricsWriter) dayGauges(dayLabel string, prices []HourPrice, expensiveHourCount int) {
+	if len(prices) == 0 {
+		return
+	}
+	values := priceValues(prices)
+	minPrice, maxPrice, sum := values[0], values[0], float64(0)
+	for _, v := range values {
+		if v < minPrice {
+			minPrice = v
+		}
+		if maxPrice < v {
+			maxPrice = v
+		}
+		sum += v
+	}
+	date := prices[0].Start.Format("2006-01-02")
+	dayLabels := fmt.Sprintf("day=%q,date=%q", dayLabel, date)
+	for _, hp := range prices {
+		p.write("spotview_hour_price", "gauge", "Spot price of hour in c/kWh", fmt.Sprintf("%s,hour=\"%02d\"", dayLabels, hp.Start.Hour()), hp.Price)
+	}
+	p.write("spotview_day_price_min", "gauge", "Minimum hour price of day in c/kWh", dayLabels, minPrice)
+	p.write("spotview_day_price_max", "gauge", "Maximum hour price of day in c/kWh", dayLabels, maxPrice)
+	p.write("spotview_day_price_avg", "gauge", "Average hour price of day in c/kWh", dayLabels, sum/float64(len(values)))
+	p.write("spotview_expensive_threshold", "gauge", "Price limit of expensive (red) hours in c/kWh", dayLabels, maxNvaluesOnThreshold(values, expensiveHourCount))
+}
+
+func (p *SpotServer) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	tNow := p.Now()
+	mw := metricsWriter{}
+
+	today, errToday := p.Store.ShownDayPrices(tNow)
+	if errToday == nil {
+		expensive := expensiveFlags(today, p.ExpensiveHourCount)
+		for i, hp := range today {
+			if !tNow.Before(hp.Start) && tNow.Before(hp.Start.Add(time.Hour)) {
+				mw.write("spotview_price", "gauge", "Spot price of current hour in c/kWh", "", hp.Price)
+				expensiveValue := float64(0)
+				if expensive[i] {
+					expensiveValue = 1
+				}
+				mw.write("spotview_expensive", "gauge", "1 if current hour is expensive (red) hour", "", expensiveValue)
+			}
+		}
+		mw.dayGauges("today", today, p.ExpensiveHourCount)
+	}
+	tomorrow, errTomorrow := p.Store.ShownDayPrices(tNow.Add(time.Hour * 24))
+	if errTomorrow == nil {
+		mw.dayGauges("tomorrow", tomorrow, p.ExpensiveHourCount)
+	}
+
+	for _, day := range []struct {
+		label string
+		t     time.Time
+	}{{"today", tNow}, {"tomorrow", tNow.Add(time.Hour * 24)}} {
+		name, errName := vattenfallCacheFileName(day.t)
+		if errName != nil {
+			continue
+		}
+		info, errStat := os.Stat(path.Join(p.Store.CacheDir, name))
+		if errStat == nil {
+			mw.write("spotview_cache_age_seconds", "gauge", "Age of cached price file", fmt.Sprintf("day=%q", day.label), tNow.Sub(info.ModTime()).Seconds())
+		}
+	}
+
+	m := metrics.snapshot()
+	mw.write("spotview_fetch_attempts_total", "counter", "Download attempts from vattenfall", "", float64(m.FetchAttempts))
+	mw.write("spotview_fetch_failures_total", "counter", "Failed downloads from vattenfall", "", float64(m.FetchFailures))
+	mw.write("spotview_epd_refreshes_total", "counter", "E-paper refresh attempts", "", float64(m.EpdRefreshes))
+	mw.write("spotview_epd_failures_total", "counter", "Failed e-paper refreshes", "", float64(m.EpdFailures))
+	mw.write("spotview_epd_idle_timeouts_total", "counter", "E-paper busy pin wait timeouts", "", float64(m.EpdIdleTimeouts))
+	mw.write("spotview_epd_refresh_duration_seconds", "gauge", "Duration of latest e-paper refresh", "", m.EpdLastDurationSec)
+	if !m.EpdLastRefresh.IsZero() {
+		mw.write("spotview_epd_last_refresh_timestamp_seconds", "gauge", "Time of latest successful e-paper refresh", "", float64(m.EpdLastRefresh.Unix()))
+	}
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(mw.Bytes())
+}
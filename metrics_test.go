@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//metricsSamples parses exposition text to sample values by name with labels. Checks that families are contiguous and typed
+func metricsSamples(t *testing.T, text string) map[string]string {
+	t.Helper()
+	result := make(map[string]string)
+	typed := make(map[string]bool)
+	current := ""
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		fields := strings.Fields(line)
+		if strings.HasPrefix(line, "# HELP ") {
+			if typed[fields[2]] {
+				t.Errorf("family %s is not contiguous", fields[2])
+			}
+			current = fields[2]
+			continue
+		}
+		if strings.HasPrefix(line, "# TYPE ") {
+			if fields[2] != current || (fields[3] != "gauge" && fields[3] != "counter") {
+				t.Errorf("unexpected type line %q", line)
+			}
+			typed[current] = true
+			continue
+		}
+		if len(fields) != 2 {
+			t.Fatalf("invalid sample %q", line)
+		}
+		name := strings.SplitN(fields[0], "{", 2)[0]
+		if name != current || !typed[name] {
+			t.Errorf("sample %q outside its family", line)
+		}
+		result[fields[0]] = fields[1]
+	}
+	return result
+}
+
+func TestMetrics(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	srv := NewSpotServer(store, 6)
+	srv.Now = func() time.Time { return testClock(t, "21:30") }
+
+	before := metrics.snapshot()
+	metrics.FetchDone(nil)
+	metrics.FetchDone(fmt.Errorf("GET 503"))
+	metrics.FetchDone(nil)
+
+	recorder := httptest.NewRecorder()
+	srv.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/metrics", nil))
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Errorf("content type %q", recorder.Header().Get("Content-Type"))
+	}
+	samples := metricsSamples(t, recorder.Body.String())
+
+	wanted := map[string]string{
+		"spotview_price":     "22",
+		"spotview_expensive": "1",
+		`spotview_hour_price{day="today",date="2026-10-19",hour="03"}`: "4",
+		`spotview_day_price_max{day="today",date="2026-10-19"}`:        "24",
+		`spotview_day_price_avg{day="today",date="2026-10-19"}`:        "12.5",
+		`spotview_expensive_threshold{day="today",date="2026-10-19"}`:  "19",
+		"spotview_fetch_attempts_total":                                fmt.Sprintf("%v", before.FetchAttempts+3),
+		"spotview_fetch_failures_total":                                fmt.Sprintf("%v", before.FetchFailures+1),
+	}
+	for name, value := range wanted {
+		if samples[name] != value {
+			t.Errorf("%s is %q, wanted %q", name, samples[name], value)
+		}
+	}
+	for name := range samples {
+		if strings.Contains(name, `day="tomorrow"`) {
+			t.Errorf("tomorrow gauge %s without tomorrow prices", name)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+/*
+Minimal Modbus TCP client. Only holding register read (0x03) and single register write (0x06)
+Works over any net.Conn so it can be run against in-process server
+*/
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	MODBUS_READHOLDING  byte = 0x03
+	MODBUS_WRITESINGLE  byte = 0x06
+	MODBUS_EXCEPTIONBIT byte = 0x80
+
+	MODBUS_TIMEOUT = 5 * time.Second
+)
+
+type ModbusClient struct {
+	conn        net.Conn
+	UnitId      byte
+	transaction uint16
+}
+
+func DialModbus(address string, unitId byte) (ModbusClient, error) {
+	conn, errDial := net.DialTimeout("tcp", address, MODBUS_TIMEOUT)
+	if errDial != nil {
+		return ModbusClient{}, fmt.Errorf("modbus dial %v err %v", address, errDial.Error())
+	}
+	return ModbusClient{conn: conn, UnitId: unitId}, nil
+}
+
+func (p *ModbusClient) Close() error {
+	return p.conn.Close()
+}
+
+//request sends pdu and returns response pdu. Exception response is returned as error
+func (p *ModbusClient) request(pdu []byte) ([]byte, error) {
+	p.transaction++
+	frame := make([]byte, 7, 7+len(pdu))
+	binary.BigEndian.PutUint16(frame[0:2], p.transaction)
+	binary.BigEndian.PutUint16(frame[2:4], 0) //Protocol id
+	binary.BigEndian.PutUint16(frame[4:6], uint16(len(pdu)+1))
+	frame[6] = p.UnitId
+	frame = append(frame, pdu...)
+
+	p.conn.SetDeadline(time.Now().Add(MODBUS_TIMEOUT))
+	_, errWrite := p.conn.Write(frame)
+	if errWrite != nil {
+		return nil, fmt.Errorf("modbus write err %v", errWrite.Error())
+	}
+
+	header := make([]byte, 7)
+	_, errHeader := io.ReadFull(p.conn, header)
+	if errHeader != nil {
+		return nil, fmt.Errorf("modbus read err %v", errHeader.Error())
+	}
+	length := binary.BigEndian.Uint16(header[4:6])
+	if length < 2 || 254 < length {
+		return nil, fmt.Errorf("modbus invalid length %v", length)
+	}
+	resp := make([]byte, length-1)
+	_, errBody := io.ReadFull(p.conn, resp)
+	if errBody != nil {
+		return nil, fmt.Errorf("modbus read err %v", errBody.Error())
+	}
+	if binary.BigEndian.Uint16(header[0:2]) != p.transaction {
+		return nil, fmt.Errorf("modbus transaction id mismatch")
+	}
+	if resp[0] == pdu[0]|MODBUS_EXCEPTIONBIT {
+		if len(resp) < 2 {
+			return nil, fmt.Errorf("modbus exception without code")
+		}
+		return nil, fmt.Errorf("modbus exception code %d", resp[1])
+	}
+	if resp[0] != pdu[0] {
+		return nil, fmt.Errorf("modbus function code mismatch %d", resp[0])
+	}
+	return resp, nil
+}
+
+//WriteRegister writes single holding register. Address is zero based
+func (p *ModbusClient) WriteRegister(address uint16, value uint16) error {
+	pdu := make([]byte, 5)
+	pdu[0] = MODBUS_WRITESINGLE
+	binary.BigEndian.PutUint16(pdu[1:3], address)
+	binary.BigEndian.PutUint16(pdu[3:5], value)
+	resp, errReq := p.request(pdu)
+	if errReq != nil {
+		return errReq
+	}
+	if len(resp) != 5 || binary.BigEndian.Uint16(resp[1:3]) != address || binary.BigEndian.Uint16(resp[3:5]) != value {
+		return fmt.Errorf("modbus write register %d not confirmed", address)
+	}
+	return nil
+}
+
+//ReadRegisters reads count holding registers starting from address
+func (p *ModbusClient) ReadRegisters(address uint16, count uint16) ([]uint16, error) {
+	pdu := make([]byte, 5)
+	pdu[0] = MODBUS_READHOLDING
+	binary.BigEndian.PutUint16(pdu[1:3], address)
+	binary.BigEndian.PutUint16(pdu[3:5], count)
+	resp, errReq := p.request(pdu)
+	if errReq != nil {
+		return nil, errReq
+	}
+	if len(resp) < 2 || int(resp[1]) != 2*int(count) || len(resp) != 2+2*int(count) {
+		return nil, fmt.Errorf("modbus read registers invalid response length")
+	}
+	result := make([]uint16, count)
+	for i := range result {
+		result[i] = binary.BigEndian.Uint16(resp[2+2*i:])
+	}
+	return result, nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//testModbusServer is in-process Modbus TCP server with 100 holding registers
+type testModbusServer struct {
+	listener  net.Listener
+	mutex     sync.Mutex
+	registers [100]uint16
+	writes    int
+}
+
+func startTestModbusServer(t *testing.T) *testModbusServer {
+	t.Helper()
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatal(errListen)
+	}
+	result := &testModbusServer{listener: listener}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go result.serve(conn)
+		}
+	}()
+	return result
+}
+
+func (p *testModbusServer) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		header := make([]byte, 7)
+		_, errHeader := io.ReadFull(conn, header)
+		if errHeader != nil {
+			return
+		}
+		pdu := make([]byte, binary.BigEndian.Uint16(header[4:6])-1)
+		_, errPdu := io.ReadFull(conn, pdu)
+		if errPdu != nil {
+			return
+		}
+		resp := p.handle(pdu)
+		binary.BigEndian.PutUint16(header[4:6], uint16(len(resp)+1))
+		conn.Write(append(header, resp...))
+	}
+}
+
+func (p *testModbusServer) handle(pdu []byte) []byte {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	address := int(binary.BigEndian.Uint16(pdu[1:3]))
+	switch pdu[0] {
+	case MODBUS_WRITESINGLE:
+		if len(p.registers) <= address {
+			return []byte{pdu[0] | MODBUS_EXCEPTIONBIT, 2} //Illegal data address
+		}
+		p.registers[address] = binary.BigEndian.Uint16(pdu[3:5])
+		p.writes++
+		return pdu
+	case MODBUS_READHOLDING:
+		count := int(binary.BigEndian.Uint16(pdu[3:5]))
+		if len(p.registers) < address+count {
+			return []byte{pdu[0] | MODBUS_EXCEPTIONBIT, 2}
+		}
+		resp := []byte{pdu[0], byte(2 * count)}
+		for i := 0; i < count; i++ {
+			resp = append(resp, byte(p.registers[address+i]>>8), byte(p.registers[address+i]))
+		}
+		return resp
+	}
+	return []byte{pdu[0] | MODBUS_EXCEPTIONBIT, 1} //Illegal function
+}
+
+func TestModbusClient(t *testing.T) {
+	srv := startTestModbusServer(t)
+	client, errDial := DialModbus(srv.listener.Addr().String(), 1)
+	if errDial != nil {
+		t.Fatal(errDial)
+	}
+	defer client.Close()
+	for i, value := range []uint16{450, 0xFFFB} {
+		errWrite := client.WriteRegister(uint16(10+i), value)
+		if errWrite != nil {
+			t.Fatal(errWrite)
+		}
+	}
+	values, errRead := client.ReadRegisters(10, 2)
+	if errRead != nil {
+		t.Fatal(errRead)
+	}
+	if values[0] != 450 || values[1] != 0xFFFB {
+		t.Errorf("read back %v", values)
+	}
+	errWrite := client.WriteRegister(500, 1)
+	if errWrite == nil || !strings.Contains(errWrite.Error(), "exception code 2") {
+		t.Errorf("expected exception, got %v", errWrite)
+	}
+}
+
+func TestModbusWriter(t *testing.T) {
+	srv := startTestModbusServer(t)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	writer := ModbusWriter{
+		Device: ModbusDevice{
+			Name:          "heatpump",
+			Address:       srv.listener.Addr().String(),
+			UnitId:        1,
+			CheapestHours: 3,
+			Registers: []ModbusRegister{
+				{Name: "dhw", Address: 20, Values: map[string]int{"expensive": 450, "normal": 500, "cheap": 550}},
+				{Name: "offset", Address: 21, Values: map[string]int{"expensive": -5, "normal": 0}},
+			},
+		},
+		ExpensiveHourCount: 6,
+	}
+	steps := []struct {
+		clock  string
+		class  string
+		dhw    uint16
+		offset uint16
+		writes int
+	}{
+		{"01:00", PRICECLASS_CHEAP, 550, 0, 1},  //Offset has no cheap value
+		{"01:30", PRICECLASS_CHEAP, 550, 0, 1},  //Nothing changed
+		{"12:00", PRICECLASS_NORMAL, 500, 0, 3}, //Both written
+		{"20:00", PRICECLASS_EXPENSIVE, 450, 0xFFFB, 5},
+	}
+	for _, step := range steps {
+		errUpdate := writer.Update(today, nil, testClock(t, step.clock))
+		if errUpdate != nil {
+			t.Fatal(errUpdate)
+		}
+		class, _, _ := writer.State()
+		srv.mutex.Lock()
+		dhw, offset, writes := srv.registers[20], srv.registers[21], srv.writes
+		srv.mutex.Unlock()
+		if class != step.class || dhw != step.dhw || offset != step.offset || writes != step.writes {
+			t.Errorf("at %s class %s dhw %d offset %d writes %d, wanted %+v", step.clock, class, dhw, offset, writes, step)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+/*
+Writes holding registers of Modbus TCP devices (heat pump, inverter) by price class of current hour.
+Like lower DHW setpoint during expensive hours and raise it during cheapest hours
+*/
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	PRICECLASS_EXPENSIVE = "expensive"
+	PRICECLASS_NORMAL    = "normal"
+	PRICECLASS_CHEAP     = "cheap"
+
+	MODBUSREWRITE_INTERVAL = time.Hour //All registers are written again after this, in case device has lost settings
+)
+
+type ModbusRegister struct {
+	Name    string         `json:"name"`
+	Address int            `json:"address"` //Zero based holding register address
+	Values  map[string]int `json:"values"`  //Raw value by price class expensive, normal, cheap. Class without value is not written
+}
+
+type ModbusDevice struct {
+	Name           string           `json:"name"`
+	Address        string           `json:"address"` //host:port
+	UnitId         int              `json:"unitId"`
+	ExpensiveHours int              `json:"expensiveHours,omitempty"` //Default same as chart (-e)
+	CheapestHours  int              `json:"cheapestHours,omitempty"`  //Cheapest hours of day, 0 means no cheap class
+	DryRun         bool             `json:"dryRun,omitempty"`         //Only log what would be written
+	Registers      []ModbusRegister `json:"registers"`
+}
+
+func (p *ModbusDevice) CheckErr() error {
+	if p.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if p.Address == "" {
+		return fmt.Errorf("%s address missing", p.Name)
+	}
+	if p.UnitId < 0 || 247 < p.UnitId {
+		return fmt.Errorf("%s unitId must be 0-247", p.Name)
+	}
+	if p.ExpensiveHours < 0 || 24 < p.ExpensiveHours || p.CheapestHours < 0 || 24 < p.CheapestHours {
+		return fmt.Errorf("%s expensiveHours and cheapestHours must be 0-24", p.Name)
+	}
+	for _, reg := range p.Registers {
+		if reg.Address < 0 || 0xFFFF < reg.Address {
+			return fmt.Errorf("%s register %s address out of range", p.Name, reg.Name)
+		}
+		for class, value := range reg.Values {
+			if class != PRICECLASS_EXPENSIVE && class != PRICECLASS_NORMAL && class != PRICECLASS_CHEAP {
+				return fmt.Errorf("%s register %s unknown price class %s", p.Name, reg.Name, class)
+			}
+			if value < -0x8000 || 0xFFFF < value {
+				return fmt.Errorf("%s register %s value %d does not fit 16 bits", p.Name, reg.Name, value)
+			}
+		}
+	}
+	return nil
+}
+
+//priceClass of hour containing tNow. Expensive like on chart, cheap is one of cheapestN hours of day
+func priceClass(today []HourPrice, tNow time.Time, expensiveN int, cheapestN int) (string, bool) {
+	flags := expensiveFlags(today, expensiveN)
+	for i, hp := range today {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		if flags[i] {
+			return PRICECLASS_EXPENSIVE, true
+		}
+		for _, cheap := range cheapestHours(today, cheapestN) {
+			if cheap.Start.Equal(hp.Start) {
+				return PRICECLASS_CHEAP, true
+			}
+		}
+		return PRICECLASS_NORMAL, true
+	}
+	return PRICECLASS_NORMAL, false
+}
+
+type ModbusWriter struct {
+	Device             ModbusDevice
+	ExpensiveHourCount int //Default when device does not have expensiveHours
+
+	mutex       sync.Mutex
+	class       string
+	written     map[int]int //Register address to value
+	lastRewrite time.Time
+	err         error
+}
+
+//State is current price class, written values by register name and error of last update
+func (p *ModbusWriter) State() (string, map[string]int, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	values := make(map[string]int)
+	for _, reg := range p.Device.Registers {
+		value, haveValue := p.written[reg.Address]
+		if haveValue {
+			values[reg.Name] = value
+		}
+	}
+	return p.class, values, p.err
+}
+
+//Update writes registers whose value for current class differs from last written. Normal class is used without prices
+func (p *ModbusWriter) Update(today []HourPrice, pricesErr error, tNow time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	class := PRICECLASS_NORMAL
+	if pricesErr == nil {
+		n := p.Device.ExpensiveHours
+		if n == 0 {
+			n = p.ExpensiveHourCount
+		}
+		class, _ = priceClass(today, tNow, n, p.Device.CheapestHours)
+	}
+	if class != p.class {
+		fmt.Printf("modbus %s price class %s\n", p.Device.Name, class)
+	}
+	p.class = class
+	if p.written == nil || p.lastRewrite.Add(MODBUSREWRITE_INTERVAL).Before(tNow) {
+		p.written = make(map[int]int)
+		p.lastRewrite = tNow
+	}
+	p.err = p.write(class)
+	return p.err
+}
+
+func (p *ModbusWriter) write(class string) error {
+	var client *ModbusClient
+	defer func() {
+		if client != nil {
+			client.Close()
+		}
+	}()
+	for _, reg := range p.Device.Registers {
+		value, haveValue := reg.Values[class]
+		if !haveValue {
+			continue
+		}
+		old, haveOld := p.written[reg.Address]
+		if haveOld && old == value {
+			continue
+		}
+		if p.Device.DryRun {
+			fmt.Printf("modbus %s dry-run: would write register %d (%s) = %d\n", p.Device.Name, reg.Address, reg.Name, value)
+			p.written[reg.Address] = value
+			continue
+		}
+		if client == nil {
+			dialed, errDial := DialModbus(p.Device.Address, byte(p.Device.UnitId))
+			if errDial != nil {
+				return fmt.Errorf("modbus %s %v", p.Device.Name, errDial.Error())
+			}
+			client = &dialed
+		}
+		errWrite := client.WriteRegister(uint16(reg.Address), uint16(value))
+		if errWrite != nil {
+			return fmt.Errorf("modbus %s register %s %v", p.Device.Name, reg.Name, errWrite.Error())
+		}
+		fmt.Printf("modbus %s wrote register %d (%s) = %d\n", p.Device.Name, reg.Address, reg.Name, value)
+		p.written[reg.Address] = value
+	}
+	return nil
+}
+
+//RunModbus updates all devices every RELAYCHECK_INTERVAL. Never returns
+func RunModbus(store *PriceStore, writers []*ModbusWriter) {
+	for {
+		tNow := time.Now()
+		today, errToday := store.DayPrices(tNow)
+		for _, writer := range writers {
+			errUpdate := writer.Update(today, errToday, tNow)
+			if errUpdate != nil {
+				fmt.Printf("%v\n", errUpdate.Error())
+			}
+		}
+		time.Sleep(RELAYCHECK_INTERVAL)
+	}
+}
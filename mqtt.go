@@ -0,0 +1,158 @@
+/*
+Minimal MQTT 3.1.1 client. Only what spotview needs: connect, QoS0 publish (retained) and disconnect
+Works over any net.Conn so it can be run against in-process broker
+*/
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+const (
+	MQTT_CONNECT    byte = 0x10
+	MQTT_CONNACK    byte = 0x20
+	MQTT_PUBLISH    byte = 0x30
+	MQTT_PINGREQ    byte = 0xC0
+	MQTT_PINGRESP   byte = 0xD0
+	MQTT_DISCONNECT byte = 0xE0
+
+	MQTT_RETAIN byte = 0x01
+
+	MQTT_KEEPALIVE_S = 60
+	MQTT_TIMEOUT     = 10 * time.Second
+)
+
+type MqttClient struct {
+	conn net.Conn
+}
+
+type MqttOptions struct {
+	ClientId string
+	User     string
+	Password string
+}
+
+func DialMqtt(address string, opts MqttOptions) (MqttClient, error) {
+	conn, errDial := net.DialTimeout("tcp", address, MQTT_TIMEOUT)
+	if errDial != nil {
+		return MqttClient{}, fmt.Errorf("mqtt dial %v err %v", address, errDial.Error())
+	}
+	client, errConnect := ConnectMqtt(conn, opts)
+	if errConnect != nil {
+		conn.Close()
+		return client, errConnect
+	}
+	return client, nil
+}
+
+//ConnectMqtt does mqtt handshake on already opened connection
+func ConnectMqtt(conn net.Conn, opts MqttOptions) (MqttClient, error) {
+	var body bytes.Buffer
+	body.Write(mqttString("MQTT"))
+	body.WriteByte(4)   //Protocol level 3.1.1
+	flags := byte(0x02) //Clean session
+	if opts.User != "" {
+		flags |= 0x80
+		if opts.Password != "" {
+			flags |= 0x40
+		}
+	}
+	body.WriteByte(flags)
+	body.Write([]byte{byte(MQTT_KEEPALIVE_S >> 8), byte(MQTT_KEEPALIVE_S & 0xFF)})
+	body.Write(mqttString(opts.ClientId))
+	if opts.User != "" {
+		body.Write(mqttString(opts.User))
+		if opts.Password != "" {
+			body.Write(mqttString(opts.Password))
+		}
+	}
+
+	result := MqttClient{conn: conn}
+	errSend := result.send(MQTT_CONNECT, body.Bytes())
+	if errSend != nil {
+		return result, fmt.Errorf("mqtt connect err %v", errSend.Error())
+	}
+
+	conn.SetReadDeadline(time.Now().Add(MQTT_TIMEOUT))
+	ack := make([]byte, 4)
+	_, errRead := io.ReadFull(conn, ack)
+	if errRead != nil {
+		return result, fmt.Errorf("mqtt connack read err %v", errRead.Error())
+	}
+	if ack[0] != MQTT_CONNACK || ack[1] != 2 {
+		return result, fmt.Errorf("mqtt invalid connack %X", ack)
+	}
+	if ack[3] != 0 {
+		return result, fmt.Errorf("mqtt connection refused, return code %v", ack[3])
+	}
+	return result, nil
+}
+
+//mqttString is length prefixed utf-8 string
+func mqttString(s string) []byte {
+	return append([]byte{byte(len(s) >> 8), byte(len(s) & 0xFF)}, []byte(s)...)
+}
+
+//mqttRemainingLength encodes variable length integer
+func mqttRemainingLength(n int) []byte {
+	result := []byte{}
+	for {
+		b := byte(n % 128)
+		n /= 128
+		if 0 < n {
+			b |= 0x80
+		}
+		result = append(result, b)
+		if n == 0 {
+			return result
+		}
+	}
+}
+
+func (p *MqttClient) send(header byte, body []byte) error {
+	packet := append([]byte{header}, mqttRemainingLength(len(body))...)
+	packet = append(packet, body...)
+	p.conn.SetWriteDeadline(time.Now().Add(MQTT_TIMEOUT))
+	_, err := p.conn.Write(packet)
+	return err
+}
+
+//Publish with QoS 0
+func (p *MqttClient) Publish(topic string, payload []byte, retain bool) error {
+	header := MQTT_PUBLISH
+	if retain {
+		header |= MQTT_RETAIN
+	}
+	errSend := p.send(header, append(mqttString(topic), payload...))
+	if errSend != nil {
+		return fmt.Errorf("mqtt publish %v err %v", topic, errSend.Error())
+	}
+	return nil
+}
+
+func (p *MqttClient) Ping() error {
+	errSend := p.send(MQTT_PINGREQ, nil)
+	if errSend != nil {
+		return fmt.Errorf("mqtt ping err %v", errSend.Error())
+	}
+	p.conn.SetReadDeadline(time.Now().Add(MQTT_TIMEOUT))
+	resp := make([]byte, 2)
+	_, errRead := io.ReadFull(p.conn, resp)
+	if errRead != nil {
+		return fmt.Errorf("mqtt pingresp read err %v", errRead.Error())
+	}
+	if resp[0] != MQTT_PINGRESP {
+		return fmt.Errorf("mqtt invalid pingresp %X", resp)
+	}
+	return nil
+}
+
+//Close disconnects cleanly
+func (p *MqttClient) Close() error {
+	p.send(MQTT_DISCONNECT, nil)
+	return p.conn.Close()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testBroker is in-process mqtt broker stand-in. Accepts one session per connection and records publishes
+type testBroker struct {
+	listener   net.Listener
+	returnCode byte
+	published  chan map[string]string //Topic to payload of one session, retained only
+}
+
+func startTestBroker(t *testing.T, returnCode byte) *testBroker {
+	t.Helper()
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatal(errListen)
+	}
+	result := &testBroker{listener: listener, returnCode: returnCode, published: make(chan map[string]string, 10)}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go result.session(conn)
+		}
+	}()
+	return result
+}
+
+func readMqttPacket(r *bufio.Reader) (byte, []byte, error) {
+	header, errHeader := r.ReadByte()
+	if errHeader != nil {
+		return 0, nil, errHeader
+	}
+	length, multiplier := 0, 1
+	for {
+		b, errLen := r.ReadByte()
+		if errLen != nil {
+			return 0, nil, errLen
+		}
+		length += int(b&0x7F) * multiplier
+		multiplier *= 128
+		if b&0x80 == 0 {
+			break
+		}
+	}
+	body := make([]byte, length)
+	_, errBody := io.ReadFull(r, body)
+	return header, body, errBody
+}
+
+func (p *testBroker) session(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	header, _, errConnect := readMqttPacket(r)
+	if errConnect != nil || header != MQTT_CONNECT {
+		return
+	}
+	conn.Write([]byte{MQTT_CONNACK, 2, 0, p.returnCode})
+	result := make(map[string]string)
+	for {
+		header, body, errRead := readMqttPacket(r)
+		if errRead != nil {
+			return
+		}
+		switch header & 0xF0 {
+		case MQTT_PUBLISH:
+			topicLen := int(body[0])<<8 | int(body[1])
+			if header&MQTT_RETAIN != 0 {
+				result[string(body[2:2+topicLen])] = string(body[2+topicLen:])
+			}
+		case MQTT_PINGREQ:
+			conn.Write([]byte{MQTT_PINGRESP, 0})
+		case MQTT_DISCONNECT:
+			p.published <- result
+			return
+		}
+	}
+}
+
+func TestMqttPublish(t *testing.T) {
+	broker := startTestBroker(t, 0)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	pub := MqttPublisher{
+		Address:            broker.listener.Addr().String(),
+		Options:            MqttOptions{ClientId: "test", User: "user", Password: "secret"},
+		Prefix:             "spotview",
+		DiscoveryPrefix:    "homeassistant",
+		Store:              store,
+		ExpensiveHourCount: 6,
+	}
+	tNow := testDate(t, "2026-10-19").Add(9*time.Hour + 30*time.Minute) //21:30, 22nd hour is expensive
+	errPub := pub.Publish(tNow)
+	if errPub != nil {
+		t.Fatal(errPub)
+	}
+	got := <-broker.published
+
+	wanted := map[string]string{
+		"spotview/price/now":   "22.00",
+		"spotview/expensive":   "ON",
+		"spotview/price/today": "[1,2,3,4,5,6,7,8,9,10,11,12,13,14,15,16,17,18,19,20,21,22,23,24]",
+	}
+	for topic, payload := range wanted {
+		if got[topic] != payload {
+			t.Errorf("topic %s payload %q wanted %q", topic, got[topic], payload)
+		}
+	}
+	for _, topic := range []string{"homeassistant/sensor/spotview/price/config", "homeassistant/binary_sensor/spotview/expensive/config", "spotview/price/tomorrow", "spotview/price/attributes"} {
+		if _, have := got[topic]; !have {
+			t.Errorf("topic %s not published", topic)
+		}
+	}
+	if !strings.Contains(got["homeassistant/sensor/spotview/price/config"], `"state_topic":"spotview/price/now"`) {
+		t.Errorf("discovery config does not point to state topic: %s", got["homeassistant/sensor/spotview/price/config"])
+	}
+}
+
+func TestMqttRefused(t *testing.T) {
+	broker := startTestBroker(t, 5) //Not authorized
+	_, errDial := DialMqtt(broker.listener.Addr().String(), MqttOptions{ClientId: "test"})
+	if errDial == nil || !strings.Contains(errDial.Error(), "refused") {
+		t.Fatalf("expected refused connection, got %v", errDial)
+	}
+}
+
+func TestMqttPing(t *testing.T) {
+	broker := startTestBroker(t, 0)
+	client, errDial := DialMqtt(broker.listener.Addr().String(), MqttOptions{ClientId: "test"})
+	if errDial != nil {
+		t.Fatal(errDial)
+	}
+	errPing := client.Ping()
+	if errPing != nil {
+		t.Fatal(errPing)
+	}
+	client.Close()
+	<-broker.published
+}
@@ -0,0 +1,168 @@
+/*
+Publishes prices to mqtt broker, with home assistant discovery config.
+All messages are retained so subscribers get state right away
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const MQTTPUBLISH_INTERVAL = 15 * time.Minute //Check also between hours, tomorrow prices appear in afternoon
+
+type MqttPublisher struct {
+	Address            string //host:port
+	Options            MqttOptions
+	Prefix             string //Topic prefix like spotview
+	DiscoveryPrefix    string //Home assistant discovery prefix, empty disables
+	Store              *PriceStore
+	ExpensiveHourCount int
+}
+
+//MqttMessage is one retained message to publish
+type MqttMessage struct {
+	Topic   string
+	Payload []byte
+}
+
+type haDevice struct {
+	Identifiers  []string `json:"identifiers"`
+	Name         string   `json:"name"`
+	Model        string   `json:"model"`
+	Manufacturer string   `json:"manufacturer"`
+}
+
+type haDiscovery struct {
+	Name                string   `json:"name"`
+	UniqueId            string   `json:"unique_id"`
+	StateTopic          string   `json:"state_topic"`
+	JsonAttributesTopic string   `json:"json_attributes_topic,omitempty"`
+	UnitOfMeasurement   string   `json:"unit_of_measurement,omitempty"`
+	StateClass          string   `json:"state_class,omitempty"`
+	PayloadOn           string   `json:"payload_on,omitempty"`
+	PayloadOff          string   `json:"payload_off,omitempty"`
+	Icon                string   `json:"icon,omitempty"`
+	Device              haDevice `json:"device"`
+}
+
+type mqttPriceAttributes struct {
+	Today    []float64 `json:"today"`
+	Tomorrow []float64 `json:"tomorrow"`
+}
+
+func (p *MqttPublisher) discoveryMessages() ([]MqttMessage, error) {
+	device := haDevice{Identifiers: []string{p.Prefix}, Name: "Spotview", Model: "spotview", Manufacturer: "spotview"}
+	configs := map[string]haDiscovery{
+		p.DiscoveryPrefix + "/sensor/" + p.Prefix + "/price/config": {
+			Name:                "Spot price",
+			UniqueId:            p.Prefix + "_price",
+			StateTopic:          p.Prefix + "/price/now",
+			JsonAttributesTopic: p.Prefix + "/price/attributes",
+			UnitOfMeasurement:   "c/kWh",
+			StateClass:          "measurement",
+			Icon:                "mdi:flash",
+			Device:              device,
+		},
+		p.DiscoveryPrefix + "/binary_sensor/" + p.Prefix + "/expensive/config": {
+			Name:       "Spot price expensive hour",
+			UniqueId:   p.Prefix + "_expensive",
+			StateTopic: p.Prefix + "/expensive",
+			PayloadOn:  "ON",
+			PayloadOff: "OFF",
+			Icon:       "mdi:cash-remove",
+			Device:     device,
+		},
+	}
+	result := []MqttMessage{}
+	for topic, cfg := range configs {
+		payload, errMarshal := json.Marshal(cfg)
+		if errMarshal != nil {
+			return nil, errMarshal
+		}
+		result = append(result, MqttMessage{Topic: topic, Payload: payload})
+	}
+	return result, nil
+}
+
+//Messages creates state messages at time tNow
+func (p *MqttPublisher) Messages(tNow time.Time) ([]MqttMessage, error) {
+	today, errToday := p.Store.ShownDayPrices(tNow)
+	if errToday != nil {
+		return nil, errToday
+	}
+	tomorrow, errTomorrow := p.Store.ShownDayPrices(tNow.Add(time.Hour * 24))
+	if errTomorrow != nil {
+		tomorrow = []HourPrice{} //Not published yet
+	}
+
+	attributes := mqttPriceAttributes{Today: priceValues(today), Tomorrow: priceValues(tomorrow)}
+	attributesPayload, _ := json.Marshal(attributes)
+	todayPayload, _ := json.Marshal(attributes.Today)
+	tomorrowPayload, _ := json.Marshal(attributes.Tomorrow)
+
+	result := []MqttMessage{
+		{Topic: p.Prefix + "/price/today", Payload: todayPayload},
+		{Topic: p.Prefix + "/price/tomorrow", Payload: tomorrowPayload},
+		{Topic: p.Prefix + "/price/attributes", Payload: attributesPayload},
+	}
+
+	expensive := expensiveFlags(today, p.ExpensiveHourCount)
+	for i, hp := range today {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		state := "OFF"
+		if expensive[i] {
+			state = "ON"
+		}
+		result = append(result,
+			MqttMessage{Topic: p.Prefix + "/price/now", Payload: []byte(fmt.Sprintf("%.2f", hp.Price))},
+			MqttMessage{Topic: p.Prefix + "/expensive", Payload: []byte(state)})
+	}
+	return result, nil
+}
+
+//Publish connects, publishes all and disconnects
+func (p *MqttPublisher) Publish(tNow time.Time) error {
+	messages, errMessages := p.Messages(tNow)
+	if errMessages != nil {
+		return errMessages
+	}
+	if p.DiscoveryPrefix != "" {
+		discovery, errDiscovery := p.discoveryMessages()
+		if errDiscovery != nil {
+			return errDiscovery
+		}
+		messages = append(discovery, messages...)
+	}
+
+	client, errDial := DialMqtt(p.Address, p.Options)
+	if errDial != nil {
+		return errDial
+	}
+	defer client.Close()
+	for _, msg := range messages {
+		errPub := client.Publish(msg.Topic, msg.Payload, true)
+		if errPub != nil {
+			return errPub
+		}
+	}
+	return nil
+}
+
+//Run publishes at every full hour and between with MQTTPUBLISH_INTERVAL. Never returns
+func (p *MqttPublisher) Run() {
+	for {
+		errPub := p.Publish(time.Now())
+		if errPub != nil {
+			fmt.Printf("mqtt publish failed %v\n", errPub.Error())
+		}
+		wait := untilNextHour(time.Now())
+		if MQTTPUBLISH_INTERVAL < wait {
+			wait = MQTTPUBLISH_INTERVAL
+		}
+		time.Sleep(wait + time.Second)
+	}
+}
@@ -0,0 +1,410 @@
+/*
+Notifications. Alerts when tomorrow prices go over or under configured limits and evening digest of tomorrow
+(min, max, average and cheapest window) with rendered view attached. Channels are ntfy compatible HTTP push and SMTP email.
+Sent notifications are logged, so restart does not send them again
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"strings"
+	"time"
+)
+
+const (
+	NOTIFYDEFAULT_DIGESTTIME = "18:00"
+	NOTIFYDEFAULT_WINDOW     = 3 //Hours of cheapest window in digest
+	NOTIFYIMAGE_NAME         = "spotview.png"
+)
+
+type PushConfig struct {
+	Url      string `json:"url"`                //Topic url like http://ntfy.local/spotprices
+	Token    string `json:"token,omitempty"`    //Sent as bearer token
+	Priority string `json:"priority,omitempty"` //ntfy priority 1-5 or name
+}
+
+type SmtpConfig struct {
+	Address  string   `json:"address"` //host:port. STARTTLS is used when server offers it
+	User     string   `json:"user,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+type NotifyConfig struct {
+	Push         *PushConfig `json:"push,omitempty"`
+	Smtp         *SmtpConfig `json:"smtp,omitempty"`
+	AlertAbove   *float64    `json:"alertAbove,omitempty"`   //Alert if some hour of tomorrow is at or over this, c/kWh
+	AlertBelow   *float64    `json:"alertBelow,omitempty"`   //Alert if some hour of tomorrow is under this, c/kWh
+	Digest       bool        `json:"digest"`                 //Send evening digest of tomorrow
+	DigestTime   string      `json:"digestTime,omitempty"`   //HH:MM in Finland, default 18:00. Sent later if prices are published later
+	DigestWindow int         `json:"digestWindow,omitempty"` //Hours of cheapest window in digest, default 3
+	Log          string      `json:"log,omitempty"`          //Sent notifications, default notify.log in cache dir
+}
+
+func (p *NotifyConfig) CheckErr() error {
+	if p.Push == nil && p.Smtp == nil {
+		return fmt.Errorf("push or smtp needed")
+	}
+	if p.Push != nil && !strings.HasPrefix(p.Push.Url, "http://") && !strings.HasPrefix(p.Push.Url, "https://") {
+		return fmt.Errorf("push url must start with http:// or https://")
+	}
+	if p.Smtp != nil {
+		_, _, errAddress := net.SplitHostPort(p.Smtp.Address)
+		if errAddress != nil {
+			return fmt.Errorf("smtp address must be host:port")
+		}
+		if p.Smtp.From == "" || len(p.Smtp.To) == 0 {
+			return fmt.Errorf("smtp from and to needed")
+		}
+	}
+	if p.DigestTime != "" {
+		_, _, errClock := parseClock(p.DigestTime)
+		if errClock != nil {
+			return fmt.Errorf("digestTime %v", errClock.Error())
+		}
+	}
+	if p.DigestWindow < 0 || 24 < p.DigestWindow {
+		return fmt.Errorf("digestWindow must be 1-24 hours")
+	}
+	if p.AlertAbove == nil && p.AlertBelow == nil && !p.Digest {
+		return fmt.Errorf("nothing to notify, set alertAbove, alertBelow or digest")
+	}
+	return nil
+}
+
+//Notification is sent to all channels. Image is optional png
+type Notification struct {
+	Title   string
+	Message string
+	Tags    string //ntfy tags (emoji shortcodes)
+	Image   []byte
+}
+
+//SendPush posts message to ntfy compatible server. Image is not sent, push is short text
+func (p *PushConfig) SendPush(n Notification) error {
+	client := &http.Client{Timeout: WEBHOOKTIMEOUT}
+	delay := WEBHOOKRETRYDELAY
+	var errSend error
+	for try := 0; try < WEBHOOKRETRIES; try++ {
+		if 0 < try {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		req, errReq := http.NewRequest(http.MethodPost, p.Url, strings.NewReader(n.Message))
+		if errReq != nil {
+			return fmt.Errorf("push request err %v", errReq.Error())
+		}
+		req.Header.Set("Title", n.Title)
+		if n.Tags != "" {
+			req.Header.Set("Tags", n.Tags)
+		}
+		if p.Priority != "" {
+			req.Header.Set("Priority", p.Priority)
+		}
+		if p.Token != "" {
+			req.Header.Set("Authorization", "Bearer "+p.Token)
+		}
+		resp, errDo := client.Do(req)
+		if errDo != nil {
+			errSend = fmt.Errorf("push err %v", errDo.Error())
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || 299 < resp.StatusCode {
+			errSend = fmt.Errorf("push %v", resp.Status)
+			continue
+		}
+		return nil
+	}
+	return errSend
+}
+
+//mailMessage builds MIME message, multipart when image is attached
+func (p *SmtpConfig) mailMessage(n Notification, tNow time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", p.From)
+	fmt.Fprintf(&buf, "To: %s\r\n", strings.Join(p.To, ", "))
+	fmt.Fprintf(&buf, "Subject: %s\r\n", n.Title)
+	fmt.Fprintf(&buf, "Date: %s\r\n", tNow.Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	text := strings.ReplaceAll(n.Message, "\n", "\r\n") + "\r\n"
+	if len(n.Image) == 0 {
+		fmt.Fprintf(&buf, "Content-Type: text/plain; charset=utf-8\r\n\r\n%s", text)
+		return buf.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	textPart, errText := mw.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if errText != nil {
+		return nil, errText
+	}
+	textPart.Write([]byte(text))
+	imagePart, errImage := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {"image/png; name=\"" + NOTIFYIMAGE_NAME + "\""},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {"attachment; filename=\"" + NOTIFYIMAGE_NAME + "\""},
+	})
+	if errImage != nil {
+		return nil, errImage
+	}
+	encoded := base64.StdEncoding.EncodeToString(n.Image)
+	for 76 < len(encoded) {
+		imagePart.Write([]byte(encoded[:76] + "\r\n"))
+		encoded = encoded[76:]
+	}
+	imagePart.Write([]byte(encoded + "\r\n"))
+	mw.Close()
+
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+	buf.Write(body.Bytes())
+	return buf.Bytes(), nil
+}
+
+func (p *SmtpConfig) SendMail(n Notification, tNow time.Time) error {
+	msg, errMsg := p.mailMessage(n, tNow)
+	if errMsg != nil {
+		return fmt.Errorf("mail message err %v", errMsg.Error())
+	}
+	var auth smtp.Auth
+	if p.User != "" {
+		host, _, _ := net.SplitHostPort(p.Address)
+		auth = smtp.PlainAuth("", p.User, p.Password, host)
+	}
+	errSend := smtp.SendMail(p.Address, auth, p.From, p.To, msg)
+	if errSend != nil {
+		return fmt.Errorf("smtp err %v", errSend.Error())
+	}
+	return nil
+}
+
+type Notifier struct {
+	Config             NotifyConfig
+	Log                *WebhookLog
+	View               func(tNow time.Time) (PriceView, error) //Rendered to digest image
+	ExpensiveHourCount int
+}
+
+//hourOf is like 03 in finnish time
+func hourOf(t time.Time) string {
+	lt, _ := TimeInFinland(t)
+	return lt.Format("15")
+}
+
+//Alerts of tomorrow. Keys identify them in log
+func (p *NotifyConfig) Alerts(tomorrow []HourPrice) (map[string]Notification, error) {
+	result := make(map[string]Notification)
+	if len(tomorrow) == 0 {
+		return result, fmt.Errorf("no prices")
+	}
+	lt, _ := TimeInFinland(tomorrow[0].Start)
+	date := lt.Format("2006-01-02")
+	if p.AlertAbove != nil {
+		count, highest := 0, tomorrow[0]
+		for _, hp := range tomorrow {
+			if *p.AlertAbove <= hp.Price {
+				count++
+			}
+			if highest.Price < hp.Price {
+				highest = hp
+			}
+		}
+		if 0 < count {
+			result["alertAbove/"+date] = Notification{
+				Title:   fmt.Sprintf("Expensive electricity %s %s", FinnishWeekDayName(lt), date),
+				Message: fmt.Sprintf("%d hours at or over %.2f %s, max %.2f at %s", count, *p.AlertAbove, VATTENFALLEXPECTED_UNIT, highest.Price, hourOf(highest.Start)),
+				Tags:    "warning",
+			}
+		}
+	}
+	if p.AlertBelow != nil {
+		count, lowest := 0, tomorrow[0]
+		for _, hp := range tomorrow {
+			if hp.Price < *p.AlertBelow {
+				count++
+			}
+			if hp.Price < lowest.Price {
+				lowest = hp
+			}
+		}
+		if 0 < count {
+			result["alertBelow/"+date] = Notification{
+				Title:   fmt.Sprintf("Cheap electricity %s %s", FinnishWeekDayName(lt), date),
+				Message: fmt.Sprintf("%d hours under %.2f %s, min %.2f at %s", count, *p.AlertBelow, VATTENFALLEXPECTED_UNIT, lowest.Price, hourOf(lowest.Start)),
+				Tags:    "zap",
+			}
+		}
+	}
+	return result, nil
+}
+
+//DigestMessage is summary of tomorrow
+func (p *NotifyConfig) DigestMessage(tomorrow []HourPrice) (Notification, error) {
+	if len(tomorrow) == 0 {
+		return Notification{}, fmt.Errorf("no prices")
+	}
+	lowest, highest := tomorrow[0], tomorrow[0]
+	for _, hp := range tomorrow {
+		if hp.Price < lowest.Price {
+			lowest = hp
+		}
+		if highest.Price < hp.Price {
+			highest = hp
+		}
+	}
+	lt, _ := TimeInFinland(tomorrow[0].Start)
+	lines := []string{
+		fmt.Sprintf("min %.2f %s at %s", lowest.Price, VATTENFALLEXPECTED_UNIT, hourOf(lowest.Start)),
+		fmt.Sprintf("max %.2f %s at %s", highest.Price, VATTENFALLEXPECTED_UNIT, hourOf(highest.Start)),
+		fmt.Sprintf("average %.2f %s", averagePrice(tomorrow), VATTENFALLEXPECTED_UNIT),
+	}
+	windowHours := p.DigestWindow
+	if windowHours == 0 {
+		windowHours = NOTIFYDEFAULT_WINDOW
+	}
+	window, errWindow := CheapestWindow(tomorrow, windowHours, nil)
+	if errWindow == nil {
+		lines = append(lines, fmt.Sprintf("cheapest %dh window %s, average %.2f %s", windowHours, clockRange(window.Start, window.End()), window.Average, VATTENFALLEXPECTED_UNIT))
+	}
+	return Notification{
+		Title:   fmt.Sprintf("Electricity prices %s %s", FinnishWeekDayName(lt), lt.Format("2006-01-02")),
+		Message: strings.Join(lines, "\n"),
+		Tags:    "bar_chart",
+	}, nil
+}
+
+//renderImage is png of view, same as /spotview.png
+func (p *Notifier) renderImage(tNow time.Time) ([]byte, error) {
+	pw, errView := p.View(tNow)
+	if errView != nil {
+		return nil, errView
+	}
+	black, red, errGen := pw.CreateBlackRedView(p.ExpensiveHourCount)
+	if errGen != nil {
+		return nil, errGen
+	}
+	var buf bytes.Buffer
+	errPng := writePng(&buf, &black, &red)
+	if errPng != nil {
+		return nil, errPng
+	}
+	return buf.Bytes(), nil
+}
+
+//sendOnce sends to channels that have not got this notification yet. Key is logged per channel
+func (p *Notifier) sendOnce(key string, n Notification, tNow time.Time) error {
+	channels := map[string]func() error{}
+	if p.Config.Push != nil {
+		channels["push"] = func() error { return p.Config.Push.SendPush(n) }
+	}
+	if p.Config.Smtp != nil {
+		channels["smtp"] = func() error { return p.Config.Smtp.SendMail(n, tNow) }
+	}
+	errs := []string{}
+	for channel, send := range channels {
+		channelKey := key + "/" + channel
+		if p.Log.Fired(channelKey) {
+			continue
+		}
+		errSend := send()
+		if errSend != nil {
+			errs = append(errs, errSend.Error()) //Not logged, tried again on next check
+			continue
+		}
+		fmt.Printf("notify sent %s\n", channelKey)
+		errMark := p.Log.Mark(channelKey, tNow)
+		if errMark != nil {
+			errs = append(errs, errMark.Error())
+		}
+	}
+	if 0 < len(errs) {
+		return fmt.Errorf("notify %s failed: %s", n.Title, strings.Join(errs, ", "))
+	}
+	return nil
+}
+
+//sent is true when all channels have got notification
+func (p *Notifier) sent(key string) bool {
+	return (p.Config.Push == nil || p.Log.Fired(key+"/push")) && (p.Config.Smtp == nil || p.Log.Fired(key+"/smtp"))
+}
+
+//Check sends alerts when tomorrow prices are published and digest after digest time. Shown prices are used like on attached view. Failed notification does not block others
+func (p *Notifier) Check(store *PriceStore, tNow time.Time) error {
+	tomorrow, errTomorrow := store.ShownDayPrices(tNow.AddDate(0, 0, 1))
+	if errTomorrow != nil || len(tomorrow) == 0 {
+		return nil //Not published yet
+	}
+	alerts, errAlerts := p.Config.Alerts(tomorrow)
+	if errAlerts != nil {
+		return errAlerts
+	}
+	errs := []string{}
+	for key, n := range alerts {
+		if p.sent(key) {
+			continue
+		}
+		errSend := p.sendOnce(key, n, tNow)
+		if errSend != nil {
+			errs = append(errs, errSend.Error())
+		}
+	}
+	errDigest := p.checkDigest(tomorrow, tNow)
+	if errDigest != nil {
+		errs = append(errs, errDigest.Error())
+	}
+	if 0 < len(errs) {
+		return fmt.Errorf("%s", strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func (p *Notifier) checkDigest(tomorrow []HourPrice, tNow time.Time) error {
+	if !p.Config.Digest {
+		return nil
+	}
+	digestTime := p.Config.DigestTime
+	if digestTime == "" {
+		digestTime = NOTIFYDEFAULT_DIGESTTIME
+	}
+	h, m, _ := parseClock(digestTime)
+	lt, _ := TimeInFinland(tNow)
+	if lt.Hour()*60+lt.Minute() < h*60+m {
+		return nil
+	}
+	ltTomorrow, _ := TimeInFinland(tomorrow[0].Start)
+	key := "digest/" + ltTomorrow.Format("2006-01-02")
+	if p.sent(key) {
+		return nil
+	}
+	n, errDigest := p.Config.DigestMessage(tomorrow)
+	if errDigest != nil {
+		return errDigest
+	}
+	image, errImage := p.renderImage(tNow)
+	if errImage != nil {
+		fmt.Printf("digest image failed %v\n", errImage.Error()) //Digest is sent without image
+	}
+	n.Image = image
+	return p.sendOnce(key, n, tNow)
+}
+
+//Run checks every minute. Never returns
+func (p *Notifier) Run(store *PriceStore) {
+	for {
+		errCheck := p.Check(store, time.Now())
+		if errCheck != nil {
+			fmt.Printf("%v\n", errCheck.Error())
+		}
+		time.Sleep(RELAYCHECK_INTERVAL)
+	}
+}
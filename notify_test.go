@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"image/png"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//ntfyStandIn records pushed messages
+type ntfyStandIn struct {
+	mutex   sync.Mutex
+	bodies  []string
+	headers []http.Header
+}
+
+func startNtfyStandIn(t *testing.T) (*ntfyStandIn, *httptest.Server) {
+	t.Helper()
+	result := &ntfyStandIn{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result.mutex.Lock()
+		defer result.mutex.Unlock()
+		result.bodies = append(result.bodies, string(body))
+		result.headers = append(result.headers, r.Header.Clone())
+		w.Write([]byte(`{"id":"test"}`))
+	}))
+	t.Cleanup(server.Close)
+	return result, server
+}
+
+func (p *ntfyStandIn) count() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.bodies)
+}
+
+//startTestSmtp is minimal smtp listener without extensions. Each received message is sent to channel
+func startTestSmtp(t *testing.T) (string, chan []byte) {
+	t.Helper()
+	listener, errListen := net.Listen("tcp", "127.0.0.1:0")
+	if errListen != nil {
+		t.Fatal(errListen)
+	}
+	t.Cleanup(func() { listener.Close() })
+	messages := make(chan []byte, 10)
+	go func() {
+		for {
+			conn, errAccept := listener.Accept()
+			if errAccept != nil {
+				return
+			}
+			go testSmtpSession(conn, messages)
+		}
+	}()
+	return listener.Addr().String(), messages
+}
+
+func testSmtpSession(conn net.Conn, messages chan []byte) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	conn.Write([]byte("220 test ESMTP\r\n"))
+	for {
+		line, errRead := r.ReadString('\n')
+		if errRead != nil {
+			return
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			conn.Write([]byte("250 test\r\n"))
+		case strings.HasPrefix(command, "DATA"):
+			conn.Write([]byte("354 end with .\r\n"))
+			var data bytes.Buffer
+			for {
+				dataLine, errData := r.ReadString('\n')
+				if errData != nil {
+					return
+				}
+				if dataLine == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(dataLine, "."))
+			}
+			messages <- data.Bytes()
+			conn.Write([]byte("250 queued\r\n"))
+		case strings.HasPrefix(command, "QUIT"):
+			conn.Write([]byte("221 bye\r\n"))
+			return
+		default: //MAIL, RCPT, RSET, NOOP
+			conn.Write([]byte("250 ok\r\n"))
+		}
+	}
+}
+
+func TestNotifyPush(t *testing.T) {
+	standIn, server := startNtfyStandIn(t)
+	push := PushConfig{Url: server.URL + "/spotprices", Token: "secret", Priority: "high"}
+	errSend := push.SendPush(Notification{Title: "Cheap electricity", Message: "3 hours under 2.00", Tags: "zap"})
+	if errSend != nil {
+		t.Fatal(errSend)
+	}
+	if standIn.count() != 1 || standIn.bodies[0] != "3 hours under 2.00" {
+		t.Fatalf("unexpected push %v", standIn.bodies)
+	}
+	header := standIn.headers[0]
+	if header.Get("Title") != "Cheap electricity" || header.Get("Tags") != "zap" || header.Get("Priority") != "high" || header.Get("Authorization") != "Bearer secret" {
+		t.Fatalf("unexpected headers %v", header)
+	}
+}
+
+//mailParts parses message, returns subject and parts by content type. Attachment is base64 decoded
+func mailParts(t *testing.T, content []byte) (string, map[string][]byte, map[string]string) {
+	t.Helper()
+	msg, errMsg := mail.ReadMessage(bytes.NewReader(content))
+	if errMsg != nil {
+		t.Fatal(errMsg)
+	}
+	mediaType, params, errType := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if errType != nil {
+		t.Fatal(errType)
+	}
+	parts := make(map[string][]byte)
+	dispositions := make(map[string]string)
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		body, _ := io.ReadAll(msg.Body)
+		parts[mediaType] = body
+		return msg.Header.Get("Subject"), parts, dispositions
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		part, errPart := mr.NextPart()
+		if errPart == io.EOF {
+			break
+		}
+		if errPart != nil {
+			t.Fatal(errPart)
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		var body io.Reader = part
+		if part.Header.Get("Content-Transfer-Encoding") == "base64" {
+			body = base64.NewDecoder(base64.StdEncoding, part)
+		}
+		content, errRead := io.ReadAll(body)
+		if errRead != nil {
+			t.Fatal(errRead)
+		}
+		parts[partType] = content
+		dispositions[partType] = part.Header.Get("Content-Disposition")
+	}
+	return msg.Header.Get("Subject"), parts, dispositions
+}
+
+func TestNotifyMailAttachment(t *testing.T) {
+	address, messages := startTestSmtp(t)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	tNow := testDate(t, "2026-10-19")
+	pw, errView := store.PriceView(tNow)
+	if errView != nil {
+		t.Fatal(errView)
+	}
+	notifier := Notifier{View: func(time.Time) (PriceView, error) { return pw, nil }, ExpensiveHourCount: EXPENSIVEHOURCOUNT}
+	image, errImage := notifier.renderImage(tNow)
+	if errImage != nil {
+		t.Fatal(errImage)
+	}
+
+	conf := SmtpConfig{Address: address, From: "spotview@example.com", To: []string{"a@example.com", "b@example.com"}}
+	errSend := conf.SendMail(Notification{Title: "Electricity prices", Message: "min 1.00\nmax 24.00", Image: image}, tNow)
+	if errSend != nil {
+		t.Fatal(errSend)
+	}
+	subject, parts, dispositions := mailParts(t, <-messages)
+	if subject != "Electricity prices" {
+		t.Errorf("subject %q", subject)
+	}
+	if string(parts["text/plain"]) != "min 1.00\r\nmax 24.00\r\n" {
+		t.Errorf("text part %q", parts["text/plain"])
+	}
+	if !bytes.Equal(parts["image/png"], image) || !strings.Contains(dispositions["image/png"], NOTIFYIMAGE_NAME) {
+		t.Fatalf("attachment differs, %d bytes, wanted %d, disposition %q", len(parts["image/png"]), len(image), dispositions["image/png"])
+	}
+	decoded, errDecode := png.Decode(bytes.NewReader(parts["image/png"]))
+	if errDecode != nil {
+		t.Fatal(errDecode)
+	}
+	if decoded.Bounds().Dx() == 0 {
+		t.Fatal("empty image")
+	}
+}
+
+func TestNotifierCheck(t *testing.T) {
+	standIn, server := startNtfyStandIn(t)
+	address, messages := startTestSmtp(t)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	above := 25.0
+	hookLog, errLog := OpenWebhookLog(path.Join(t.TempDir(), "notify.log"), time.Now())
+	if errLog != nil {
+		t.Fatal(errLog)
+	}
+	notifier := Notifier{
+		Config: NotifyConfig{
+			Push:       &PushConfig{Url: server.URL},
+			Smtp:       &SmtpConfig{Address: address, From: "spotview@example.com", To: []string{"a@example.com"}},
+			AlertAbove: &above,
+			Digest:     true,
+		},
+		Log:                hookLog,
+		View:               store.PriceView,
+		ExpensiveHourCount: EXPENSIVEHOURCOUNT,
+	}
+
+	tNow := testDate(t, "2026-10-19").Add(2 * time.Hour) //14:00, only alert before digest time
+	errCheck := notifier.Check(store, tNow)
+	if errCheck != nil {
+		t.Fatal(errCheck)
+	}
+	if standIn.count() != 1 || !strings.HasPrefix(standIn.bodies[0], "6 hours at or over 25.00") {
+		t.Fatalf("unexpected pushes %v", standIn.bodies)
+	}
+	subject, _, _ := mailParts(t, <-messages)
+	if !strings.HasPrefix(subject, "Expensive electricity") {
+		t.Fatalf("unexpected alert mail %q", subject)
+	}
+
+	tNow = tNow.Add(4*time.Hour + 30*time.Minute) //18:30
+	errCheck = notifier.Check(store, tNow)
+	if errCheck != nil {
+		t.Fatal(errCheck)
+	}
+	if standIn.count() != 2 || !strings.Contains(standIn.bodies[1], "max 30.00") {
+		t.Fatalf("unexpected pushes %v", standIn.bodies)
+	}
+	subject, parts, _ := mailParts(t, <-messages)
+	if !strings.HasPrefix(subject, "Electricity prices") || len(parts["image/png"]) == 0 {
+		t.Fatalf("digest mail %q without image", subject)
+	}
+
+	errCheck = notifier.Check(store, tNow.Add(time.Minute))
+	if errCheck != nil {
+		t.Fatal(errCheck)
+	}
+	if standIn.count() != 2 || len(messages) != 0 {
+		t.Fatalf("sent again, %d pushes %d mails", standIn.count(), len(messages))
+	}
+}
+
+func TestNotifierShownPrices(t *testing.T) {
+	standIn, server := startNtfyStandIn(t)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	store.Vat = &VatMode{Table: DEFAULTVATTABLE, SourceIncludesVat: true, ShowVat: false}
+	above := 25.0 //Over net maximum 30/1.255
+	hookLog, errLog := OpenWebhookLog(path.Join(t.TempDir(), "notify.log"), time.Now())
+	if errLog != nil {
+		t.Fatal(errLog)
+	}
+	notifier := Notifier{
+		Config:             NotifyConfig{Push: &PushConfig{Url: server.URL}, AlertAbove: &above, Digest: true},
+		Log:                hookLog,
+		View:               store.PriceView,
+		ExpensiveHourCount: EXPENSIVEHOURCOUNT,
+	}
+	errCheck := notifier.Check(store, testDate(t, "2026-10-19").Add(6*time.Hour+30*time.Minute))
+	if errCheck != nil {
+		t.Fatal(errCheck)
+	}
+	if standIn.count() != 1 || !strings.Contains(standIn.bodies[0], "max 23.90") {
+		t.Fatalf("wanted only digest with net prices, got %v", standIn.bodies)
+	}
+}
@@ -0,0 +1,605 @@
+/*
+Minimal OCPP 1.6J central system for one charger. Charge point connects with websocket to /ocpp/<chargePointId>.
+Central system answers boot, heartbeat, status, authorize, transaction and meter messages, and pushes
+TxDefaultProfile so that charging happens only during cheapest hours needed for requested energy before departure
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	OCPPPATH               = "/ocpp/"
+	OCPPSUBPROTOCOL        = "ocpp1.6"
+	OCPPHEARTBEAT_S        = 300
+	OCPPCHECK_INTERVAL     = time.Minute
+	OCPPCHARGINGPROFILE_ID = 1
+
+	OCPP_CALL       = 2
+	OCPP_CALLRESULT = 3
+	OCPP_CALLERROR  = 4
+)
+
+type OcppConfig struct {
+	ChargePointId string  `json:"chargePointId,omitempty"` //Only this charger is accepted. Empty accepts any
+	ConnectorId   int     `json:"connectorId"`             //Profile connector, 0 is all connectors
+	MaxPowerW     float64 `json:"maxPowerW"`               //Charging power when charging is allowed
+	EnergyKWh     float64 `json:"energyKWh"`               //Default requested energy
+	Departure     string  `json:"departure"`               //Default departure HH:MM finnish time
+}
+
+func (p *OcppConfig) CheckErr() error {
+	if p.MaxPowerW <= 0 {
+		return fmt.Errorf("maxPowerW must be positive")
+	}
+	if p.EnergyKWh < 0 {
+		return fmt.Errorf("energyKWh must not be negative")
+	}
+	if p.ConnectorId < 0 {
+		return fmt.Errorf("connectorId must not be negative")
+	}
+	_, _, errClock := parseClock(p.Departure)
+	return errClock
+}
+
+type ChargeHour struct {
+	Start     time.Time
+	Price     float64
+	EnergyKWh float64 //Planned energy in this hour
+}
+
+type ChargePlan struct {
+	Departure time.Time
+	EnergyKWh float64      //Energy still needed
+	Hours     []ChargeHour //Selected hours, sorted by time
+	FullPower bool         //Not enough known hours before departure, charging is not limited
+	CostEur   float64      //Estimated cost of planned hours
+}
+
+//hourCapacity is kWh that can be charged in hour with powerKW between tNow and departure
+func hourCapacity(start time.Time, tNow time.Time, departure time.Time, powerKW float64) float64 {
+	from, to := start, start.Add(time.Hour)
+	if from.Before(tNow) {
+		from = tNow
+	}
+	if departure.Before(to) {
+		to = departure
+	}
+	if !from.Before(to) {
+		return 0
+	}
+	return to.Sub(from).Hours() * powerKW
+}
+
+//PlanCharging picks cheapest hours before departure until energy is covered. Last picked hour may be partial
+func PlanCharging(prices []HourPrice, tNow time.Time, departure time.Time, energyKWh float64, powerKW float64) ChargePlan {
+	result := ChargePlan{Departure: departure, EnergyKWh: energyKWh, Hours: []ChargeHour{}}
+	if energyKWh <= 0 {
+		return result
+	}
+	candidates := []HourPrice{}
+	for _, hp := range upcomingPrices(prices, tNow) {
+		if 0 < hourCapacity(hp.Start, tNow, departure, powerKW) {
+			candidates = append(candidates, hp)
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool { return candidates[i].Price < candidates[j].Price })
+
+	remaining := energyKWh
+	for _, hp := range candidates {
+		if remaining <= 0 {
+			break
+		}
+		energy := math.Min(remaining, hourCapacity(hp.Start, tNow, departure, powerKW))
+		result.Hours = append(result.Hours, ChargeHour{Start: hp.Start, Price: hp.Price, EnergyKWh: energy})
+		result.CostEur += energy * hp.Price / 100
+		remaining -= energy
+	}
+	if 1e-6 < remaining {
+		result.FullPower = true
+	}
+	sort.Slice(result.Hours, func(i, j int) bool { return result.Hours[i].Start.Before(result.Hours[j].Start) })
+	return result
+}
+
+type OcppSchedulePeriod struct {
+	StartPeriod int     `json:"startPeriod"` //Seconds from start of schedule
+	Limit       float64 `json:"limit"`
+}
+
+type OcppChargingSchedule struct {
+	Duration               int                  `json:"duration"`
+	StartSchedule          string               `json:"startSchedule"`
+	ChargingRateUnit       string               `json:"chargingRateUnit"`
+	ChargingSchedulePeriod []OcppSchedulePeriod `json:"chargingSchedulePeriod"`
+}
+
+type OcppChargingProfile struct {
+	ChargingProfileId      int                  `json:"chargingProfileId"`
+	StackLevel             int                  `json:"stackLevel"`
+	ChargingProfilePurpose string               `json:"chargingProfilePurpose"`
+	ChargingProfileKind    string               `json:"chargingProfileKind"`
+	ChargingSchedule       OcppChargingSchedule `json:"chargingSchedule"`
+}
+
+type OcppSetChargingProfile struct {
+	ConnectorId        int                 `json:"connectorId"`
+	CsChargingProfiles OcppChargingProfile `json:"csChargingProfiles"`
+}
+
+//Profile converts plan to absolute schedule from start of current hour until departure
+func (p *ChargePlan) Profile(tNow time.Time, connectorId int, maxPowerW float64) OcppSetChargingProfile {
+	start := tNow.Truncate(time.Hour)
+	periods := []OcppSchedulePeriod{}
+	addPeriod := func(t time.Time, limit float64) {
+		if 0 < len(periods) && periods[len(periods)-1].Limit == limit {
+			return
+		}
+		periods = append(periods, OcppSchedulePeriod{StartPeriod: int(t.Sub(start).Seconds()), Limit: limit})
+	}
+	if p.FullPower {
+		addPeriod(start, maxPowerW)
+	} else {
+		selected := make(map[int64]bool)
+		for _, hour := range p.Hours {
+			selected[hour.Start.Unix()] = true
+		}
+		for t := start; t.Before(p.Departure); t = t.Add(time.Hour) {
+			limit := float64(0)
+			if selected[t.Unix()] {
+				limit = maxPowerW
+			}
+			addPeriod(t, limit)
+		}
+	}
+	if len(periods) == 0 {
+		addPeriod(start, 0)
+	}
+	return OcppSetChargingProfile{
+		ConnectorId: connectorId,
+		CsChargingProfiles: OcppChargingProfile{
+			ChargingProfileId:      OCPPCHARGINGPROFILE_ID,
+			StackLevel:             0,
+			ChargingProfilePurpose: "TxDefaultProfile",
+			ChargingProfileKind:    "Absolute",
+			ChargingSchedule: OcppChargingSchedule{
+				Duration:               int(p.Departure.Sub(start).Seconds()),
+				StartSchedule:          start.UTC().Format(time.RFC3339),
+				ChargingRateUnit:       "W",
+				ChargingSchedulePeriod: periods,
+			},
+		},
+	}
+}
+
+type CentralSystem struct {
+	Config OcppConfig
+	Store  *PriceStore
+	Now    func() time.Time
+
+	mutex          sync.Mutex
+	ws             *WsConn
+	chargePointId  string
+	nextId         int
+	pending        map[string]string //Message id to action of calls sent to charge point
+	energyKWh      float64
+	departureClock string
+	status         string
+	transactionId  int       //Ongoing transaction, 0 when no transaction
+	lastId         int       //Latest given transaction id. Transaction interrupted by disconnect can resume with it
+	meterStartWh   float64   //Meter when counting of ongoing transaction started
+	meterWh        float64   //Latest meter value
+	deliveredWh    float64   //Energy of finished and interrupted transactions for current request
+	deliveredFor   time.Time //Departure that delivered energy counts for
+	sentProfile    string    //Last pushed schedule, for detecting changes
+	err            error
+}
+
+func NewCentralSystem(conf OcppConfig, store *PriceStore) *CentralSystem {
+	return &CentralSystem{
+		Config:         conf,
+		Store:          store,
+		Now:            time.Now,
+		pending:        make(map[string]string),
+		energyKWh:      conf.EnergyKWh,
+		departureClock: conf.Departure,
+		status:         "Disconnected",
+	}
+}
+
+//deliveredKWh is energy charged for current request, including ongoing transaction
+func (p *CentralSystem) deliveredKWh() float64 {
+	result := p.deliveredWh
+	if p.transactionId != 0 && p.meterStartWh < p.meterWh {
+		result += p.meterWh - p.meterStartWh
+	}
+	return result / 1000
+}
+
+//resetDelivered starts counting energy from zero, for new request or after departure has passed
+func (p *CentralSystem) resetDelivered(departure time.Time) {
+	p.deliveredWh = 0
+	p.deliveredFor = departure
+	p.meterStartWh = p.meterWh
+}
+
+//closeTransaction moves energy of ongoing transaction to delivered
+func (p *CentralSystem) closeTransaction(meterWh float64) {
+	if p.transactionId != 0 && p.meterStartWh < meterWh {
+		p.deliveredWh += meterWh - p.meterStartWh
+	}
+	p.transactionId = 0
+	p.meterWh = meterWh
+}
+
+//resumeTransaction continues transaction that was interrupted by disconnect. Energy before disconnect is already delivered
+func (p *CentralSystem) resumeTransaction(id int) {
+	if p.transactionId == 0 && id != 0 && id == p.lastId {
+		p.transactionId = id
+		p.meterStartWh = p.meterWh
+	}
+}
+
+//plan is current charging plan. Without prices charging is not limited
+func (p *CentralSystem) plan(tNow time.Time) (ChargePlan, error) {
+	departure, errDeparture := nextClock(tNow, p.departureClock)
+	if errDeparture != nil {
+		return ChargePlan{}, errDeparture
+	}
+	if !departure.Equal(p.deliveredFor) {
+		p.resetDelivered(departure)
+	}
+	remaining := p.energyKWh - p.deliveredKWh()
+	horizon, errHorizon := p.Store.Horizon(tNow)
+	if errHorizon != nil {
+		return ChargePlan{Departure: departure, EnergyKWh: remaining, FullPower: true}, errHorizon
+	}
+	return PlanCharging(horizon, tNow, departure, remaining, p.Config.MaxPowerW/1000), nil
+}
+
+//call sends request to charge point. Result is handled when it arrives
+func (p *CentralSystem) call(action string, payload interface{}) error {
+	if p.ws == nil {
+		return fmt.Errorf("charge point not connected")
+	}
+	p.nextId++
+	id := strconv.Itoa(p.nextId)
+	msg, errMarshal := json.Marshal([]interface{}{OCPP_CALL, id, action, payload})
+	if errMarshal != nil {
+		return errMarshal
+	}
+	p.pending[id] = action
+	return p.ws.WriteText(string(msg))
+}
+
+//pushProfile sends charging profile if it differs from last sent, or always if forced
+func (p *CentralSystem) pushProfile(force bool) error {
+	if p.ws == nil {
+		return nil
+	}
+	tNow := p.Now()
+	plan, errPlan := p.plan(tNow)
+	if errPlan != nil {
+		fmt.Printf("ocpp planning failed, charging not limited: %v\n", errPlan.Error())
+	}
+	profile := plan.Profile(tNow, p.Config.ConnectorId, p.Config.MaxPowerW)
+	//Start of schedule moves every hour, compare absolute periods instead
+	key := fmt.Sprintf("%v %v %v", plan.Departure.Unix(), plan.FullPower, len(plan.Hours))
+	for _, hour := range plan.Hours {
+		key += fmt.Sprintf(" %v", hour.Start.Unix())
+	}
+	if !force && key == p.sentProfile {
+		return nil
+	}
+	errCall := p.call("SetChargingProfile", profile)
+	if errCall != nil {
+		return errCall
+	}
+	p.sentProfile = key
+	fmt.Printf("ocpp sent charging profile, %d hours, %.1f kWh before %s\n", len(plan.Hours), plan.EnergyKWh, plan.Departure.Format(time.RFC3339))
+	return nil
+}
+
+func ocppTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02T15:04:05Z")
+}
+
+//handleCall answers request from charge point. Result payload or error code
+func (p *CentralSystem) handleCall(action string, payload json.RawMessage) (interface{}, string) {
+	idTagInfo := map[string]string{"status": "Accepted"}
+	switch action {
+	case "BootNotification":
+		return map[string]interface{}{"status": "Accepted", "currentTime": ocppTime(p.Now()), "interval": OCPPHEARTBEAT_S}, ""
+	case "Heartbeat":
+		return map[string]string{"currentTime": ocppTime(p.Now())}, ""
+	case "StatusNotification":
+		var req struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal(payload, &req)
+		p.status = req.Status
+		return map[string]string{}, ""
+	case "Authorize":
+		return map[string]interface{}{"idTagInfo": idTagInfo}, ""
+	case "StartTransaction":
+		var req struct {
+			MeterStart float64 `json:"meterStart"`
+		}
+		errParse := json.Unmarshal(payload, &req)
+		if errParse != nil {
+			return nil, "FormationViolation"
+		}
+		p.closeTransaction(p.meterWh)
+		p.lastId++
+		p.transactionId = p.lastId
+		p.meterStartWh, p.meterWh = req.MeterStart, req.MeterStart
+		return map[string]interface{}{"transactionId": p.transactionId, "idTagInfo": idTagInfo}, ""
+	case "StopTransaction":
+		var req struct {
+			TransactionId int     `json:"transactionId"`
+			MeterStop     float64 `json:"meterStop"`
+		}
+		errParse := json.Unmarshal(payload, &req)
+		if errParse != nil {
+			return nil, "FormationViolation"
+		}
+		p.resumeTransaction(req.TransactionId)
+		if req.TransactionId == p.transactionId {
+			p.closeTransaction(req.MeterStop)
+		}
+		return map[string]interface{}{"idTagInfo": idTagInfo}, ""
+	case "MeterValues":
+		var req struct {
+			TransactionId int `json:"transactionId"`
+			MeterValue    []struct {
+				SampledValue []struct {
+					Value     string `json:"value"`
+					Measurand string `json:"measurand"`
+					Unit      string `json:"unit"`
+				} `json:"sampledValue"`
+			} `json:"meterValue"`
+		}
+		json.Unmarshal(payload, &req)
+		p.resumeTransaction(req.TransactionId)
+		for _, meterValue := range req.MeterValue {
+			for _, sample := range meterValue.SampledValue {
+				if sample.Measurand != "" && sample.Measurand != "Energy.Active.Import.Register" {
+					continue
+				}
+				value, errValue := strconv.ParseFloat(sample.Value, 64)
+				if errValue != nil {
+					continue
+				}
+				if sample.Unit == "kWh" {
+					value *= 1000
+				}
+				p.meterWh = value
+			}
+		}
+		return map[string]string{}, ""
+	case "DataTransfer":
+		return map[string]string{"status": "UnknownVendorId"}, ""
+	}
+	return nil, "NotImplemented"
+}
+
+//handleMessage processes one OCPP-J message
+func (p *CentralSystem) handleMessage(msg string) error {
+	var frame []json.RawMessage
+	errParse := json.Unmarshal([]byte(msg), &frame)
+	if errParse != nil || len(frame) < 3 {
+		return fmt.Errorf("invalid ocpp message %s", msg)
+	}
+	var messageType int
+	var id string
+	json.Unmarshal(frame[0], &messageType)
+	json.Unmarshal(frame[1], &id)
+
+	switch messageType {
+	case OCPP_CALL:
+		var action string
+		json.Unmarshal(frame[2], &action)
+		var payload json.RawMessage
+		if 3 < len(frame) {
+			payload = frame[3]
+		}
+		result, errCode := p.handleCall(action, payload)
+		var reply []interface{}
+		if errCode != "" {
+			reply = []interface{}{OCPP_CALLERROR, id, errCode, action + " not handled", map[string]string{}}
+		} else {
+			reply = []interface{}{OCPP_CALLRESULT, id, result}
+		}
+		content, _ := json.Marshal(reply)
+		errWrite := p.ws.WriteText(string(content))
+		if errWrite != nil {
+			return errWrite
+		}
+		switch action {
+		case "BootNotification":
+			return p.pushProfile(true)
+		case "StartTransaction", "StopTransaction", "MeterValues":
+			return p.pushProfile(false)
+		}
+	case OCPP_CALLRESULT:
+		action := p.pending[id]
+		delete(p.pending, id)
+		var result struct {
+			Status string `json:"status"`
+		}
+		json.Unmarshal(frame[2], &result)
+		if action == "SetChargingProfile" && result.Status != "Accepted" {
+			p.err = fmt.Errorf("charging profile %s", result.Status)
+			p.sentProfile = "" //Try again on next check
+			fmt.Printf("ocpp %v\n", p.err.Error())
+		} else if action == "SetChargingProfile" {
+			p.err = nil
+		}
+	case OCPP_CALLERROR:
+		action := p.pending[id]
+		delete(p.pending, id)
+		var code string
+		json.Unmarshal(frame[2], &code)
+		p.err = fmt.Errorf("%s failed %s", action, code)
+		if action == "SetChargingProfile" {
+			p.sentProfile = ""
+		}
+		fmt.Printf("ocpp %v\n", p.err.Error())
+	default:
+		return fmt.Errorf("unknown ocpp message type %d", messageType)
+	}
+	return nil
+}
+
+//ServeHTTP accepts charge point websocket connection. New connection replaces old one
+func (p *CentralSystem) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	id := strings.TrimPrefix(r.URL.Path, OCPPPATH)
+	if id == "" || (p.Config.ChargePointId != "" && id != p.Config.ChargePointId) {
+		http.Error(w, "unknown charge point", http.StatusNotFound)
+		return
+	}
+	ws, errUpgrade := UpgradeWebsocket(w, r, OCPPSUBPROTOCOL)
+	if errUpgrade != nil {
+		fmt.Printf("ocpp %s %v\n", id, errUpgrade.Error())
+		return
+	}
+	defer ws.Close()
+
+	p.mutex.Lock()
+	if p.ws != nil {
+		p.ws.Close()
+	}
+	p.ws, p.chargePointId, p.sentProfile = ws, id, ""
+	p.pending = make(map[string]string)
+	p.mutex.Unlock()
+	fmt.Printf("ocpp charge point %s connected\n", id)
+
+	for {
+		msg, errRead := ws.ReadText()
+		if errRead != nil {
+			if errRead != io.EOF {
+				fmt.Printf("ocpp %s read err %v\n", id, errRead.Error())
+			}
+			break
+		}
+		p.mutex.Lock()
+		errHandle := p.handleMessage(msg)
+		p.mutex.Unlock()
+		if errHandle != nil {
+			fmt.Printf("ocpp %s %v\n", id, errHandle.Error())
+		}
+	}
+
+	p.mutex.Lock()
+	if p.ws == ws {
+		p.ws = nil
+		p.status = "Disconnected"
+		p.closeTransaction(p.meterWh)
+	}
+	p.mutex.Unlock()
+	fmt.Printf("ocpp charge point %s disconnected\n", id)
+}
+
+//SetRequest changes requested energy and departure and pushes new profile
+func (p *CentralSystem) SetRequest(energyKWh float64, departureClock string) error {
+	_, _, errClock := parseClock(departureClock)
+	if errClock != nil {
+		return errClock
+	}
+	if energyKWh < 0 {
+		return fmt.Errorf("energy must not be negative")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.energyKWh, p.departureClock = energyKWh, departureClock
+	departure, _ := nextClock(p.Now(), departureClock)
+	p.resetDelivered(departure)
+	return p.pushProfile(false)
+}
+
+//Run checks plan every OCPPCHECK_INTERVAL and pushes profile when it changes. Never returns
+func (p *CentralSystem) Run() {
+	for {
+		time.Sleep(OCPPCHECK_INTERVAL)
+		p.mutex.Lock()
+		errPush := p.pushProfile(false)
+		p.mutex.Unlock()
+		if errPush != nil {
+			fmt.Printf("ocpp %v\n", errPush.Error())
+		}
+	}
+}
+
+type ApiChargeHour struct {
+	Time      string  `json:"time"`
+	Price     float64 `json:"price"`
+	EnergyKWh float64 `json:"energyKWh"`
+}
+
+type ApiCharging struct {
+	ChargePoint  string          `json:"chargePoint"`
+	Connected    bool            `json:"connected"`
+	Status       string          `json:"status"`
+	Transaction  bool            `json:"transaction"`
+	RequestedKWh float64         `json:"requestedKWh"`
+	DeliveredKWh float64         `json:"deliveredKWh"`
+	Departure    string          `json:"departure"`
+	FullPower    bool            `json:"fullPower"`
+	CostEur      float64         `json:"costEur"`
+	Hours        []ApiChargeHour `json:"hours"`
+	Error        string          `json:"error,omitempty"`
+}
+
+//handleCharging is charging state and plan. POST with kwh and departure (HH:MM) changes request
+func (p *CentralSystem) handleCharging(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodPost {
+		energy, errEnergy := strconv.ParseFloat(r.FormValue("kwh"), 64)
+		if errEnergy != nil {
+			http.Error(w, "invalid kwh", http.StatusBadRequest)
+			return
+		}
+		errSet := p.SetRequest(energy, r.FormValue("departure"))
+		if errSet != nil {
+			http.Error(w, errSet.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	tNow := p.Now()
+	plan, errPlan := p.plan(tNow)
+	departure, _ := TimeInFinland(plan.Departure)
+	result := ApiCharging{
+		ChargePoint:  p.chargePointId,
+		Connected:    p.ws != nil,
+		Status:       p.status,
+		Transaction:  p.transactionId != 0,
+		RequestedKWh: p.energyKWh,
+		DeliveredKWh: p.deliveredKWh(),
+		Departure:    departure.Format(time.RFC3339),
+		FullPower:    plan.FullPower,
+		CostEur:      plan.CostEur,
+		Hours:        []ApiChargeHour{},
+	}
+	for _, hour := range plan.Hours {
+		lt, _ := TimeInFinland(hour.Start)
+		result.Hours = append(result.Hours, ApiChargeHour{Time: lt.Format(time.RFC3339), Price: hour.Price, EnergyKWh: hour.EnergyKWh})
+	}
+	if errPlan != nil {
+		result.Error = errPlan.Error()
+	} else if p.err != nil {
+		result.Error = p.err.Error()
+	}
+	writeJson(w, result)
+}
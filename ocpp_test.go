@@ -0,0 +1,271 @@
+package main
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+//testChargePoint is simulated OCPP 1.6J charge point over websocket
+type testChargePoint struct {
+	t        *testing.T
+	conn     net.Conn
+	reader   *bufio.Reader
+	nextId   int
+	profiles []OcppSetChargingProfile //Received from central system
+}
+
+func dialTestChargePoint(t *testing.T, serverUrl string, id string) *testChargePoint {
+	t.Helper()
+	conn, errDial := net.Dial("tcp", strings.TrimPrefix(serverUrl, "http://"))
+	if errDial != nil {
+		t.Fatal(errDial)
+	}
+	keyBytes := make([]byte, 16)
+	rand.Read(keyBytes)
+	fmt.Fprintf(conn, "GET %s%s HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n"+
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: %s\r\nSec-WebSocket-Protocol: %s\r\n\r\n",
+		OCPPPATH, id, base64.StdEncoding.EncodeToString(keyBytes), OCPPSUBPROTOCOL)
+	reader := bufio.NewReader(conn)
+	resp, errResp := http.ReadResponse(reader, nil)
+	if errResp != nil {
+		t.Fatal(errResp)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols || resp.Header.Get("Sec-WebSocket-Protocol") != OCPPSUBPROTOCOL {
+		t.Fatalf("websocket handshake failed %v", resp.Status)
+	}
+	return &testChargePoint{t: t, conn: conn, reader: reader}
+}
+
+//send writes masked text frame, like clients must
+func (p *testChargePoint) send(msg []byte) {
+	frame := []byte{0x80 | WS_TEXT}
+	if len(msg) < 126 {
+		frame = append(frame, 0x80|byte(len(msg)))
+	} else {
+		frame = append(frame, 0x80|126, byte(len(msg)>>8), byte(len(msg)))
+	}
+	mask := []byte{1, 2, 3, 4}
+	frame = append(frame, mask...)
+	for i, b := range msg {
+		frame = append(frame, b^mask[i%4])
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	_, errWrite := p.conn.Write(frame)
+	if errWrite != nil {
+		p.t.Fatal(errWrite)
+	}
+}
+
+func (p *testChargePoint) receive() []json.RawMessage {
+	p.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	head := make([]byte, 2)
+	_, errHead := io.ReadFull(p.reader, head)
+	if errHead != nil {
+		p.t.Fatal(errHead)
+	}
+	length := int(head[1] & 0x7F)
+	if length == 126 {
+		ext := make([]byte, 2)
+		io.ReadFull(p.reader, ext)
+		length = int(binary.BigEndian.Uint16(ext))
+	}
+	payload := make([]byte, length)
+	_, errPayload := io.ReadFull(p.reader, payload)
+	if errPayload != nil {
+		p.t.Fatal(errPayload)
+	}
+	var frame []json.RawMessage
+	errParse := json.Unmarshal(payload, &frame)
+	if errParse != nil {
+		p.t.Fatalf("invalid message %s", payload)
+	}
+	return frame
+}
+
+//call sends request and waits result. Calls from central system are answered with Accepted meanwhile
+func (p *testChargePoint) call(action string, payload interface{}) map[string]interface{} {
+	p.t.Helper()
+	p.nextId++
+	id := fmt.Sprintf("cp%d", p.nextId)
+	msg, _ := json.Marshal([]interface{}{OCPP_CALL, id, action, payload})
+	p.send(msg)
+	for {
+		frame := p.receive()
+		var messageType int
+		var frameId string
+		json.Unmarshal(frame[0], &messageType)
+		json.Unmarshal(frame[1], &frameId)
+		switch messageType {
+		case OCPP_CALL:
+			var profile OcppSetChargingProfile
+			json.Unmarshal(frame[3], &profile)
+			p.profiles = append(p.profiles, profile)
+			reply, _ := json.Marshal([]interface{}{OCPP_CALLRESULT, frameId, map[string]string{"status": "Accepted"}})
+			p.send(reply)
+		case OCPP_CALLRESULT:
+			if frameId != id {
+				p.t.Fatalf("result for %s, wanted %s", frameId, id)
+			}
+			result := make(map[string]interface{})
+			json.Unmarshal(frame[2], &result)
+			return result
+		default:
+			p.t.Fatalf("%s failed %s", action, frame[2])
+		}
+	}
+}
+
+func (p *testChargePoint) meterValues(transactionId int, wh float64) {
+	p.call("MeterValues", map[string]interface{}{
+		"connectorId":   1,
+		"transactionId": transactionId,
+		"meterValue": []interface{}{map[string]interface{}{
+			"timestamp":    "2026-10-19T17:00:00Z",
+			"sampledValue": []interface{}{map[string]string{"value": fmt.Sprint(wh), "measurand": "Energy.Active.Import.Register", "unit": "Wh"}},
+		}},
+	})
+}
+
+//testCharging reads state from api
+func testCharging(t *testing.T, cs *CentralSystem) ApiCharging {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	cs.handleCharging(rec, httptest.NewRequest(http.MethodGet, "/api/charging", nil))
+	var result ApiCharging
+	errParse := json.Unmarshal(rec.Body.Bytes(), &result)
+	if errParse != nil {
+		t.Fatal(errParse)
+	}
+	return result
+}
+
+func ocppTestSetup(t *testing.T) (*CentralSystem, *httptest.Server) {
+	night := rampPrices(10, 0)
+	night[2], night[3], night[4] = 1, 2, 3
+	store := testStore(t, "2026-10-19", rampPrices(10, 0), night)
+	cs := NewCentralSystem(OcppConfig{ChargePointId: "garage", ConnectorId: 1, MaxPowerW: 11000, EnergyKWh: 20, Departure: "07:00"}, store)
+	cs.Now = func() time.Time { return testClock(t, "20:00") }
+	srv := httptest.NewServer(http.HandlerFunc(cs.ServeHTTP))
+	t.Cleanup(srv.Close)
+	return cs, srv
+}
+
+func TestOcppBootProfile(t *testing.T) {
+	cs, srv := ocppTestSetup(t)
+	cp := dialTestChargePoint(t, srv.URL, "garage")
+	defer cp.conn.Close()
+	boot := cp.call("BootNotification", map[string]string{"chargePointVendor": "test", "chargePointModel": "sim"})
+	if boot["status"] != "Accepted" {
+		t.Fatalf("boot %v", boot)
+	}
+	cp.call("Heartbeat", map[string]string{}) //Profile call is answered while waiting
+	if len(cp.profiles) != 1 {
+		t.Fatalf("got %d profiles after boot, wanted 1", len(cp.profiles))
+	}
+	schedule := cp.profiles[0].CsChargingProfiles.ChargingSchedule
+	//20 kWh with 11 kW needs two cheapest hours 02 and 03 of tomorrow, starting 6h and 7h from 20:00
+	wanted := []OcppSchedulePeriod{{StartPeriod: 0, Limit: 0}, {StartPeriod: 6 * 3600, Limit: 11000}, {StartPeriod: 8 * 3600, Limit: 0}}
+	if fmt.Sprint(schedule.ChargingSchedulePeriod) != fmt.Sprint(wanted) {
+		t.Errorf("schedule %v, wanted %v", schedule.ChargingSchedulePeriod, wanted)
+	}
+	if state := testCharging(t, cs); !state.Connected || state.ChargePoint != "garage" {
+		t.Errorf("unexpected state %+v", state)
+	}
+
+	errOther := ocppHandshakeErr(srv.URL, "neighbour")
+	if errOther == nil || !strings.Contains(errOther.Error(), "404") {
+		t.Errorf("unknown charge point should be rejected, got %v", errOther)
+	}
+}
+
+//ocppHandshakeErr tries connecting without websocket headers, error tells why it failed
+func ocppHandshakeErr(serverUrl string, id string) error {
+	resp, errGet := http.Get(serverUrl + OCPPPATH + id)
+	if errGet != nil {
+		return errGet
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return fmt.Errorf("%v", resp.Status)
+	}
+	return nil
+}
+
+func TestOcppTransactions(t *testing.T) {
+	cs, srv := ocppTestSetup(t)
+	cp := dialTestChargePoint(t, srv.URL, "garage")
+	cp.call("BootNotification", map[string]string{"chargePointVendor": "test", "chargePointModel": "sim"})
+
+	start := cp.call("StartTransaction", map[string]interface{}{"connectorId": 1, "idTag": "car", "meterStart": 1000, "timestamp": "2026-10-19T17:00:00Z"})
+	firstId := int(start["transactionId"].(float64))
+	cp.meterValues(firstId, 6000)
+	state := testCharging(t, cs)
+	if !state.Transaction || state.DeliveredKWh != 5 {
+		t.Fatalf("after meter values %+v", state)
+	}
+
+	cp.call("StopTransaction", map[string]interface{}{"transactionId": firstId, "meterStop": 7000, "timestamp": "2026-10-19T17:10:00Z"})
+	state = testCharging(t, cs)
+	if state.Transaction || state.DeliveredKWh != 6 {
+		t.Fatalf("energy of stopped transaction must stay delivered %+v", state)
+	}
+	plan, _ := cs.plan(cs.Now())
+	if plan.EnergyKWh != 14 {
+		t.Errorf("planned %v kWh after stop, wanted 14", plan.EnergyKWh)
+	}
+
+	start = cp.call("StartTransaction", map[string]interface{}{"connectorId": 1, "idTag": "car", "meterStart": 7000, "timestamp": "2026-10-19T17:20:00Z"})
+	secondId := int(start["transactionId"].(float64))
+	if secondId == firstId {
+		t.Fatalf("transaction id reused")
+	}
+	cp.meterValues(secondId, 8000)
+
+	//Disconnect ends counting of transaction
+	cp.conn.Close()
+	for try := 0; testCharging(t, cs).Connected; try++ {
+		if 100 < try {
+			t.Fatalf("disconnect not noticed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	state = testCharging(t, cs)
+	if state.Transaction || state.DeliveredKWh != 7 || state.Status != "Disconnected" {
+		t.Fatalf("after disconnect %+v", state)
+	}
+
+	//Charge point reconnects and continues same transaction
+	cp = dialTestChargePoint(t, srv.URL, "garage")
+	defer cp.conn.Close()
+	cp.call("BootNotification", map[string]string{"chargePointVendor": "test", "chargePointModel": "sim"})
+	cp.meterValues(secondId, 9000)
+	state = testCharging(t, cs)
+	if !state.Transaction || state.DeliveredKWh != 8 {
+		t.Fatalf("after reconnect %+v", state)
+	}
+	cp.call("StopTransaction", map[string]interface{}{"transactionId": secondId, "meterStop": 9500, "timestamp": "2026-10-19T18:00:00Z"})
+	state = testCharging(t, cs)
+	if state.Transaction || state.DeliveredKWh != 8.5 {
+		t.Fatalf("after stop %+v", state)
+	}
+
+	//New request starts counting from zero
+	errSet := cs.SetRequest(10, "07:00")
+	if errSet != nil {
+		t.Fatal(errSet)
+	}
+	if state = testCharging(t, cs); state.DeliveredKWh != 0 || state.RequestedKWh != 10 {
+		t.Errorf("after new request %+v", state)
+	}
+}
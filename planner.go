@@ -0,0 +1,166 @@
+/*
+Planner, answers "when should dishwasher / EV / sauna run for N hours"
+Finds cheapest contiguous window from known prices (today and tomorrow if published)
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//HourRange is range of hours in day (finnish time). Start inclusive, End exclusive. Wraps over midnight if End<=Start
+type HourRange struct {
+	Start int
+	End   int
+}
+
+func (p HourRange) Contains(hour int) bool {
+	if p.Start < p.End {
+		return p.Start <= hour && hour < p.End
+	}
+	return p.Start <= hour || hour < p.End
+}
+
+//ParseHourRanges parses list like "22-07,10-14". Empty string is all day
+func ParseHourRanges(s string) ([]HourRange, error) {
+	result := []HourRange{}
+	if strings.TrimSpace(s) == "" {
+		return result, nil
+	}
+	for _, part := range strings.Split(s, ",") {
+		limits := strings.Split(strings.TrimSpace(part), "-")
+		if len(limits) != 2 {
+			return nil, fmt.Errorf("invalid hour range %s, use like 22-07", part)
+		}
+		start, errStart := strconv.Atoi(limits[0])
+		end, errEnd := strconv.Atoi(limits[1])
+		if errStart != nil || errEnd != nil || start < 0 || 23 < start || end < 0 || 24 < end {
+			return nil, fmt.Errorf("invalid hour range %s", part)
+		}
+		result = append(result, HourRange{Start: start, End: end % 24})
+	}
+	return result, nil
+}
+
+func hourAllowed(t time.Time, allowed []HourRange) bool {
+	if len(allowed) == 0 {
+		return true
+	}
+	lt, _ := TimeInFinland(t)
+	for _, r := range allowed {
+		if r.Contains(lt.Hour()) {
+			return true
+		}
+	}
+	return false
+}
+
+type HourWindow struct {
+	Start   time.Time
+	Hours   int
+	Average float64
+	Prices  []HourPrice
+}
+
+func (p *HourWindow) End() time.Time {
+	return p.Start.Add(time.Duration(p.Hours) * time.Hour)
+}
+
+//upcomingPrices drops hours that have already ended
+func upcomingPrices(prices []HourPrice, tNow time.Time) []HourPrice {
+	result := []HourPrice{}
+	for _, hp := range prices {
+		if tNow.Before(hp.Start.Add(time.Hour)) {
+			result = append(result, hp)
+		}
+	}
+	return result
+}
+
+/*
+CheapestWindow finds cheapest contiguous window of hours length. Every hour of window must be in allowed ranges
+(all allowed if empty). Prices must be sorted by time
+*/
+func CheapestWindow(prices []HourPrice, hours int, allowed []HourRange) (HourWindow, error) {
+	if hours < 1 {
+		return HourWindow{}, fmt.Errorf("window length must be at least one hour")
+	}
+	best := HourWindow{Average: math.Inf(1)}
+	for first := 0; first+hours <= len(prices); first++ {
+		sum := float64(0)
+		valid := true
+		for i := first; i < first+hours; i++ {
+			if !hourAllowed(prices[i].Start, allowed) || (first < i && !prices[i].Start.Equal(prices[i-1].Start.Add(time.Hour))) {
+				valid = false
+				break
+			}
+			sum += prices[i].Price
+		}
+		if valid && sum/float64(hours) < best.Average {
+			best = HourWindow{Start: prices[first].Start, Hours: hours, Average: sum / float64(hours), Prices: prices[first : first+hours]}
+		}
+	}
+	if best.Hours == 0 {
+		return best, fmt.Errorf("no %v hour window available in known %v hours", hours, len(prices))
+	}
+	return best, nil
+}
+
+//PlanWindow is cheapest window from current hour onwards
+func (p *PriceStore) PlanWindow(tNow time.Time, hours int, allowed []HourRange) (HourWindow, error) {
+	horizon, errHorizon := p.ShownHorizon(tNow)
+	if errHorizon != nil {
+		return HourWindow{}, errHorizon
+	}
+	return CheapestWindow(upcomingPrices(horizon, tNow), hours, allowed)
+}
+
+//ShowWindow plans window and shows it on view as bracket. Failed plan is not fatal for view
+func (p *PriceStore) ShowWindow(pw *PriceView, tNow time.Time, hours int, allowed []HourRange) {
+	if hours < 1 {
+		return
+	}
+	window, errWindow := p.PlanWindow(tNow, hours, allowed)
+	if errWindow != nil {
+		fmt.Printf("window planning failed %v\n", errWindow.Error())
+		return
+	}
+	pw.SetWindow(window)
+}
+
+//cmdCheapest is "cheapest" subcommand
+func cmdCheapest(args []string) error {
+	fs := flag.NewFlagSet("cheapest", flag.ExitOnError)
+	pHours := fs.Int("hours", 3, "length of window in hours")
+	pAllowed := fs.String("allowed", "", "allowed hour ranges like 22-07,10-14 (default all day)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	getVatMode := vatFlags(fs)
+	fs.Parse(args)
+
+	allowed, errAllowed := ParseHourRanges(*pAllowed)
+	if errAllowed != nil {
+		return errAllowed
+	}
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		return errVat
+	}
+	waitClock()
+
+	store := NewPriceStore(*pCacheDirName)
+	store.Vat = vatMode
+	window, errWindow := store.PlanWindow(time.Now(), *pHours, allowed)
+	if errWindow != nil {
+		return errWindow
+	}
+	start, _ := TimeInFinland(window.Start)
+	end, _ := TimeInFinland(window.End())
+	fmt.Printf("cheapest %vh window %s %s - %s average %.2f %s\n", window.Hours,
+		FinnishWeekDayName(start), start.Format("2006-01-02 15:04"), end.Format("15:04"), window.Average, VATTENFALLEXPECTED_UNIT)
+	return nil
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseHourRanges(t *testing.T) {
+	tests := []struct {
+		s      string
+		wanted []HourRange //nil if error
+	}{
+		{"", []HourRange{}},
+		{"22-07", []HourRange{{22, 7}}},
+		{"10-14, 22-24", []HourRange{{10, 14}, {22, 0}}},
+		{"5-5", []HourRange{{5, 5}}},
+		{"22", nil},
+		{"25-3", nil},
+		{"3-25", nil},
+		{"a-b", nil},
+		{"-1-3", nil},
+	}
+	for _, test := range tests {
+		result, errParse := ParseHourRanges(test.s)
+		if test.wanted == nil {
+			if errParse == nil {
+				t.Errorf("%q was accepted as %v", test.s, result)
+			}
+			continue
+		}
+		if errParse != nil || !reflect.DeepEqual(result, test.wanted) {
+			t.Errorf("%q parsed %v err %v, wanted %v", test.s, result, errParse, test.wanted)
+		}
+	}
+}
+
+func TestHourRangeContains(t *testing.T) {
+	tests := []struct {
+		r   HourRange
+		in  []int
+		out []int
+	}{
+		{HourRange{22, 7}, []int{22, 23, 0, 6}, []int{7, 12, 21}},
+		{HourRange{10, 14}, []int{10, 13}, []int{9, 14}},
+		{HourRange{22, 0}, []int{22, 23}, []int{0, 21}},
+		{HourRange{5, 5}, []int{0, 4, 5, 6, 23}, []int{}}, //All day
+	}
+	for _, test := range tests {
+		for _, hour := range test.in {
+			if !test.r.Contains(hour) {
+				t.Errorf("%v does not contain %d", test.r, hour)
+			}
+		}
+		for _, hour := range test.out {
+			if test.r.Contains(hour) {
+				t.Errorf("%v contains %d", test.r, hour)
+			}
+		}
+	}
+}
+
+func TestCheapestWindow(t *testing.T) {
+	today := rampPrices(30, -1) //Hour 10 is 20, 13 is 17
+	today[22], today[23] = 1, 1
+	tomorrow := rampPrices(20, 0)
+	tomorrow[0] = 1
+	store := testStore(t, "2026-10-19", today, tomorrow)
+	tNow := testClock(t, "09:30")
+	horizon, errHorizon := store.Horizon(tNow)
+	if errHorizon != nil {
+		t.Fatal(errHorizon)
+	}
+	upcoming := upcomingPrices(horizon, tNow)
+	if !upcoming[0].Start.Equal(testClock(t, "09:00")) {
+		t.Fatalf("upcoming starts %v, wanted current hour", upcoming[0].Start)
+	}
+
+	tests := []struct {
+		hours   int
+		allowed string
+		start   string //Clock of today
+		average float64
+	}{
+		{3, "", "22:00", 1},             //Spans today and tomorrow
+		{3, "22-07", "22:00", 1},        //Wraps past midnight
+		{3, "23-07", "23:00", 22.0 / 3}, //Starting hour limits window
+		{3, "10-14", "11:00", 18},       //Today is cheaper than tomorrow 20
+		{2, "12-12", "22:00", 1},        //All day
+		{1, "01-22", "21:00", 9},        //Cheapest allowed single hour is on today, tomorrow is 20
+	}
+	for _, test := range tests {
+		allowed, errAllowed := ParseHourRanges(test.allowed)
+		if errAllowed != nil {
+			t.Fatal(errAllowed)
+		}
+		window, errWindow := CheapestWindow(upcoming, test.hours, allowed)
+		if errWindow != nil {
+			t.Errorf("%dh %q err %v", test.hours, test.allowed, errWindow)
+			continue
+		}
+		if !window.Start.Equal(testClock(t, test.start)) || 1e-9 < window.Average-test.average || 1e-9 < test.average-window.Average || len(window.Prices) != test.hours {
+			t.Errorf("%dh %q window %v average %v, wanted %s average %v", test.hours, test.allowed, window.Start, window.Average, test.start, test.average)
+		}
+	}
+
+	for _, hours := range []int{0, len(upcoming) + 1} {
+		_, errWindow := CheapestWindow(upcoming, hours, nil)
+		if errWindow == nil {
+			t.Errorf("%dh window accepted", hours)
+		}
+	}
+	onlyNight, _ := ParseHourRanges("02-04")
+	_, errWindow := CheapestWindow(upcoming, 3, onlyNight)
+	if errWindow == nil {
+		t.Errorf("3h window accepted in 2h allowed range")
+	}
+}
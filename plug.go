@@ -0,0 +1,316 @@
+/*
+Smart plug control over local HTTP. Supports Shelly Gen1 (/relay api), Shelly Gen2 (RPC api) and Tasmota (/cm api).
+Plug follows expensive/cheap classification of chart. State is read back after every switch and checked periodically,
+so manual switching is also corrected
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	PLUGRETRIES    = 3
+	PLUGRETRYDELAY = 2 * time.Second
+	PLUGTIMEOUT    = 5 * time.Second
+)
+
+type PlugConfig struct {
+	Name           string `json:"name"`
+	Type           string `json:"type"`              //shelly1, shelly2 or tasmota
+	Address        string `json:"address"`           //host[:port] or base url like http://192.168.1.20
+	Channel        int    `json:"channel,omitempty"` //Relay index on multi channel devices. Shelly starts from 0, tasmota from 1 (0 is single relay)
+	User           string `json:"user,omitempty"`
+	Password       string `json:"password,omitempty"`
+	OnDuring       string `json:"onDuring"`                 //"cheap" (off during expensive hours) or "expensive"
+	ExpensiveHours int    `json:"expensiveHours,omitempty"` //Expensive hours per day, default same as chart (-e)
+	FailSafe       string `json:"failSafe"`                 //"on" or "off" when no prices are available. Default on
+}
+
+//plugDrivers create driver by type
+var plugDrivers = map[string]func(conf PlugConfig) PlugDriver{
+	"shelly1": func(conf PlugConfig) PlugDriver { return &ShellyGen1{plugHttp: newPlugHttp(conf)} },
+	"shelly2": func(conf PlugConfig) PlugDriver { return &ShellyGen2{plugHttp: newPlugHttp(conf)} },
+	"tasmota": func(conf PlugConfig) PlugDriver { return &Tasmota{plugHttp: newPlugHttp(conf)} },
+}
+
+func (p *PlugConfig) CheckErr() error {
+	if p.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	_, knownType := plugDrivers[p.Type]
+	if !knownType {
+		return fmt.Errorf("%s unknown type %s, use shelly1, shelly2 or tasmota", p.Name, p.Type)
+	}
+	if p.Address == "" {
+		return fmt.Errorf("%s address missing", p.Name)
+	}
+	if p.OnDuring != "cheap" && p.OnDuring != "expensive" {
+		return fmt.Errorf("%s onDuring must be cheap or expensive", p.Name)
+	}
+	if p.FailSafe != "" && p.FailSafe != "on" && p.FailSafe != "off" {
+		return fmt.Errorf("%s failSafe must be on or off", p.Name)
+	}
+	if p.ExpensiveHours < 0 || 24 < p.ExpensiveHours || p.Channel < 0 {
+		return fmt.Errorf("%s invalid expensiveHours or channel", p.Name)
+	}
+	return nil
+}
+
+//PlugDriver is one device api
+type PlugDriver interface {
+	Set(on bool) error
+	Get() (bool, error)
+}
+
+//plugHttp is common part of http drivers
+type plugHttp struct {
+	Base     string
+	Channel  int
+	User     string
+	Password string
+	client   *http.Client
+}
+
+func newPlugHttp(conf PlugConfig) plugHttp {
+	base := strings.TrimSuffix(conf.Address, "/")
+	if !strings.Contains(base, "://") {
+		base = "http://" + base
+	}
+	return plugHttp{Base: base, Channel: conf.Channel, User: conf.User, Password: conf.Password, client: &http.Client{Timeout: PLUGTIMEOUT}}
+}
+
+//getJson does GET and parses json response to result
+func (p *plugHttp) getJson(pathAndQuery string, basicAuth bool, result interface{}) error {
+	req, errReq := http.NewRequest("GET", p.Base+pathAndQuery, nil)
+	if errReq != nil {
+		return errReq
+	}
+	if basicAuth && p.User != "" {
+		req.SetBasicAuth(p.User, p.Password)
+	}
+	resp, errDo := p.client.Do(req)
+	if errDo != nil {
+		return errDo
+	}
+	defer resp.Body.Close()
+	body, errBody := io.ReadAll(resp.Body)
+	if errBody != nil {
+		return errBody
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s %s %s", pathAndQuery, resp.Status, body)
+	}
+	errParse := json.Unmarshal(body, result)
+	if errParse != nil {
+		return fmt.Errorf("invalid response %s %v", body, errParse.Error())
+	}
+	return nil
+}
+
+//ShellyGen1 uses /relay/N api
+type ShellyGen1 struct {
+	plugHttp
+}
+
+type shellyGen1Relay struct {
+	IsOn bool `json:"ison"`
+}
+
+func (p *ShellyGen1) Set(on bool) error {
+	turn := "off"
+	if on {
+		turn = "on"
+	}
+	var resp shellyGen1Relay
+	return p.getJson(fmt.Sprintf("/relay/%d?turn=%s", p.Channel, turn), true, &resp)
+}
+
+func (p *ShellyGen1) Get() (bool, error) {
+	var resp shellyGen1Relay
+	errGet := p.getJson(fmt.Sprintf("/relay/%d", p.Channel), true, &resp)
+	return resp.IsOn, errGet
+}
+
+//ShellyGen2 uses RPC over http GET. Authentication (digest) is not supported
+type ShellyGen2 struct {
+	plugHttp
+}
+
+func (p *ShellyGen2) Set(on bool) error {
+	var resp struct {
+		WasOn bool `json:"was_on"`
+	}
+	return p.getJson(fmt.Sprintf("/rpc/Switch.Set?id=%d&on=%v", p.Channel, on), false, &resp)
+}
+
+func (p *ShellyGen2) Get() (bool, error) {
+	var resp struct {
+		Output bool `json:"output"`
+	}
+	errGet := p.getJson(fmt.Sprintf("/rpc/Switch.GetStatus?id=%d", p.Channel), false, &resp)
+	return resp.Output, errGet
+}
+
+//Tasmota uses /cm?cmnd=PowerN api. Credentials are given as query parameters
+type Tasmota struct {
+	plugHttp
+}
+
+func (p *Tasmota) command(cmnd string) (bool, error) {
+	query := url.Values{}
+	query.Set("cmnd", cmnd)
+	if p.User != "" {
+		query.Set("user", p.User)
+		query.Set("password", p.Password)
+	}
+	resp := make(map[string]interface{})
+	errGet := p.getJson("/cm?"+query.Encode(), false, &resp)
+	if errGet != nil {
+		return false, errGet
+	}
+	//Single relay devices answer POWER even when asked with Power1
+	for _, key := range []string{fmt.Sprintf("POWER%d", p.Channel), "POWER"} {
+		state, found := resp[key]
+		if found {
+			return state == "ON", nil
+		}
+	}
+	return false, fmt.Errorf("no power state in tasmota response %v", resp)
+}
+
+func (p *Tasmota) powerCommand() string {
+	if p.Channel == 0 {
+		return "Power"
+	}
+	return fmt.Sprintf("Power%d", p.Channel)
+}
+
+func (p *Tasmota) Set(on bool) error {
+	state := "OFF"
+	if on {
+		state = "ON"
+	}
+	_, errCmd := p.command(p.powerCommand() + " " + state)
+	return errCmd
+}
+
+func (p *Tasmota) Get() (bool, error) {
+	return p.command(p.powerCommand())
+}
+
+type PlugController struct {
+	Config             PlugConfig
+	Driver             PlugDriver
+	ExpensiveHourCount int //Default when config does not have expensiveHours
+
+	mutex  sync.Mutex
+	on     bool
+	reason string
+	err    error
+}
+
+func NewPlugController(conf PlugConfig, expensiveHourCount int) *PlugController {
+	return &PlugController{Config: conf, Driver: plugDrivers[conf.Type](conf), ExpensiveHourCount: expensiveHourCount}
+}
+
+//State is latest wanted state, reason and error of last update
+func (p *PlugController) State() (bool, string, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.on, p.reason, p.err
+}
+
+//Wanted decides state with same expensive classification as chart
+func (p *PlugController) Wanted(today []HourPrice, tNow time.Time) (bool, string) {
+	n := p.Config.ExpensiveHours
+	if n == 0 {
+		n = p.ExpensiveHourCount
+	}
+	flags := expensiveFlags(today, n)
+	for i, hp := range today {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		if flags[i] {
+			return p.Config.OnDuring == "expensive", fmt.Sprintf("one of %d expensive hours", n)
+		}
+		return p.Config.OnDuring == "cheap", fmt.Sprintf("not one of %d expensive hours", n)
+	}
+	return p.Config.FailSafe != "off", "fail-safe, no price for current hour"
+}
+
+//apply reads state from device and switches if needed. Switching is verified by reading back
+func (p *PlugController) apply(on bool) error {
+	var errLast error
+	for try := 0; try < PLUGRETRIES; try++ {
+		if 0 < try {
+			time.Sleep(PLUGRETRYDELAY)
+		}
+		state, errGet := p.Driver.Get()
+		if errGet != nil {
+			errLast = fmt.Errorf("read err %v", errGet.Error())
+			continue
+		}
+		if state == on {
+			return nil
+		}
+		errSet := p.Driver.Set(on)
+		if errSet != nil {
+			errLast = fmt.Errorf("switch err %v", errSet.Error())
+			continue
+		}
+		state, errGet = p.Driver.Get()
+		if errGet != nil {
+			errLast = fmt.Errorf("read back err %v", errGet.Error())
+			continue
+		}
+		if state == on {
+			fmt.Printf("plug %s switched on=%v\n", p.Config.Name, on)
+			return nil
+		}
+		errLast = fmt.Errorf("read back on=%v, wanted on=%v", state, on)
+	}
+	return fmt.Errorf("plug %s failed after %d tries, %v", p.Config.Name, PLUGRETRIES, errLast.Error())
+}
+
+//Update decides and applies state. Prices err means prices are not available
+func (p *PlugController) Update(today []HourPrice, pricesErr error, tNow time.Time) error {
+	on, reason := p.Config.FailSafe != "off", ""
+	if pricesErr != nil {
+		reason = "fail-safe, " + pricesErr.Error()
+	} else {
+		on, reason = p.Wanted(today, tNow)
+	}
+	errApply := p.apply(on)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if on != p.on || reason != p.reason {
+		fmt.Printf("plug %s on=%v (%s)\n", p.Config.Name, on, reason)
+	}
+	p.on, p.reason, p.err = on, reason, errApply
+	return errApply
+}
+
+//RunPlugs updates all plugs every RELAYCHECK_INTERVAL. Never returns
+func RunPlugs(store *PriceStore, plugs []*PlugController) {
+	for {
+		tNow := time.Now()
+		today, errToday := store.DayPrices(tNow)
+		for _, plug := range plugs {
+			errUpdate := plug.Update(today, errToday, tNow)
+			if errUpdate != nil {
+				fmt.Printf("%v\n", errUpdate.Error())
+			}
+		}
+		time.Sleep(RELAYCHECK_INTERVAL)
+	}
+}
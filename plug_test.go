@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+//plugStandIn emulates relay of shelly gen1, shelly gen2 or tasmota device
+type plugStandIn struct {
+	mutex    sync.Mutex
+	on       bool
+	switches int
+}
+
+func (p *plugStandIn) set(on bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if on != p.on {
+		p.switches++
+	}
+	p.on = on
+}
+
+func (p *plugStandIn) state() bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.on
+}
+
+func (p *plugStandIn) handler(plugType string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		switch plugType {
+		case "shelly1":
+			user, password, _ := r.BasicAuth()
+			if user != "admin" || password != "secret" {
+				http.Error(w, "unauthorized", http.StatusUnauthorized)
+				return
+			}
+			if r.URL.Path != "/relay/1" {
+				http.NotFound(w, r)
+				return
+			}
+			if query.Get("turn") != "" {
+				p.set(query.Get("turn") == "on")
+			}
+			json.NewEncoder(w).Encode(map[string]interface{}{"ison": p.state()})
+		case "shelly2":
+			if query.Get("id") != "0" {
+				http.Error(w, "invalid id", http.StatusBadRequest)
+				return
+			}
+			switch r.URL.Path {
+			case "/rpc/Switch.Set":
+				wasOn := p.state()
+				p.set(query.Get("on") == "true")
+				json.NewEncoder(w).Encode(map[string]interface{}{"was_on": wasOn})
+			case "/rpc/Switch.GetStatus":
+				json.NewEncoder(w).Encode(map[string]interface{}{"id": 0, "output": p.state()})
+			default:
+				http.NotFound(w, r)
+			}
+		case "tasmota":
+			if r.URL.Path != "/cm" || query.Get("user") != "admin" {
+				http.NotFound(w, r)
+				return
+			}
+			cmnd := strings.Fields(query.Get("cmnd"))
+			if len(cmnd) == 2 {
+				p.set(cmnd[1] == "ON")
+			}
+			state := "OFF"
+			if p.state() {
+				state = "ON"
+			}
+			json.NewEncoder(w).Encode(map[string]string{"POWER": state}) //Single relay answers POWER
+		}
+	}
+}
+
+func TestPlugDrivers(t *testing.T) {
+	configs := []PlugConfig{
+		{Name: "gen1", Type: "shelly1", Channel: 1, User: "admin", Password: "secret", OnDuring: "cheap"},
+		{Name: "gen2", Type: "shelly2", OnDuring: "cheap"},
+		{Name: "tasmota", Type: "tasmota", Channel: 1, User: "admin", Password: "secret", OnDuring: "cheap"},
+	}
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	for _, conf := range configs {
+		device := &plugStandIn{}
+		srv := httptest.NewServer(device.handler(conf.Type))
+		conf.Address = strings.TrimPrefix(srv.URL, "http://")
+		errCheck := conf.CheckErr()
+		if errCheck != nil {
+			t.Fatal(errCheck)
+		}
+		plug := NewPlugController(conf, 6)
+
+		errUpdate := plug.Update(today, nil, testClock(t, "03:00"))
+		if errUpdate != nil || !device.state() {
+			t.Errorf("%s should be on at cheap hour, err %v", conf.Name, errUpdate)
+		}
+		errUpdate = plug.Update(today, nil, testClock(t, "22:00"))
+		if errUpdate != nil || device.state() {
+			t.Errorf("%s should be off at expensive hour, err %v", conf.Name, errUpdate)
+		}
+
+		device.set(true) //Manual switching is corrected
+		errUpdate = plug.Update(today, nil, testClock(t, "22:30"))
+		if errUpdate != nil || device.state() || device.switches != 4 {
+			t.Errorf("%s manual switch not corrected, switches %d err %v", conf.Name, device.switches, errUpdate)
+		}
+
+		errUpdate = plug.Update(nil, fmt.Errorf("no prices"), testClock(t, "23:00"))
+		if errUpdate != nil || !device.state() {
+			t.Errorf("%s should be on by default fail-safe, err %v", conf.Name, errUpdate)
+		}
+		srv.Close()
+	}
+}
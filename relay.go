@@ -0,0 +1,143 @@
+/*
+Relay control for water boiler etc.. GPIO output is on during N cheapest hours of day
+or when price is below threshold. Fail-safe state is used when prices are not available
+*/
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+	"periph.io/x/conn/v3/gpio/gpioreg"
+	"periph.io/x/host/v3"
+)
+
+const RELAYCHECK_INTERVAL = time.Minute
+
+type RelayConfig struct {
+	Name          string   `json:"name"`
+	Pin           string   `json:"pin"`                  //periph.io gpio name like GPIO23
+	ActiveLow     bool     `json:"activeLow,omitempty"`  //Relay board turns on with low output
+	CheapestHours int      `json:"cheapestHours"`        //On during this many cheapest hours of day
+	PriceBelow    *float64 `json:"priceBelow,omitempty"` //Also on when price is below this (c/kWh), optional
+	MinOnMinutes  int      `json:"minOnMinutes"`         //Once turned on, keep on at least this long
+	FailSafe      string   `json:"failSafe"`             //"on" or "off" when no prices are available. Default on
+}
+
+func (p *RelayConfig) CheckErr() error {
+	if p.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if p.Pin == "" {
+		return fmt.Errorf("%s pin missing", p.Name)
+	}
+	if p.FailSafe != "" && p.FailSafe != "on" && p.FailSafe != "off" {
+		return fmt.Errorf("%s failSafe must be on or off", p.Name)
+	}
+	if p.CheapestHours < 0 || 24 < p.CheapestHours {
+		return fmt.Errorf("%s cheapestHours must be 0-24", p.Name)
+	}
+	return nil
+}
+
+//OutputPin is part of gpio.PinIO that relay needs. Easy to replace with fake
+type OutputPin interface {
+	Out(l gpio.Level) error
+}
+
+//OpenOutputPin finds pin by periph.io name
+func OpenOutputPin(name string) (OutputPin, error) {
+	_, errInit := host.Init()
+	if errInit != nil {
+		return nil, errInit
+	}
+	pin := gpioreg.ByName(name)
+	if pin == nil {
+		return nil, fmt.Errorf("pin %s not found", name)
+	}
+	return pin, nil
+}
+
+type RelayController struct {
+	Config RelayConfig
+	Pin    OutputPin
+
+	mutex   sync.Mutex
+	on      bool
+	reason  string
+	onSince time.Time
+}
+
+//State is latest output state and reason for it
+func (p *RelayController) State() (bool, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.on, p.reason
+}
+
+//Wanted decides state from todays prices. Min on time is not considered here
+func (p *RelayController) Wanted(today []HourPrice, tNow time.Time) (bool, string) {
+	for _, hp := range today {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		if p.Config.PriceBelow != nil && hp.Price < *p.Config.PriceBelow {
+			return true, fmt.Sprintf("price %.2f below %.2f", hp.Price, *p.Config.PriceBelow)
+		}
+		for _, cheap := range cheapestHours(today, p.Config.CheapestHours) {
+			if cheap.Start.Equal(hp.Start) {
+				return true, fmt.Sprintf("one of %d cheapest hours", p.Config.CheapestHours)
+			}
+		}
+		return false, fmt.Sprintf("price %.2f not cheap", hp.Price)
+	}
+	return p.Config.FailSafe != "off", "fail-safe, no price for current hour"
+}
+
+//Update sets output. Prices err means prices are not available
+func (p *RelayController) Update(today []HourPrice, pricesErr error, tNow time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	on, reason := false, ""
+	if pricesErr != nil {
+		on, reason = p.Config.FailSafe != "off", "fail-safe, "+pricesErr.Error()
+	} else {
+		on, reason = p.Wanted(today, tNow)
+		minOn := time.Duration(p.Config.MinOnMinutes) * time.Minute
+		if !on && p.on && tNow.Before(p.onSince.Add(minOn)) {
+			on, reason = true, fmt.Sprintf("minimum on time %v", minOn)
+		}
+	}
+
+	if on && !p.on {
+		p.onSince = tNow
+	}
+	if on != p.on || reason != p.reason {
+		fmt.Printf("relay %s on=%v (%s)\n", p.Config.Name, on, reason)
+	}
+	p.on, p.reason = on, reason
+
+	level := gpio.Level(on != p.Config.ActiveLow)
+	errOut := p.Pin.Out(level)
+	if errOut != nil {
+		return fmt.Errorf("relay %s output err %v", p.Config.Name, errOut.Error())
+	}
+	return nil
+}
+
+//RunRelays updates all relays every RELAYCHECK_INTERVAL. Never returns
+func RunRelays(store *PriceStore, relays []*RelayController) {
+	for {
+		tNow := time.Now()
+		today, errToday := store.DayPrices(tNow)
+		for _, relay := range relays {
+			errUpdate := relay.Update(today, errToday, tNow)
+			if errUpdate != nil {
+				fmt.Printf("%v\n", errUpdate.Error())
+			}
+		}
+		time.Sleep(RELAYCHECK_INTERVAL)
+	}
+}
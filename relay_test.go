@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+//fakePin records output levels
+type fakePin struct {
+	levels []gpio.Level
+	err    error
+}
+
+func (p *fakePin) Out(l gpio.Level) error {
+	if p.err != nil {
+		return p.err
+	}
+	p.levels = append(p.levels, l)
+	return nil
+}
+
+func (p *fakePin) last() gpio.Level {
+	return p.levels[len(p.levels)-1]
+}
+
+func TestRelayCheapestHours(t *testing.T) {
+	prices := rampPrices(30, -1) //Last hours are cheapest
+	store := testStore(t, "2026-10-19", prices)
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	pin := &fakePin{}
+	relay := RelayController{Config: RelayConfig{Name: "boiler", Pin: "GPIO23", CheapestHours: 4, MinOnMinutes: 90}, Pin: pin}
+
+	steps := []struct {
+		clock string
+		on    bool
+	}{
+		{"12:00", false},
+		{"20:30", true}, //One of 4 cheapest hours 20-23
+		{"23:59", true},
+	}
+	for _, step := range steps {
+		errUpdate := relay.Update(today, nil, testClock(t, step.clock))
+		if errUpdate != nil {
+			t.Fatal(errUpdate)
+		}
+		on, reason := relay.State()
+		if on != step.on || pin.last() != gpio.Level(step.on) {
+			t.Errorf("at %s relay on=%v pin=%v (%s), wanted %v", step.clock, on, pin.last(), reason, step.on)
+		}
+	}
+}
+
+func TestRelayMinOnTime(t *testing.T) {
+	prices := rampPrices(10, 0)
+	below := float64(5)
+	prices[10] = 1
+	store := testStore(t, "2026-10-19", prices)
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	pin := &fakePin{}
+	relay := RelayController{Config: RelayConfig{Name: "boiler", Pin: "GPIO23", PriceBelow: &below, MinOnMinutes: 90, ActiveLow: true}, Pin: pin}
+
+	relay.Update(today, nil, testClock(t, "10:30"))
+	if pin.last() != gpio.Low {
+		t.Fatalf("active low relay should be driven low when on")
+	}
+	relay.Update(today, nil, testClock(t, "11:30"))
+	on, reason := relay.State()
+	if !on || pin.last() != gpio.Low {
+		t.Errorf("relay should be kept on by minimum on time, got %v (%s)", on, reason)
+	}
+	relay.Update(today, nil, testClock(t, "12:05"))
+	on, _ = relay.State()
+	if on || pin.last() != gpio.High {
+		t.Errorf("relay should be off after minimum on time")
+	}
+}
+
+func TestRelayFailSafe(t *testing.T) {
+	for _, failSafe := range []string{"", "on", "off"} {
+		pin := &fakePin{}
+		relay := RelayController{Config: RelayConfig{Name: "boiler", Pin: "GPIO23", CheapestHours: 4, FailSafe: failSafe}, Pin: pin}
+		relay.Update(nil, fmt.Errorf("no prices"), testClock(t, "12:00"))
+		wanted := failSafe != "off"
+		if pin.last() != gpio.Level(wanted) {
+			t.Errorf("failSafe %q output %v, wanted %v", failSafe, pin.last(), wanted)
+		}
+	}
+
+	pin := &fakePin{err: fmt.Errorf("pin busy")}
+	relay := RelayController{Config: RelayConfig{Name: "boiler", Pin: "GPIO23"}, Pin: pin}
+	if relay.Update(nil, fmt.Errorf("no prices"), testClock(t, "12:00")) == nil {
+		t.Errorf("output error should be returned")
+	}
+}
@@ -0,0 +1,948 @@
+/*
+HTTP server mode. Pi works as price hub on LAN
+JSON api and rendered views are served from same cache as e-paper
+*/
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hjkoskel/gomonochromebitmap"
+)
+
+type SpotServer struct {
+	Store              *PriceStore
+	ExpensiveHourCount int
+	Now                func() time.Time //Clock, replaceable
+	Events             *EventHub
+
+	WindowHours   int //Cheapest window shown on views, 0 disables
+	WindowAllowed []HourRange
+
+	Appliances []ApplianceProfile
+	Relays     []*RelayController
+	SgReady    *SgReadyController //nil if not configured
+	Plugs      []*PlugController
+	Modbus     []*ModbusWriter
+	Ocpp       *CentralSystem     //nil if not configured
+	Battery    *BatteryConfig     //nil if not configured
+	Heating    *HeatingConfig     //nil if not configured
+	Webhooks   *WebhookDispatcher //nil if not configured
+	Tariff     *TariffConfig      //nil if not configured
+}
+
+type ApiHeatingHour struct {
+	Time        string  `json:"time"`
+	Price       float64 `json:"price"`
+	On          bool    `json:"on"`
+	OutdoorTemp float64 `json:"outdoorTemp"`
+	IndoorTemp  float64 `json:"indoorTemp"` //At end of hour
+}
+
+type ApiHeating struct {
+	StartTemp float64          `json:"startTemp"`
+	EnergyKWh float64          `json:"energyKWh"`
+	CostEur   float64          `json:"costEur"`
+	Comfort   bool             `json:"comfort"` //Stays in comfort band
+	Hours     []ApiHeatingHour `json:"hours"`
+}
+
+type ApiBatteryHour struct {
+	Time    string  `json:"time"`
+	Price   float64 `json:"price"`
+	Action  string  `json:"action"`  //charge, discharge or idle
+	GridKWh float64 `json:"gridKWh"` //Positive is charging from grid
+	SocKWh  float64 `json:"socKWh"`  //At end of hour
+}
+
+type ApiBattery struct {
+	Unit        string           `json:"unit"`
+	StartSocKWh float64          `json:"startSocKWh"`
+	CostEur     float64          `json:"costEur"` //Negative is profit
+	Hours       []ApiBatteryHour `json:"hours"`
+}
+
+type ApiModbusDevice struct {
+	Name    string         `json:"name"`
+	Class   string         `json:"class"` //Price class of current hour
+	DryRun  bool           `json:"dryRun"`
+	Written map[string]int `json:"written"` //Register values by name
+	Error   string         `json:"error,omitempty"`
+}
+
+type ApiPlug struct {
+	Name   string `json:"name"`
+	On     bool   `json:"on"`
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"` //Last switching or read back error
+}
+
+type ApiWebhook struct {
+	Name      string   `json:"name"`
+	Method    string   `json:"method"`
+	Url       string   `json:"url"`
+	Events    []string `json:"events"`
+	LastEvent string   `json:"lastEvent,omitempty"`
+	LastFired string   `json:"lastFired,omitempty"`
+	Error     string   `json:"error,omitempty"` //Last failed send
+}
+
+type ApiSgReadyHour struct {
+	Time  string `json:"time"`
+	State int    `json:"state"`
+	Name  string `json:"name"`
+}
+
+type ApiSgReady struct {
+	State  int              `json:"state"`
+	Name   string           `json:"name"`
+	Reason string           `json:"reason"`
+	Today  []ApiSgReadyHour `json:"today"`
+}
+
+type ApiRelay struct {
+	Name   string `json:"name"`
+	On     bool   `json:"on"`
+	Reason string `json:"reason"`
+}
+
+type ApiAppliancePlan struct {
+	Name         string  `json:"name"`
+	Start        string  `json:"start"`
+	End          string  `json:"end"`
+	EnergyKWh    float64 `json:"energyKWh"`
+	CostEur      float64 `json:"costEur"`
+	NowAvailable bool    `json:"nowAvailable"`
+	NowCostEur   float64 `json:"nowCostEur,omitempty"`
+	SavingsEur   float64 `json:"savingsEur,omitempty"`
+}
+
+type ApiWindow struct {
+	Start   string         `json:"start"`
+	End     string         `json:"end"`
+	Hours   int            `json:"hours"`
+	Unit    string         `json:"unit"`
+	Average float64        `json:"average"`
+	Prices  []ApiHourPrice `json:"prices"`
+}
+
+type ApiHourPrice struct {
+	Time      string  `json:"time"` //RFC3339 in finnish time
+	Price     float64 `json:"price"`
+	Expensive bool    `json:"expensive"`
+}
+
+type ApiHourCost struct {
+	Time      string  `json:"time"`
+	Spot      float64 `json:"spot"`
+	Margin    float64 `json:"margin"`
+	Transfer  float64 `json:"transfer"`
+	Tax       float64 `json:"tax"`
+	Fixed     float64 `json:"fixed"`
+	Total     float64 `json:"total"`
+	Expensive bool    `json:"expensive"` //By total price
+}
+
+type ApiCosts struct {
+	Date  string        `json:"date"`
+	Unit  string        `json:"unit"`
+	Vat   bool          `json:"vat"`
+	Hours []ApiHourCost `json:"hours"`
+}
+
+type ApiPrices struct {
+	Date   string         `json:"date"`
+	Unit   string         `json:"unit"`
+	Prices []ApiHourPrice `json:"prices"`
+}
+
+type ApiNow struct {
+	Time      string  `json:"time"`
+	Until     string  `json:"until"`
+	Price     float64 `json:"price"`
+	Unit      string  `json:"unit"`
+	Expensive bool    `json:"expensive"`
+}
+
+type ApiCheapest struct {
+	Hours   int            `json:"hours"`
+	Unit    string         `json:"unit"`
+	Average float64        `json:"average"`
+	Prices  []ApiHourPrice `json:"prices"`
+}
+
+func NewSpotServer(store *PriceStore, expensiveHourCount int) *SpotServer {
+	return &SpotServer{Store: store, ExpensiveHourCount: expensiveHourCount, Now: time.Now, Events: NewEventHub()}
+}
+
+func (p *SpotServer) Handler() *http.ServeMux {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/prices", p.handlePrices)
+	mux.HandleFunc("/api/now", p.handleNow)
+	mux.HandleFunc("/api/costs", p.handleCosts)
+	mux.HandleFunc("/api/cheapest", p.handleCheapest)
+	mux.HandleFunc("/api/window", p.handleWindow)
+	mux.HandleFunc("/api/appliances", p.handleAppliances)
+	mux.HandleFunc("/api/relays", p.handleRelays)
+	mux.HandleFunc("/api/sgready", p.handleSgReady)
+	mux.HandleFunc("/api/plugs", p.handlePlugs)
+	mux.HandleFunc("/api/modbus", p.handleModbus)
+	mux.HandleFunc("/api/charging", p.handleCharging)
+	mux.HandleFunc("/api/battery", p.handleBattery)
+	mux.HandleFunc("/api/heating", p.handleHeating)
+	mux.HandleFunc("/api/webhooks", p.handleWebhooks)
+	mux.HandleFunc(OCPPPATH, p.handleOcpp)
+	mux.HandleFunc("/device", p.handleDevice)
+	mux.HandleFunc("/frame/", p.handleFrame)
+	mux.HandleFunc("/events", p.handleEvents)
+	mux.HandleFunc("/metrics", p.handleMetrics)
+	mux.HandleFunc("/spotview.png", p.handlePng)
+	mux.HandleFunc("/spotview.svg", p.handleSvg)
+	mux.HandleFunc("/calendar.ics", p.handleCalendar)
+	return mux
+}
+
+func writeJson(w http.ResponseWriter, v interface{}) {
+	content, errMarshal := json.MarshalIndent(v, "", "  ")
+	if errMarshal != nil {
+		http.Error(w, errMarshal.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(content)
+}
+
+func toApiHourPrices(prices []HourPrice, expensive []bool) []ApiHourPrice {
+	result := make([]ApiHourPrice, len(prices))
+	for i, hp := range prices {
+		result[i] = ApiHourPrice{Time: hp.Start.Format(time.RFC3339), Price: hp.Price, Expensive: expensive[i]}
+	}
+	return result
+}
+
+//expensiveOf picks expensive flags of selected hours, days of horizon decide what is expensive
+func (p *SpotServer) expensiveOf(selected []HourPrice, horizon []HourPrice) []bool {
+	horizonExpensive := expensiveFlags(horizon, p.ExpensiveHourCount)
+	result := make([]bool, len(selected))
+	for i, hp := range selected {
+		for j, h := range horizon {
+			if h.Start.Equal(hp.Start) {
+				result[i] = horizonExpensive[j]
+			}
+		}
+	}
+	return result
+}
+
+func (p *SpotServer) handlePrices(w http.ResponseWriter, r *http.Request) {
+	t := p.Now()
+	dateQuery := r.URL.Query().Get("date")
+	if dateQuery != "" {
+		var errParse error
+		t, errParse = ParseDateInFinland(dateQuery)
+		if errParse != nil {
+			http.Error(w, fmt.Sprintf("invalid date %s, use YYYY-MM-DD", dateQuery), http.StatusBadRequest)
+			return
+		}
+	}
+	prices, errPrices := p.Store.ShownDayPrices(t)
+	if errPrices != nil {
+		http.Error(w, errPrices.Error(), http.StatusBadGateway)
+		return
+	}
+	lt, _ := TimeInFinland(t)
+	writeJson(w, ApiPrices{
+		Date:   lt.Format("2006-01-02"),
+		Unit:   VATTENFALLEXPECTED_UNIT,
+		Prices: toApiHourPrices(prices, expensiveFlags(prices, p.ExpensiveHourCount)),
+	})
+}
+
+//handleCosts is total price of hours split to components, /api/costs?date=YYYY-MM-DD (default today)
+func (p *SpotServer) handleCosts(w http.ResponseWriter, r *http.Request) {
+	if p.Tariff == nil {
+		http.Error(w, "tariff not configured", http.StatusNotFound)
+		return
+	}
+	t := p.Now()
+	dateQuery := r.URL.Query().Get("date")
+	if dateQuery != "" {
+		var errParse error
+		t, errParse = ParseDateInFinland(dateQuery)
+		if errParse != nil {
+			http.Error(w, fmt.Sprintf("invalid date %s, use YYYY-MM-DD", dateQuery), http.StatusBadRequest)
+			return
+		}
+	}
+	prices, errPrices := p.Store.ShownDayPrices(t)
+	if errPrices != nil {
+		http.Error(w, errPrices.Error(), http.StatusBadGateway)
+		return
+	}
+	costs := p.Store.HourCosts(*p.Tariff, prices)
+	totals := make([]HourPrice, len(costs))
+	for i, cost := range costs {
+		totals[i] = HourPrice{Start: cost.Start, Price: cost.Total}
+	}
+	expensive := expensiveFlags(totals, p.ExpensiveHourCount)
+	lt, _ := TimeInFinland(t)
+	result := ApiCosts{Date: lt.Format("2006-01-02"), Unit: VATTENFALLEXPECTED_UNIT, Vat: p.Store.Vat == nil || p.Store.Vat.ShowVat, Hours: make([]ApiHourCost, len(costs))}
+	for i, cost := range costs {
+		result.Hours[i] = ApiHourCost{
+			Time:      cost.Start.Format(time.RFC3339),
+			Spot:      cost.Spot,
+			Margin:    cost.Margin,
+			Transfer:  cost.Transfer,
+			Tax:       cost.Tax,
+			Fixed:     cost.Fixed,
+			Total:     cost.Total,
+			Expensive: expensive[i],
+		}
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handleNow(w http.ResponseWriter, r *http.Request) {
+	tNow := p.Now()
+	prices, errPrices := p.Store.ShownDayPrices(tNow)
+	if errPrices != nil {
+		http.Error(w, errPrices.Error(), http.StatusBadGateway)
+		return
+	}
+	expensive := expensiveFlags(prices, p.ExpensiveHourCount)
+	for i, hp := range prices {
+		if !tNow.Before(hp.Start) && tNow.Before(hp.Start.Add(time.Hour)) {
+			writeJson(w, ApiNow{
+				Time:      hp.Start.Format(time.RFC3339),
+				Until:     hp.Start.Add(time.Hour).Format(time.RFC3339),
+				Price:     hp.Price,
+				Unit:      VATTENFALLEXPECTED_UNIT,
+				Expensive: expensive[i],
+			})
+			return
+		}
+	}
+	http.Error(w, "no price for current hour", http.StatusNotFound)
+}
+
+func (p *SpotServer) handleCheapest(w http.ResponseWriter, r *http.Request) {
+	hours, errHours := strconv.Atoi(r.URL.Query().Get("hours"))
+	if errHours != nil || hours < 1 {
+		http.Error(w, "hours parameter must be positive integer", http.StatusBadRequest)
+		return
+	}
+	tNow := p.Now()
+	horizon, errHorizon := p.Store.ShownHorizon(tNow)
+	if errHorizon != nil {
+		http.Error(w, errHorizon.Error(), http.StatusBadGateway)
+		return
+	}
+	upcoming := []HourPrice{}
+	for _, hp := range horizon {
+		if tNow.Before(hp.Start.Add(time.Hour)) {
+			upcoming = append(upcoming, hp)
+		}
+	}
+	if len(upcoming) < hours {
+		http.Error(w, fmt.Sprintf("only %v hours known", len(upcoming)), http.StatusNotFound)
+		return
+	}
+	cheapest := cheapestHours(upcoming, hours)
+	sum := float64(0)
+	for _, hp := range cheapest {
+		sum += hp.Price
+	}
+	writeJson(w, ApiCheapest{
+		Hours:   hours,
+		Unit:    VATTENFALLEXPECTED_UNIT,
+		Average: sum / float64(hours),
+		Prices:  toApiHourPrices(cheapest, p.expensiveOf(cheapest, horizon)),
+	})
+}
+
+/*
+handleDevice is for microcontrollers. One plain text line, space separated
+price nextprice expensive secondstonextchange localtime
+like "8.24 9.10 0 1234 1739". Nextprice is nan if tomorrow prices are not published yet.
+localtime is HHMM in finnish time, so device does not need ntp or DST tables
+*/
+func (p *SpotServer) handleDevice(w http.ResponseWriter, r *http.Request) {
+	tNow := p.Now()
+	horizon, errHorizon := p.Store.ShownHorizon(tNow)
+	if errHorizon != nil {
+		http.Error(w, errHorizon.Error(), http.StatusBadGateway)
+		return
+	}
+	expensive := expensiveFlags(horizon, p.ExpensiveHourCount)
+	for i, hp := range horizon {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		next := "nan"
+		if i+1 < len(horizon) {
+			next = fmt.Sprintf("%.2f", horizon[i+1].Price)
+		}
+		expensiveFlag := 0
+		if expensive[i] {
+			expensiveFlag = 1
+		}
+		lt, _ := TimeInFinland(tNow)
+		w.Header().Set("Content-Type", "text/plain")
+		fmt.Fprintf(w, "%.2f %s %d %d %02d%02d\n", hp.Price, next, expensiveFlag,
+			int(math.Ceil(hp.Start.Add(time.Hour).Sub(tNow).Seconds())), lt.Hour(), lt.Minute())
+		return
+	}
+	http.Error(w, "no price for current hour", http.StatusNotFound)
+}
+
+//RamFormatter converts bitmap to display native ram layout
+type RamFormatter interface {
+	ToRamFormat(bm gomonochromebitmap.MonoBitmap) ([]byte, error)
+}
+
+//Panel types available on /frame/<name>.bin
+var framePanels = map[string]RamFormatter{
+	"epd0213": &Epd0213{},
+}
+
+/*
+handleFrame serves pre-rendered e-paper ram content for remote panels.
+Content is black ram followed by red ram, both same length.
+ETag changes only when frame content changes
+*/
+func (p *SpotServer) handleFrame(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/frame/")
+	if !strings.HasSuffix(name, ".bin") {
+		http.NotFound(w, r)
+		return
+	}
+	panel, havePanel := framePanels[strings.TrimSuffix(name, ".bin")]
+	if !havePanel {
+		http.Error(w, fmt.Sprintf("unknown panel type %s", name), http.StatusNotFound)
+		return
+	}
+
+	pw, errView := p.View(p.Now())
+	if errView != nil {
+		http.Error(w, errView.Error(), http.StatusBadGateway)
+		return
+	}
+	black, red, errGen := pw.CreateBlackRedView(p.ExpensiveHourCount)
+	if errGen != nil {
+		http.Error(w, errGen.Error(), http.StatusInternalServerError)
+		return
+	}
+	blackData, convBlackErr := panel.ToRamFormat(black)
+	if convBlackErr != nil {
+		http.Error(w, fmt.Sprintf("error converting black %v", convBlackErr.Error()), http.StatusInternalServerError)
+		return
+	}
+	redData, convRedErr := panel.ToRamFormat(red)
+	if convRedErr != nil {
+		http.Error(w, fmt.Sprintf("error converting red %v", convRedErr.Error()), http.StatusInternalServerError)
+		return
+	}
+	content := append(blackData, redData...)
+	etag := fmt.Sprintf("\"%x\"", sha256.Sum256(content))
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	if r.Header.Get("If-None-Match") == etag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+	w.Write(content)
+}
+
+//View is rendered view with cheapest window and configured plan strips
+func (p *SpotServer) View(tNow time.Time) (PriceView, error) {
+	pw, errView := p.Store.PriceView(tNow)
+	if errView != nil {
+		return pw, errView
+	}
+	p.Store.ShowWindow(&pw, tNow, p.WindowHours, p.WindowAllowed)
+	if p.SgReady != nil {
+		p.Store.ShowSgReady(&pw, p.SgReady.Config)
+	}
+	if p.Battery != nil {
+		p.Store.ShowBattery(&pw, *p.Battery, tNow)
+	}
+	if p.Heating != nil {
+		p.Store.ShowHeating(&pw, *p.Heating, tNow)
+	}
+	if p.Tariff != nil && p.Tariff.Stack {
+		p.Store.ShowTariff(&pw, *p.Tariff)
+	}
+	return pw, nil
+}
+
+//handleWindow is cheapest contiguous window, /api/window?hours=3&allowed=22-07,10-14
+func (p *SpotServer) handleWindow(w http.ResponseWriter, r *http.Request) {
+	hours, errHours := strconv.Atoi(r.URL.Query().Get("hours"))
+	if errHours != nil || hours < 1 {
+		http.Error(w, "hours parameter must be positive integer", http.StatusBadRequest)
+		return
+	}
+	allowed, errAllowed := ParseHourRanges(r.URL.Query().Get("allowed"))
+	if errAllowed != nil {
+		http.Error(w, errAllowed.Error(), http.StatusBadRequest)
+		return
+	}
+	tNow := p.Now()
+	horizon, errHorizon := p.Store.ShownHorizon(tNow)
+	if errHorizon != nil {
+		http.Error(w, errHorizon.Error(), http.StatusBadGateway)
+		return
+	}
+	window, errWindow := CheapestWindow(upcomingPrices(horizon, tNow), hours, allowed)
+	if errWindow != nil {
+		http.Error(w, errWindow.Error(), http.StatusNotFound)
+		return
+	}
+	writeJson(w, ApiWindow{
+		Start:   window.Start.Format(time.RFC3339),
+		End:     window.End().Format(time.RFC3339),
+		Hours:   window.Hours,
+		Unit:    VATTENFALLEXPECTED_UNIT,
+		Average: window.Average,
+		Prices:  toApiHourPrices(window.Prices, p.expensiveOf(window.Prices, horizon)),
+	})
+}
+
+func (p *SpotServer) handleAppliances(w http.ResponseWriter, r *http.Request) {
+	plans, errPlans := p.Store.PlanAppliances(p.Appliances, p.Now())
+	if errPlans != nil {
+		http.Error(w, errPlans.Error(), http.StatusBadGateway)
+		return
+	}
+	result := make([]ApiAppliancePlan, len(plans))
+	for i, plan := range plans {
+		result[i] = ApiAppliancePlan{
+			Name:         plan.Name,
+			Start:        plan.Start.Format(time.RFC3339),
+			End:          plan.End.Format(time.RFC3339),
+			EnergyKWh:    plan.EnergyKWh,
+			CostEur:      plan.CostEur,
+			NowAvailable: plan.NowAvailable,
+			NowCostEur:   plan.NowCostEur,
+			SavingsEur:   plan.SavingsEur,
+		}
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handleRelays(w http.ResponseWriter, r *http.Request) {
+	result := make([]ApiRelay, len(p.Relays))
+	for i, relay := range p.Relays {
+		on, reason := relay.State()
+		result[i] = ApiRelay{Name: relay.Config.Name, On: on, Reason: reason}
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handlePlugs(w http.ResponseWriter, r *http.Request) {
+	result := make([]ApiPlug, len(p.Plugs))
+	for i, plug := range p.Plugs {
+		on, reason, errPlug := plug.State()
+		result[i] = ApiPlug{Name: plug.Config.Name, On: on, Reason: reason}
+		if errPlug != nil {
+			result[i].Error = errPlug.Error()
+		}
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handleModbus(w http.ResponseWriter, r *http.Request) {
+	result := make([]ApiModbusDevice, len(p.Modbus))
+	for i, writer := range p.Modbus {
+		class, written, errWriter := writer.State()
+		result[i] = ApiModbusDevice{Name: writer.Device.Name, Class: class, DryRun: writer.Device.DryRun, Written: written}
+		if errWriter != nil {
+			result[i].Error = errWriter.Error()
+		}
+	}
+	writeJson(w, result)
+}
+
+//handleBattery is battery plan, /api/battery?soc=50&horizon=24
+func (p *SpotServer) handleBattery(w http.ResponseWriter, r *http.Request) {
+	if p.Battery == nil {
+		http.Error(w, "battery not configured", http.StatusNotFound)
+		return
+	}
+	soc := p.Battery.defaultSoc()
+	if r.FormValue("soc") != "" {
+		parsed, errSoc := strconv.ParseFloat(r.FormValue("soc"), 64)
+		if errSoc != nil || parsed < 0 || 100 < parsed {
+			http.Error(w, "invalid soc", http.StatusBadRequest)
+			return
+		}
+		soc = parsed
+	}
+	horizonHours := p.Battery.HorizonHours
+	if r.FormValue("horizon") != "" {
+		parsed, errHorizon := strconv.Atoi(r.FormValue("horizon"))
+		if errHorizon != nil || (parsed != 24 && parsed != 48) {
+			http.Error(w, "horizon must be 24 or 48", http.StatusBadRequest)
+			return
+		}
+		horizonHours = parsed
+	}
+	plan, errPlan := p.Store.PlanBattery(*p.Battery, p.Now(), soc, horizonHours)
+	if errPlan != nil {
+		http.Error(w, errPlan.Error(), http.StatusBadGateway)
+		return
+	}
+	result := ApiBattery{Unit: VATTENFALLEXPECTED_UNIT, StartSocKWh: plan.StartSocKWh, CostEur: plan.CostEur, Hours: []ApiBatteryHour{}}
+	for _, hour := range plan.Hours {
+		action := "idle"
+		if 0 < hour.GridKWh {
+			action = "charge"
+		} else if hour.GridKWh < 0 {
+			action = "discharge"
+		}
+		lt, _ := TimeInFinland(hour.Start)
+		result.Hours = append(result.Hours, ApiBatteryHour{Time: lt.Format(time.RFC3339), Price: hour.Price, Action: action, GridKWh: hour.GridKWh, SocKWh: hour.SocKWh})
+	}
+	writeJson(w, result)
+}
+
+//handleHeating is heating plan, /api/heating?temp=21.5&horizon=24
+func (p *SpotServer) handleHeating(w http.ResponseWriter, r *http.Request) {
+	if p.Heating == nil {
+		http.Error(w, "heating not configured", http.StatusNotFound)
+		return
+	}
+	startTemp := p.Heating.defaultIndoor()
+	if r.FormValue("temp") != "" {
+		parsed, errTemp := strconv.ParseFloat(r.FormValue("temp"), 64)
+		if errTemp != nil {
+			http.Error(w, "invalid temp", http.StatusBadRequest)
+			return
+		}
+		startTemp = parsed
+	}
+	horizonHours := p.Heating.HorizonHours
+	if r.FormValue("horizon") != "" {
+		parsed, errHorizon := strconv.Atoi(r.FormValue("horizon"))
+		if errHorizon != nil || (parsed != 24 && parsed != 48) {
+			http.Error(w, "horizon must be 24 or 48", http.StatusBadRequest)
+			return
+		}
+		horizonHours = parsed
+	}
+	plan, errPlan := p.Store.PlanHeating(*p.Heating, p.Now(), startTemp, horizonHours)
+	if errPlan != nil {
+		http.Error(w, errPlan.Error(), http.StatusBadGateway)
+		return
+	}
+	result := ApiHeating{StartTemp: plan.StartTemp, EnergyKWh: plan.EnergyKWh, CostEur: plan.CostEur, Comfort: plan.Comfort, Hours: []ApiHeatingHour{}}
+	for _, hour := range plan.Hours {
+		lt, _ := TimeInFinland(hour.Start)
+		result.Hours = append(result.Hours, ApiHeatingHour{Time: lt.Format(time.RFC3339), Price: hour.Price, On: hour.On, OutdoorTemp: hour.OutdoorTemp, IndoorTemp: hour.IndoorTemp})
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handleCharging(w http.ResponseWriter, r *http.Request) {
+	if p.Ocpp == nil {
+		http.Error(w, "ocpp not configured", http.StatusNotFound)
+		return
+	}
+	p.Ocpp.handleCharging(w, r)
+}
+
+func (p *SpotServer) handleOcpp(w http.ResponseWriter, r *http.Request) {
+	if p.Ocpp == nil {
+		http.Error(w, "ocpp not configured", http.StatusNotFound)
+		return
+	}
+	p.Ocpp.ServeHTTP(w, r)
+}
+
+func (p *SpotServer) handleSgReady(w http.ResponseWriter, r *http.Request) {
+	if p.SgReady == nil {
+		http.Error(w, "sg-ready not configured", http.StatusNotFound)
+		return
+	}
+	state, reason := p.SgReady.State()
+	result := ApiSgReady{State: int(state), Name: state.String(), Reason: reason, Today: []ApiSgReadyHour{}}
+	today, errToday := p.Store.DayPrices(p.Now())
+	if errToday == nil {
+		for i, planned := range p.SgReady.Config.Plan(today) {
+			lt, _ := TimeInFinland(today[i].Start)
+			result.Today = append(result.Today, ApiSgReadyHour{Time: lt.Format(time.RFC3339), State: int(planned), Name: planned.String()})
+		}
+	}
+	writeJson(w, result)
+}
+
+func (p *SpotServer) handlePng(w http.ResponseWriter, r *http.Request) {
+	pw, errView := p.View(p.Now())
+	if errView != nil {
+		http.Error(w, errView.Error(), http.StatusBadGateway)
+		return
+	}
+	black, red, errGen := pw.CreateBlackRedView(p.ExpensiveHourCount)
+	if errGen != nil {
+		http.Error(w, errGen.Error(), http.StatusInternalServerError)
+		return
+	}
+	var buf bytes.Buffer
+	errPng := writePng(&buf, &black, &red)
+	if errPng != nil {
+		http.Error(w, errPng.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+func (p *SpotServer) handleSvg(w http.ResponseWriter, r *http.Request) {
+	pw, errView := p.View(p.Now())
+	if errView != nil {
+		http.Error(w, errView.Error(), http.StatusBadGateway)
+		return
+	}
+	var buf bytes.Buffer
+	errSvg := pw.WriteSvg(&buf, p.ExpensiveHourCount)
+	if errSvg != nil {
+		http.Error(w, errSvg.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
+
+func (p *SpotServer) handleWebhooks(w http.ResponseWriter, r *http.Request) {
+	if p.Webhooks == nil {
+		http.Error(w, "webhooks not configured", http.StatusNotFound)
+		return
+	}
+	result := make([]ApiWebhook, len(p.Webhooks.Hooks))
+	for i, hook := range p.Webhooks.Hooks {
+		status := p.Webhooks.State(i)
+		result[i] = ApiWebhook{Name: hook.Name, Method: hook.method(), Url: hook.Url, Events: hook.Events, LastEvent: status.LastEvent, Error: status.LastError}
+		if !status.LastFired.IsZero() {
+			result[i].LastFired = status.LastFired.Format(time.RFC3339)
+		}
+	}
+	writeJson(w, result)
+}
+
+//cmdServe is "serve" subcommand
+func cmdServe(args []string) error {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	pListen := fs.String("listen", ":8080", "http listen address")
+	pConfigFileName := fs.String("config", "", "optional configuration file (json)")
+	pCacheDirName := fs.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
+	pNumberOfExpensiveHours := fs.Int("e", 6, "number of expensive hours per 24h highlighted in red")
+	pMqttAddress := fs.String("mqtt", "", "mqtt broker host:port, empty disables mqtt")
+	pMqttUser := fs.String("mqttuser", "", "mqtt username")
+	pMqttPassword := fs.String("mqttpass", "", "mqtt password")
+	pMqttPrefix := fs.String("mqttprefix", "spotview", "mqtt topic prefix")
+	pMqttDiscovery := fs.String("mqttdiscovery", "homeassistant", "home assistant discovery prefix, empty disables discovery")
+	pInfluxUrl := fs.String("influx", "", "influxdb v2 base url like http://localhost:8086, empty disables")
+	pInfluxOrg := fs.String("influxorg", "", "influxdb organization")
+	pInfluxBucket := fs.String("influxbucket", "spotview", "influxdb bucket")
+	pInfluxToken := fs.String("influxtoken", "", "influxdb api token")
+	pInfluxMeasurement := fs.String("influxmeasurement", "spotprice", "influxdb measurement name")
+	pWindow := fs.Int("window", 0, "show cheapest contiguous window of this many hours on views, 0 disables")
+	pAllowed := fs.String("allowed", "", "allowed hour ranges of window like 22-07,10-14 (default all day)")
+	pEpd := fs.Bool("epd", false, "drive e-paper, redraw when view changes")
+	pSpiName := fs.String("spi", "/dev/spidev0.0", "spi device file name")
+	pReadyPinName := fs.String("pinbusy", "GPIO24", "busy pin name (pin8 BUSY on display)")
+	pResetPin := fs.String("pinreset", "GPIO17", "reset pin name (pin7 RESET on display)")
+	pDataModePinName := fs.String("pindc", "GPIO25", " data mode pin name (pin6 D/C on display)")
+	getVatMode := vatFlags(fs)
+	fs.Parse(args)
+
+	allowed, errAllowed := ParseHourRanges(*pAllowed)
+	if errAllowed != nil {
+		return errAllowed
+	}
+	conf, errConf := LoadConfig(*pConfigFileName)
+	if errConf != nil {
+		return errConf
+	}
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		return errVat
+	}
+
+	waitClock()
+
+	store := NewPriceStore(*pCacheDirName)
+	store.Vat = vatMode
+	srv := NewSpotServer(store, *pNumberOfExpensiveHours)
+	srv.WindowHours = *pWindow
+	srv.WindowAllowed = allowed
+	srv.Appliances = conf.Appliances
+	for _, relayConfig := range conf.Relays {
+		pin, errPin := OpenOutputPin(relayConfig.Pin)
+		if errPin != nil {
+			return fmt.Errorf("relay %s %v", relayConfig.Name, errPin.Error())
+		}
+		srv.Relays = append(srv.Relays, &RelayController{Config: relayConfig, Pin: pin})
+	}
+	if 0 < len(srv.Relays) {
+		go RunRelays(store, srv.Relays)
+	}
+	for _, plugConfig := range conf.Plugs {
+		srv.Plugs = append(srv.Plugs, NewPlugController(plugConfig, *pNumberOfExpensiveHours))
+	}
+	if 0 < len(srv.Plugs) {
+		go RunPlugs(store, srv.Plugs)
+	}
+	for _, device := range conf.Modbus {
+		srv.Modbus = append(srv.Modbus, &ModbusWriter{Device: device, ExpensiveHourCount: *pNumberOfExpensiveHours})
+	}
+	if 0 < len(srv.Modbus) {
+		go RunModbus(store, srv.Modbus)
+	}
+	srv.Battery = conf.Battery
+	srv.Heating = conf.Heating
+	srv.Tariff = conf.Tariff
+	if conf.Ocpp != nil {
+		srv.Ocpp = NewCentralSystem(*conf.Ocpp, store)
+		go srv.Ocpp.Run()
+	}
+	if conf.SgReady != nil {
+		pinA, errPinA := OpenOutputPin(conf.SgReady.PinA)
+		if errPinA != nil {
+			return fmt.Errorf("sg-ready %v", errPinA.Error())
+		}
+		pinB, errPinB := OpenOutputPin(conf.SgReady.PinB)
+		if errPinB != nil {
+			return fmt.Errorf("sg-ready %v", errPinB.Error())
+		}
+		srv.SgReady = &SgReadyController{Config: *conf.SgReady, PinA: pinA, PinB: pinB}
+		go srv.SgReady.Run(store)
+	}
+
+	if 0 < len(conf.Webhooks) {
+		logFileName := conf.WebhookLog
+		if logFileName == "" {
+			logFileName = path.Join(*pCacheDirName, "webhooks.log")
+		}
+		webhookLog, errLog := OpenWebhookLog(logFileName, time.Now())
+		if errLog != nil {
+			return errLog
+		}
+		srv.Webhooks = NewWebhookDispatcher(conf.Webhooks, webhookLog, *pNumberOfExpensiveHours)
+		go srv.Webhooks.Run(store)
+	}
+
+	if conf.Notify != nil {
+		logFileName := conf.Notify.Log
+		if logFileName == "" {
+			logFileName = path.Join(*pCacheDirName, "notify.log")
+		}
+		notifyLog, errLog := OpenWebhookLog(logFileName, time.Now())
+		if errLog != nil {
+			return errLog
+		}
+		notifier := Notifier{Config: *conf.Notify, Log: notifyLog, View: srv.View, ExpensiveHourCount: *pNumberOfExpensiveHours}
+		go notifier.Run(store)
+	}
+
+	if *pEpd {
+		lowLevel, errLowLevel := InitEPD0213LowLevel(*pSpiName, *pReadyPinName, *pResetPin, *pDataModePinName)
+		if errLowLevel != nil {
+			return fmt.Errorf("low level init error %v", errLowLevel.Error())
+		}
+		go RunEpaperRefresh(lowLevel, srv.View, *pNumberOfExpensiveHours)
+	}
+	if *pMqttAddress != "" {
+		publisher := MqttPublisher{
+			Address:            *pMqttAddress,
+			Options:            MqttOptions{ClientId: *pMqttPrefix, User: *pMqttUser, Password: *pMqttPassword},
+			Prefix:             *pMqttPrefix,
+			DiscoveryPrefix:    *pMqttDiscovery,
+			Store:              store,
+			ExpensiveHourCount: *pNumberOfExpensiveHours,
+		}
+		go publisher.Run()
+	}
+
+	if *pInfluxUrl != "" {
+		influx := InfluxWriter{
+			Url:         *pInfluxUrl,
+			Org:         *pInfluxOrg,
+			Bucket:      *pInfluxBucket,
+			Token:       *pInfluxToken,
+			Measurement: *pInfluxMeasurement,
+			CacheDir:    *pCacheDirName,
+			Tariff:      conf.Tariff,
+		}
+		go influx.Run()
+	}
+
+	go srv.RunEvents()
+	fmt.Printf("serving on %s\n", *pListen)
+	return http.ListenAndServe(*pListen, srv.Handler())
+}
+
+//handleCalendar is iCalendar feed, /calendar.ics?hours=3&days=7&allowed=22-07. Defaults are window options of server
+func (p *SpotServer) handleCalendar(w http.ResponseWriter, r *http.Request) {
+	hours := p.WindowHours
+	if hours == 0 {
+		hours = ICSDEFAULT_WINDOW
+	}
+	allowed := p.WindowAllowed
+	days := ICSDEFAULT_DAYS
+	query := r.URL.Query()
+	if query.Get("hours") != "" {
+		var errHours error
+		hours, errHours = strconv.Atoi(query.Get("hours"))
+		if errHours != nil || hours < 1 {
+			http.Error(w, "hours parameter must be positive integer", http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("days") != "" {
+		var errDays error
+		days, errDays = strconv.Atoi(query.Get("days"))
+		if errDays != nil || days < 0 || ICSMAX_DAYS < days {
+			http.Error(w, fmt.Sprintf("days parameter must be integer 0-%d", ICSMAX_DAYS), http.StatusBadRequest)
+			return
+		}
+	}
+	if query.Get("allowed") != "" {
+		var errAllowed error
+		allowed, errAllowed = ParseHourRanges(query.Get("allowed"))
+		if errAllowed != nil {
+			http.Error(w, errAllowed.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+	tNow := p.Now()
+	events, errEvents := p.Store.CalendarEvents(tNow, days, hours, allowed, p.ExpensiveHourCount)
+	if errEvents != nil {
+		http.Error(w, errEvents.Error(), http.StatusBadGateway)
+		return
+	}
+	var buf bytes.Buffer
+	errWrite := WriteIcs(&buf, events, tNow)
+	if errWrite != nil {
+		http.Error(w, errWrite.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Write(buf.Bytes())
+}
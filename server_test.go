@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestHandlePricesDate(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	srv := NewSpotServer(store, 6)
+	srv.Now = func() time.Time { return testClock(t, "12:00") }
+
+	tests := []struct {
+		query  string
+		status int
+		date   string
+		first  float64
+	}{
+		{"", http.StatusOK, "2026-10-19", 1},
+		{"?date=2026-10-20", http.StatusOK, "2026-10-20", 30},
+		{"?date=20.10.2026", http.StatusBadRequest, "", 0},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		srv.Handler().ServeHTTP(recorder, httptest.NewRequest("GET", "/api/prices"+test.query, nil))
+		if recorder.Code != test.status {
+			t.Errorf("%q status %d, wanted %d", test.query, recorder.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		result := ApiPrices{}
+		json.Unmarshal(recorder.Body.Bytes(), &result)
+		if result.Date != test.date || len(result.Prices) != 24 || result.Prices[0].Price != test.first {
+			t.Errorf("%q gave %s with %d prices", test.query, result.Date, len(result.Prices))
+		}
+	}
+}
@@ -0,0 +1,271 @@
+/*
+SG-Ready heat pump control. Two relay outputs give four states. Hour states are planned from
+price distribution of day (percentiles), with hysteresis and limit for blocked hours per day
+*/
+package main
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+type SgReadyState int
+
+//SG-Ready operating states, numbered as in specification
+const (
+	SGBLOCKED     SgReadyState = 1 //Utility lock, heat pump off
+	SGNORMAL      SgReadyState = 2
+	SGRECOMMENDED SgReadyState = 3 //Recommended on, raised setpoints
+	SGFORCED      SgReadyState = 4 //Forced on
+)
+
+var sgReadyStateNames = map[SgReadyState]string{
+	SGBLOCKED:     "blocked",
+	SGNORMAL:      "normal",
+	SGRECOMMENDED: "recommended",
+	SGFORCED:      "forced",
+}
+
+func (p SgReadyState) String() string {
+	name, known := sgReadyStateNames[p]
+	if !known {
+		return fmt.Sprintf("state%d", int(p))
+	}
+	return name
+}
+
+//Relay contacts (A,B) of each state
+func (p SgReadyState) contacts() (bool, bool) {
+	switch p {
+	case SGBLOCKED:
+		return true, false
+	case SGRECOMMENDED:
+		return false, true
+	case SGFORCED:
+		return true, true
+	}
+	return false, false
+}
+
+//stripMark is how state is shown on chart strip
+func (p SgReadyState) stripMark() StripMark {
+	switch p {
+	case SGBLOCKED:
+		return STRIPALERT
+	case SGRECOMMENDED:
+		return STRIPLOW
+	case SGFORCED:
+		return STRIPHIGH
+	}
+	return STRIPNONE
+}
+
+type SgReadyConfig struct {
+	PinA             string  `json:"pinA"` //periph.io gpio names
+	PinB             string  `json:"pinB"`
+	ActiveLow        bool    `json:"activeLow,omitempty"`
+	BlockedAbove     float64 `json:"blockedAbove"`     //Percentile (0-100) of day prices, hours at or above are blocked. 0 disables
+	RecommendedBelow float64 `json:"recommendedBelow"` //Percentile, hours below are recommended on. 0 disables
+	ForcedBelow      float64 `json:"forcedBelow"`      //Percentile, hours below are forced on. 0 disables
+	Hysteresis       float64 `json:"hysteresis"`       //c/kWh, previous state is kept if price is this close to it
+	MaxBlockedHours  int     `json:"maxBlockedHours"`  //Safety limit, only most expensive blocked hours are kept
+}
+
+func (p *SgReadyConfig) CheckErr() error {
+	if p.PinA == "" || p.PinB == "" {
+		return fmt.Errorf("pinA and pinB are required")
+	}
+	for _, percentile := range []float64{p.BlockedAbove, p.RecommendedBelow, p.ForcedBelow} {
+		if percentile < 0 || 100 < percentile {
+			return fmt.Errorf("percentiles must be 0-100")
+		}
+	}
+	if p.RecommendedBelow < p.ForcedBelow {
+		return fmt.Errorf("forcedBelow must not be over recommendedBelow")
+	}
+	if 0 < p.BlockedAbove && p.BlockedAbove < p.RecommendedBelow {
+		return fmt.Errorf("blockedAbove must not be under recommendedBelow")
+	}
+	if 0 < p.BlockedAbove && (p.MaxBlockedHours < 1 || 24 < p.MaxBlockedHours) {
+		return fmt.Errorf("maxBlockedHours 1-24 is required when blocking is enabled")
+	}
+	if p.Hysteresis < 0 {
+		return fmt.Errorf("hysteresis must not be negative")
+	}
+	return nil
+}
+
+//percentile of sorted values, linear interpolation between closest ranks
+func percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	pos := q / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(pos))
+	if len(sorted)-1 <= lower {
+		return sorted[len(sorted)-1]
+	}
+	return sorted[lower] + (pos-float64(lower))*(sorted[lower+1]-sorted[lower])
+}
+
+//sgThresholds are price limits of one day
+type sgThresholds struct {
+	Blocked     float64 //Inf if disabled
+	Recommended float64 //-Inf if disabled
+	Forced      float64
+}
+
+func (p *SgReadyConfig) thresholds(prices []HourPrice) sgThresholds {
+	sorted := priceValues(prices)
+	sort.Float64s(sorted)
+	result := sgThresholds{Blocked: math.Inf(1), Recommended: math.Inf(-1), Forced: math.Inf(-1)}
+	if 0 < p.BlockedAbove {
+		result.Blocked = percentile(sorted, p.BlockedAbove)
+	}
+	if 0 < p.RecommendedBelow {
+		result.Recommended = percentile(sorted, p.RecommendedBelow)
+	}
+	if 0 < p.ForcedBelow {
+		result.Forced = percentile(sorted, p.ForcedBelow)
+	}
+	return result
+}
+
+func (p sgThresholds) classify(price float64) SgReadyState {
+	switch {
+	case p.Blocked <= price:
+		return SGBLOCKED
+	case price < p.Forced:
+		return SGFORCED
+	case price < p.Recommended:
+		return SGRECOMMENDED
+	}
+	return SGNORMAL
+}
+
+/*
+Plan gives state for each hour of day. Day starts from normal state. Hysteresis keeps previous
+state when price is within hysteresis from price that would give it. After that blocked hours over
+limit are returned to normal, cheapest first
+*/
+func (p *SgReadyConfig) Plan(day []HourPrice) []SgReadyState {
+	limits := p.thresholds(day)
+	result := make([]SgReadyState, len(day))
+	prev := SGNORMAL
+	for i, hp := range day {
+		state := limits.classify(hp.Price)
+		if state != prev && (limits.classify(hp.Price+p.Hysteresis) == prev || limits.classify(hp.Price-p.Hysteresis) == prev) {
+			state = prev
+		}
+		result[i] = state
+		prev = state
+	}
+
+	blocked := []int{}
+	for i, state := range result {
+		if state == SGBLOCKED {
+			blocked = append(blocked, i)
+		}
+	}
+	if p.MaxBlockedHours < len(blocked) {
+		sort.SliceStable(blocked, func(a, b int) bool { return day[blocked[b]].Price < day[blocked[a]].Price })
+		for _, i := range blocked[p.MaxBlockedHours:] {
+			result[i] = SGNORMAL
+		}
+	}
+	return result
+}
+
+//sgStateAt picks state of hour containing t from plan of day
+func sgStateAt(day []HourPrice, plan []SgReadyState, t time.Time) (SgReadyState, bool) {
+	for i, hp := range day {
+		if !t.Before(hp.Start) && t.Before(hp.Start.Add(time.Hour)) {
+			return plan[i], true
+		}
+	}
+	return SGNORMAL, false
+}
+
+type SgReadyController struct {
+	Config SgReadyConfig
+	PinA   OutputPin
+	PinB   OutputPin
+
+	mutex  sync.Mutex
+	state  SgReadyState
+	reason string
+}
+
+func (p *SgReadyController) State() (SgReadyState, string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.state, p.reason
+}
+
+//Update sets outputs. Normal state is used when prices are not available
+func (p *SgReadyController) Update(today []HourPrice, pricesErr error, tNow time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	state, reason := SGNORMAL, ""
+	if pricesErr != nil {
+		reason = "fail-safe, " + pricesErr.Error()
+	} else {
+		known := false
+		state, known = sgStateAt(today, p.Config.Plan(today), tNow)
+		reason = "planned from day prices"
+		if !known {
+			reason = "fail-safe, no price for current hour"
+		}
+	}
+	if state != p.state || reason != p.reason {
+		fmt.Printf("sg-ready %s (%s)\n", state, reason)
+	}
+	p.state, p.reason = state, reason
+
+	a, b := state.contacts()
+	errA := p.PinA.Out(gpio.Level(a != p.Config.ActiveLow))
+	if errA != nil {
+		return fmt.Errorf("sg-ready pin A output err %v", errA.Error())
+	}
+	errB := p.PinB.Out(gpio.Level(b != p.Config.ActiveLow))
+	if errB != nil {
+		return fmt.Errorf("sg-ready pin B output err %v", errB.Error())
+	}
+	return nil
+}
+
+//Run updates outputs every RELAYCHECK_INTERVAL. Never returns
+func (p *SgReadyController) Run(store *PriceStore) {
+	for {
+		tNow := time.Now()
+		today, errToday := store.DayPrices(tNow)
+		errUpdate := p.Update(today, errToday, tNow)
+		if errUpdate != nil {
+			fmt.Printf("%v\n", errUpdate.Error())
+		}
+		time.Sleep(RELAYCHECK_INTERVAL)
+	}
+}
+
+//ShowSgReady adds strip of planned states under bars. Days without prices are left empty
+func (p *PriceStore) ShowSgReady(pw *PriceView, conf SgReadyConfig) {
+	strip := ChartStrip{Label: "SG"}
+	for _, t := range []time.Time{pw.FirstDay, pw.LastDay} {
+		day, errDay := p.DayPrices(t)
+		if errDay != nil {
+			continue
+		}
+		for i, state := range conf.Plan(day) {
+			index, onChart := pw.ChartIndex(day[i].Start)
+			if onChart {
+				strip.Marks[index] = state.stripMark()
+			}
+		}
+	}
+	pw.Strips = append(pw.Strips, strip)
+}
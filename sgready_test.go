@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"periph.io/x/conn/v3/gpio"
+)
+
+//sgTestDay is permutation of prices 1-24. Percentiles depend only on values: 75th is 18.25 and 25th is 6.75
+var sgTestDay = []float64{10, 19, 24, 18, 11, 6, 1, 7, 12, 2, 3, 4, 5, 8, 9, 13, 14, 15, 16, 17, 20, 21, 22, 23}
+
+func TestSgReadyHysteresis(t *testing.T) {
+	store := testStore(t, "2026-10-19", sgTestDay)
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	conf := SgReadyConfig{PinA: "GPIO5", PinB: "GPIO6", BlockedAbove: 75, RecommendedBelow: 25, MaxBlockedHours: 24}
+
+	tests := []struct {
+		hysteresis float64
+		wanted     []SgReadyState //First 9 hours
+	}{
+		{0, []SgReadyState{SGNORMAL, SGBLOCKED, SGBLOCKED, SGNORMAL, SGNORMAL, SGRECOMMENDED, SGRECOMMENDED, SGNORMAL, SGNORMAL}},
+		//19 and 6 are within 1 from normal price, 18 from blocked and 7 from recommended, so previous state is kept
+		{1, []SgReadyState{SGNORMAL, SGNORMAL, SGBLOCKED, SGBLOCKED, SGNORMAL, SGNORMAL, SGRECOMMENDED, SGRECOMMENDED, SGNORMAL}},
+	}
+	for _, test := range tests {
+		conf.Hysteresis = test.hysteresis
+		plan := conf.Plan(today)
+		for i, wanted := range test.wanted {
+			if plan[i] != wanted {
+				t.Errorf("hysteresis %v hour %d price %v state %s, wanted %s", test.hysteresis, i, today[i].Price, plan[i], wanted)
+			}
+		}
+	}
+}
+
+func TestSgReadyMaxBlocked(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	conf := SgReadyConfig{PinA: "GPIO5", PinB: "GPIO6", BlockedAbove: 50, MaxBlockedHours: 3} //12 hours over median
+	plan := conf.Plan(today)
+	for i, state := range plan {
+		wanted := SGNORMAL
+		if 21 <= i {
+			wanted = SGBLOCKED
+		}
+		if state != wanted {
+			t.Errorf("hour %d state %s, wanted %s", i, state, wanted)
+		}
+	}
+}
+
+func TestSgReadyController(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	today, _ := store.DayPrices(testDate(t, "2026-10-19"))
+	pinA, pinB := &fakePin{}, &fakePin{}
+	controller := SgReadyController{
+		Config: SgReadyConfig{PinA: "GPIO5", PinB: "GPIO6", ActiveLow: true, BlockedAbove: 90, ForcedBelow: 10, RecommendedBelow: 10, MaxBlockedHours: 3},
+		PinA:   pinA,
+		PinB:   pinB,
+	}
+
+	steps := []struct {
+		clock    string
+		pricesOk bool
+		state    SgReadyState
+		a, b     gpio.Level //Active low
+	}{
+		{"22:30", true, SGBLOCKED, gpio.Low, gpio.High},
+		{"01:30", true, SGFORCED, gpio.Low, gpio.Low},
+		{"12:00", true, SGNORMAL, gpio.High, gpio.High},
+		{"22:30", false, SGNORMAL, gpio.High, gpio.High}, //Fail-safe
+	}
+	for _, step := range steps {
+		var errPrices error
+		if !step.pricesOk {
+			errPrices = fmt.Errorf("no prices")
+		}
+		errUpdate := controller.Update(today, errPrices, testClock(t, step.clock))
+		if errUpdate != nil {
+			t.Fatal(errUpdate)
+		}
+		state, reason := controller.State()
+		if state != step.state || pinA.last() != step.a || pinB.last() != step.b {
+			t.Errorf("at %s state %s (%s) contacts %v %v, wanted %s %v %v", step.clock, state, reason, pinA.last(), pinB.last(), step.state, step.a, step.b)
+		}
+		if !step.pricesOk && !strings.HasPrefix(reason, "fail-safe") {
+			t.Errorf("reason %q is not fail-safe", reason)
+		}
+	}
+
+	//Prices of other day do not cover current hour
+	controller.Update(today, nil, testClock(t, "22:30").AddDate(0, 0, 1))
+	state, reason := controller.State()
+	if state != SGNORMAL || !strings.HasPrefix(reason, "fail-safe") || pinA.last() != gpio.High || pinB.last() != gpio.High {
+		t.Errorf("without current hour state %s (%s)", state, reason)
+	}
+
+	controller.PinB = &fakePin{err: fmt.Errorf("pin busy")}
+	if controller.Update(today, nil, testClock(t, "12:00")) == nil {
+		t.Errorf("output error should be returned")
+	}
+}
@@ -6,7 +6,6 @@ check help/options with -h command line flag
 
 One way to deploy this to use raspberry distribution like https://gokrazy.org/
 gokr-packer -overwrite=/dev/sdb github.com/hjkoskel/spotview
-
 */
 package main
 
@@ -16,6 +15,7 @@ import (
 	"image"
 	"image/color"
 	"image/png"
+	"io"
 	"math"
 	"os"
 	"sort"
@@ -74,14 +74,192 @@ const (
 
 	SMALLTICKLEN int = 1
 	TICKLEN      int = 4
+
+	BRACKETGAP int = 3 //Pixels between bracket and highest bar
+	BRACKETLEG int = 2
+
+	STRIPHEIGHT int = 3 //Strips under bars
+	STRIPGAP    int = 1
 )
 
 type PriceView struct {
 	FirstName string
+	FirstDay  time.Time
 	FirstData [24]float64
 
 	LastName string
+	LastDay  time.Time
 	LastData [24]float64
+
+	Bracket *ChartSpan   //Optional bracket over bars, like cheapest window
+	Strips  []ChartStrip //Optional strips under bars, like heat pump state
+	Stack   *ChartStack  //Optional split of bars to segments, like tariff components
+}
+
+//ChartStack splits bars to segments, bottom first. Bar height is sum of segments
+type ChartStack struct {
+	Labels   []string
+	Segments [48][]float64
+}
+
+//ChartSpan marks range of bars on chart
+type ChartSpan struct {
+	First int //Bar index 0-47
+	Count int
+	Label string
+}
+
+//ChartIndex is bar index of hour starting at t, false if hour is not on chart
+func (p *PriceView) ChartIndex(t time.Time) (int, bool) {
+	lt, errLt := TimeInFinland(t)
+	if errLt != nil {
+		return 0, false
+	}
+	date := lt.Format("2006-01-02")
+	first, _ := TimeInFinland(p.FirstDay)
+	last, _ := TimeInFinland(p.LastDay)
+	if date == first.Format("2006-01-02") {
+		return lt.Hour(), true
+	}
+	if date == last.Format("2006-01-02") {
+		return 24 + lt.Hour(), true
+	}
+	return 0, false
+}
+
+//chartSpanOf converts time range to bars, clipped to chart
+func (p *PriceView) chartSpanOf(start time.Time, hours int, label string) *ChartSpan {
+	first, last := -1, -1
+	for h := 0; h < hours; h++ {
+		index, onChart := p.ChartIndex(start.Add(time.Duration(h) * time.Hour))
+		if !onChart {
+			continue
+		}
+		if first < 0 {
+			first = index
+		}
+		last = index
+	}
+	if first < 0 {
+		return nil
+	}
+	return &ChartSpan{First: first, Count: last - first + 1, Label: label}
+}
+
+//StripMark is how one hour is marked on strip under bars
+type StripMark int
+
+const (
+	STRIPNONE  StripMark = iota
+	STRIPLOW             //Thin black line
+	STRIPHIGH            //Full black
+	STRIPALERT           //Full red
+	STRIPUP              //Black up arrow
+	STRIPDOWN            //Red down arrow
+)
+
+//ChartStrip is row of marks under bars, one mark per bar
+type ChartStrip struct {
+	Label string
+	Marks [48]StripMark
+}
+
+//SetWindow shows window as bracket on chart
+func (p *PriceView) SetWindow(w HourWindow) {
+	p.Bracket = p.chartSpanOf(w.Start, w.Hours, fmt.Sprintf("%vh %.1f", w.Hours, w.Average))
+}
+
+//chartBar is one hour on chart. Index runs 0-47 over both days
+type chartBar struct {
+	Index     int
+	Price     float64
+	Expensive bool
+	Segments  []float64 //Stacked parts of price, empty if not stacked
+}
+
+//chartLayout is resolution independent layout of price chart. Shared between e-paper bitmap and svg renderers
+type chartLayout struct {
+	Bars       [48]chartBar
+	PlotMax    float64 //Top of y-axis, rounded to PRICEINCREMENT
+	PlotMin    float64 //Bottom of y-axis, lowest negative price (or negative segments of stacked bar) or 0
+	FirstTitle string
+	LastTitle  string
+	SmallTicks []float64 //Prices where small y-axis ticks are drawn
+	Ticks      []float64 //Prices where large y-axis ticks are drawn
+	HourLabels []int     //Bar indexes with hour label on x-axis
+	Bracket    *ChartSpan
+	BracketTop float64 //Highest price under bracket
+	Strips     []ChartStrip
+	Stack      []string //Labels of stacked segments, nil if not stacked
+}
+
+func (p *PriceView) layout(expensiveHourCount int) chartLayout {
+	result := chartLayout{}
+	firstData, lastData := p.FirstData, p.LastData
+	if p.Stack != nil { //Bars are total of segments
+		for h := 0; h < 24; h++ {
+			firstData[h], lastData[h] = 0, 0
+			for _, v := range p.Stack.Segments[h] {
+				firstData[h] += v
+			}
+			for _, v := range p.Stack.Segments[h+24] {
+				lastData[h] += v
+			}
+		}
+		result.Stack = p.Stack.Labels
+	}
+	_, max1 := maxArr(firstData[:])
+	_, max2 := maxArr(lastData[:])
+
+	//Stacked bar reaches over its total when some segments are negative
+	maxprice := float64(0)
+	for i, v := range append(firstData[:], lastData[:]...) {
+		above, below := math.Max(v, 0), math.Min(v, 0)
+		if p.Stack != nil {
+			above, below = 0, 0
+			for _, segment := range p.Stack.Segments[i] {
+				above += math.Max(segment, 0)
+				below += math.Min(segment, 0)
+			}
+		}
+		maxprice = math.Max(maxprice, above)
+		result.PlotMin = math.Min(result.PlotMin, below)
+	}
+
+	//Round to increments. Negative part is not rounded, it would take too much of small display
+	result.PlotMax = PRICEINCREMENT * math.Ceil(maxprice/PRICEINCREMENT)
+
+	result.FirstTitle = fmt.Sprintf("%s %.1f c/kWh", p.FirstName, max1)
+	result.LastTitle = fmt.Sprintf("%s %.1f c/kWh", p.LastName, max2)
+
+	firstExpensive := maxNvaluesOnThreshold(firstData[:], expensiveHourCount)
+	lastExpensive := maxNvaluesOnThreshold(lastData[:], expensiveHourCount)
+	for h := 0; h < 24; h++ {
+		result.Bars[h] = chartBar{Index: h, Price: firstData[h], Expensive: firstExpensive <= firstData[h]}
+		result.Bars[h+24] = chartBar{Index: h + 24, Price: lastData[h], Expensive: lastExpensive <= lastData[h]}
+		if p.Stack != nil {
+			result.Bars[h].Segments = p.Stack.Segments[h]
+			result.Bars[h+24].Segments = p.Stack.Segments[h+24]
+		}
+	}
+
+	for v := SMALLTICKPRICESTEP * math.Ceil(result.PlotMin/SMALLTICKPRICESTEP); v < result.PlotMax; v += SMALLTICKPRICESTEP {
+		result.SmallTicks = append(result.SmallTicks, v)
+	}
+	for v := TICKPRICESTEP * math.Ceil(result.PlotMin/TICKPRICESTEP); v < result.PlotMax; v += TICKPRICESTEP {
+		result.Ticks = append(result.Ticks, v)
+	}
+	for n := 0; n < 48; n += 4 {
+		result.HourLabels = append(result.HourLabels, n)
+	}
+	if p.Bracket != nil {
+		result.Bracket = p.Bracket
+		for i := p.Bracket.First; i < p.Bracket.First+p.Bracket.Count && i < 48; i++ {
+			result.BracketTop = math.Max(result.BracketTop, result.Bars[i].Price)
+		}
+	}
+	result.Strips = p.Strips
+	return result
 }
 
 //Cents per kWh
@@ -89,23 +267,20 @@ func (p *PriceView) CreateBlackRedView(expensiveHourCount int) (gomonochromebitm
 	redPic := gomonochromebitmap.NewMonoBitmap(DISP_WIDTH, DISP_HEIGHT, false)
 	blackPic := gomonochromebitmap.NewMonoBitmap(DISP_WIDTH, DISP_HEIGHT, false)
 
+	lay := p.layout(expensiveHourCount)
+
 	//X scale
 	barWidth := DISP_WIDTH / 48
 	barMargin := (DISP_WIDTH % 48) / 2
-	//Y scale
-	_, max1 := maxArr(p.FirstData[:])
-	_, max2 := maxArr(p.LastData[:])
-	maxprice := math.Max(max1, max2)
-
-	//Round to increments
-	plotMax := PRICEINCREMENT * math.Ceil(maxprice/PRICEINCREMENT)
 
-	//Title+plot+Xaxis text
-	plotHeight := DISP_HEIGHT - TITLE_HEIGHT - XAXIS_HEIGHT
-	yConv := float64(plotHeight) / float64(plotMax)
+	//Title+plot+strips+Xaxis text
+	plotHeight := DISP_HEIGHT - TITLE_HEIGHT - XAXIS_HEIGHT - len(lay.Strips)*(STRIPHEIGHT+STRIPGAP)
+	plotBottom := TITLE_HEIGHT + plotHeight
+	yConv := float64(plotHeight) / (lay.PlotMax - lay.PlotMin)
+	zeroY := plotBottom + int(lay.PlotMin*yConv) //Negative prices are drawn below
 
 	tickFont := gomonochromebitmap.GetFont_4x5()
-	for n := 0; n < 48; n += 4 {
+	for _, n := range lay.HourLabels {
 		fontOff := -1 //looks better
 		if 9 < n%24 {
 			fontOff = -3
@@ -113,83 +288,149 @@ func (p *PriceView) CreateBlackRedView(expensiveHourCount int) (gomonochromebitm
 		blackPic.Print(fmt.Sprintf("%v", n%24), tickFont, 0, 0, image.Rect(barMargin+n*barWidth+fontOff, DISP_HEIGHT-5, DISP_WIDTH, DISP_HEIGHT), true, false, false, false)
 	}
 
-	firstDayText := fmt.Sprintf("%s %.1f c/kWh", p.FirstName, max1)
-	lastDayText := fmt.Sprintf("%s %.1f c/kWh", p.LastName, max2)
-
 	titleFont := gomonochromebitmap.GetFont_5x7()
 	titleFontWidth := 5 + 1
-	blackPic.Print(firstDayText, titleFont, 0, 1, image.Rect(
-		DISP_WIDTH/4-titleFontWidth*len(firstDayText)/2, 0, DISP_WIDTH/2, DISP_HEIGHT), true, false, false, false)
-	blackPic.Print(lastDayText, titleFont, 0, 1, image.Rect(
-		DISP_WIDTH/2+DISP_WIDTH/4-titleFontWidth*len(lastDayText)/2, 0, DISP_WIDTH, DISP_HEIGHT), true, false, false, false)
-
-	//First day
-	firstExpensive := maxNvaluesOnThreshold(p.FirstData[:], expensiveHourCount)
-	for h, price := range p.FirstData {
-		barHeight := int(price * yConv)
-		bar := image.Rect(
-			barMargin+h*barWidth,
-			TITLE_HEIGHT+plotHeight-barHeight,
-			barMargin+(h+1)*barWidth-1-BARGAP,
-			TITLE_HEIGHT+plotHeight)
+	blackPic.Print(lay.FirstTitle, titleFont, 0, 1, image.Rect(
+		DISP_WIDTH/4-titleFontWidth*len(lay.FirstTitle)/2, 0, DISP_WIDTH/2, DISP_HEIGHT), true, false, false, false)
+	blackPic.Print(lay.LastTitle, titleFont, 0, 1, image.Rect(
+		DISP_WIDTH/2+DISP_WIDTH/4-titleFontWidth*len(lay.LastTitle)/2, 0, DISP_WIDTH, DISP_HEIGHT), true, false, false, false)
 
+	for _, b := range lay.Bars {
+		barHeight := int(b.Price * yConv)
+		bar := image.Rect(
+			barMargin+b.Index*barWidth,
+			zeroY-barHeight,
+			barMargin+(b.Index+1)*barWidth-1-BARGAP,
+			zeroY)
+
+		if 0 < len(b.Segments) {
+			fillStackedBar(&blackPic, &redPic, bar.Min.X, bar.Max.X, zeroY, b, yConv)
+			continue
+		}
 		blackPic.Fill(bar, true)
-		if firstExpensive <= price {
+		if b.Expensive {
 			redPic.Fill(bar, true)
 		}
 	}
 
-	lastExpensive := maxNvaluesOnThreshold(p.LastData[:], expensiveHourCount)
-	for h, price := range p.LastData { //BAD copy paste. TODO refactor
-		barHeight := int(price * yConv)
-
-		bar := image.Rect(
-			barMargin+h*barWidth,
-			TITLE_HEIGHT+plotHeight-barHeight,
-			barMargin+(h+1)*barWidth-1-BARGAP,
-			TITLE_HEIGHT+plotHeight)
-
-		bar.Min.X += barWidth * 24
-		bar.Max.X += barWidth * 24
+	if lay.Bracket != nil {
+		x0 := barMargin + lay.Bracket.First*barWidth
+		x1 := barMargin + (lay.Bracket.First+lay.Bracket.Count)*barWidth - 1 - BARGAP
+		y := zeroY - int(lay.BracketTop*yConv) - BRACKETGAP
+		if y < TITLE_HEIGHT+1 {
+			y = TITLE_HEIGHT + 1
+		}
+		blackPic.Hline(x0, x1, y, true)
+		blackPic.Vline(x0, y, y+BRACKETLEG, true)
+		blackPic.Vline(x1, y, y+BRACKETLEG, true)
+	}
 
-		blackPic.Fill(bar, true)
-		if lastExpensive <= price {
-			redPic.Fill(bar, true)
+	for i, strip := range lay.Strips {
+		y0 := plotBottom + 1 + STRIPGAP + i*(STRIPHEIGHT+STRIPGAP)
+		for index, mark := range strip.Marks {
+			x0 := barMargin + index*barWidth
+			x1 := barMargin + (index+1)*barWidth - 1 - BARGAP
+			cell := image.Rect(x0, y0, x1, y0+STRIPHEIGHT-1)
+			switch mark {
+			case STRIPLOW:
+				blackPic.Hline(x0, x1, y0+STRIPHEIGHT/2, true)
+			case STRIPHIGH:
+				blackPic.Fill(cell, true)
+			case STRIPALERT:
+				blackPic.Fill(cell, true)
+				redPic.Fill(cell, true)
+			case STRIPUP:
+				blackPic.SetPix((x0+x1)/2, y0, true)
+				blackPic.Hline(x0, x1, y0+1, true)
+			case STRIPDOWN:
+				for _, pic := range []*gomonochromebitmap.MonoBitmap{&blackPic, &redPic} {
+					pic.Hline(x0, x1, y0+1, true)
+					pic.SetPix((x0+x1)/2, y0+2, true)
+				}
+			}
 		}
 	}
 
 	//Yscale, small ticks
-	for v := float64(0); v < plotMax; v += SMALLTICKPRICESTEP {
-		tickpos := DISP_HEIGHT - XAXIS_HEIGHT - int(v*yConv)
+	for _, v := range lay.SmallTicks {
+		tickpos := zeroY - int(v*yConv)
 		blackPic.Hline(0, SMALLTICKLEN, tickpos, true)
-		if 0 < v {
+		if v != 0 {
 			blackPic.Print(fmt.Sprintf("%.0f", v), tickFont, 0, 0, image.Rect(2, tickpos-2, DISP_WIDTH, DISP_HEIGHT), true, false, false, false)
 		}
 	}
 	//Yscale, large ticks
-	for v := float64(0); v < plotMax; v += TICKPRICESTEP {
-		blackPic.Hline(0, TICKLEN, DISP_HEIGHT-XAXIS_HEIGHT-int(v*yConv), true)
+	for _, v := range lay.Ticks {
+		blackPic.Hline(0, TICKLEN, zeroY-int(v*yConv), true)
 	}
 
 	return blackPic, redPic, nil
 }
 
-func createPngOutput(filename string, blackPic *gomonochromebitmap.MonoBitmap, redPic *gomonochromebitmap.MonoBitmap) error {
-	if len(filename) == 0 {
-		return nil
+//stackPatterns are fills of stacked segments, bottom first. Pattern repeats if there are more segments
+var stackPatterns = []func(x int, y int) bool{
+	func(x int, y int) bool { return true },
+	func(x int, y int) bool { return (x+y)%2 == 0 },
+	func(x int, y int) bool { return y%2 == 0 },
+	func(x int, y int) bool { return y%3 == 0 },
+	func(x int, y int) bool { return (x+y)%4 == 0 },
+}
+
+/*
+fillStackedBar fills segments of bar between x0 and x1 with own patterns. Expensive bar is drawn red.
+Positive segments are stacked up from zero line and negative segments (like negative spot price) down from it
+*/
+func fillStackedBar(blackPic *gomonochromebitmap.MonoBitmap, redPic *gomonochromebitmap.MonoBitmap, x0 int, x1 int, zeroY int, b chartBar, yConv float64) {
+	above, below := float64(0), float64(0)
+	for i, v := range b.Segments {
+		var top, bottom int
+		switch {
+		case 0 < v:
+			top, bottom = zeroY-int((above+v)*yConv), zeroY-int(above*yConv) //Inclusive like MonoBitmap.Fill
+			if 0 < above {
+				bottom--
+			}
+			above += v
+		case v < 0:
+			top, bottom = zeroY-int(below*yConv)+1, zeroY-int((below+v)*yConv)
+			below += v
+		default:
+			continue
+		}
+		pattern := stackPatterns[i%len(stackPatterns)]
+		for y := top; y <= bottom; y++ {
+			for x := x0; x <= x1; x++ {
+				if pattern(x, y) {
+					blackPic.SetPix(x, y, true)
+					if b.Expensive {
+						redPic.SetPix(x, y, true)
+					}
+				}
+			}
+		}
 	}
+}
+
+func writePng(w io.Writer, blackPic *gomonochromebitmap.MonoBitmap, redPic *gomonochromebitmap.MonoBitmap) error {
 	planar, errPlanar := gomonochromebitmap.CreatePlanarColorImage([]gomonochromebitmap.MonoBitmap{*blackPic, *redPic}, []color.Color{
 		color.White, color.Black, color.RGBA{R: 255, A: 255}, color.RGBA{R: 255, A: 255}})
 	if errPlanar != nil {
 		return fmt.Errorf("planar color image err%v", errPlanar.Error())
 	}
+	return png.Encode(w, planar)
+}
 
+func createPngOutput(filename string, blackPic *gomonochromebitmap.MonoBitmap, redPic *gomonochromebitmap.MonoBitmap) error {
+	if len(filename) == 0 {
+		return nil
+	}
 	out, errCreateOut := os.Create(filename)
 	if errCreateOut != nil {
 		return fmt.Errorf("err creating %v debugfile %v", filename, errCreateOut.Error())
 	}
-	errEncode := png.Encode(out, planar)
+	errEncode := writePng(out, blackPic, redPic)
 	if errEncode != nil {
+		out.Close()
 		return fmt.Errorf("error png-encode debugfile %v err=%v", filename, errEncode.Error())
 	}
 	closeErr := out.Close()
@@ -228,6 +469,48 @@ func UpdateAndShutdownEpaper(lowLevel EPD0213LowLevel, blackPic *gomonochromebit
 	return nil
 }
 
+func sameBitmap(a gomonochromebitmap.MonoBitmap, b gomonochromebitmap.MonoBitmap) bool {
+	if a.W != b.W || a.H != b.H || len(a.Pix) != len(b.Pix) {
+		return false
+	}
+	for i := range a.Pix {
+		if a.Pix[i] != b.Pix[i] {
+			return false
+		}
+	}
+	return true
+}
+
+const EPAPERCHECK_INTERVAL = 15 * time.Minute
+
+//RunEpaperRefresh is for long running modes. Redraws e-paper only when view changes. Never returns
+func RunEpaperRefresh(lowLevel EPD0213LowLevel, view func(tNow time.Time) (PriceView, error), expensiveHourCount int) {
+	var shownBlack, shownRed gomonochromebitmap.MonoBitmap
+	for {
+		pw, errView := view(time.Now())
+		if errView == nil {
+			black, red, errGen := pw.CreateBlackRedView(expensiveHourCount)
+			if errGen != nil {
+				fmt.Printf("Error generating view %v\n", errGen.Error())
+			} else if !sameBitmap(black, shownBlack) || !sameBitmap(red, shownRed) {
+				errUpdate := UpdateAndShutdownEpaper(lowLevel, &black, &red)
+				if errUpdate != nil {
+					fmt.Printf("Hardware error %v\n", errUpdate.Error())
+				} else {
+					shownBlack, shownRed = black, red
+				}
+			}
+		} else {
+			fmt.Printf("Error getting data %v\n", errView.Error())
+		}
+		wait := untilNextHour(time.Now())
+		if EPAPERCHECK_INTERVAL < wait {
+			wait = EPAPERCHECK_INTERVAL
+		}
+		time.Sleep(wait + time.Second)
+	}
+}
+
 const (
 	VALIDEPOCHLIMIT = 1663890653963
 )
@@ -239,8 +522,32 @@ func waitClock() {
 	}
 }
 
+//Subcommands, given as first argument. Without subcommand spotview renders view once
+var subCommands = map[string]func(args []string) error{
+	"serve":    cmdServe,
+	"fetch":    cmdFetch,
+	"export":   cmdExport,
+	"cheapest": cmdCheapest,
+	"plan":     cmdPlan,
+	"backtest": cmdBacktest,
+	"ics":      cmdIcs,
+}
+
 func main() {
+	if 1 < len(os.Args) {
+		cmd, haveCmd := subCommands[os.Args[1]]
+		if haveCmd {
+			errCmd := cmd(os.Args[2:])
+			if errCmd != nil {
+				fmt.Printf("%s failed %v\n", os.Args[1], errCmd.Error())
+				os.Exit(-1)
+			}
+			return
+		}
+	}
+
 	pOutputFileName := flag.String("o", "/tmp/spotview.png", "outputfilename (in .png) what spotview renders on screen")
+	pSvgFileName := flag.String("svg", "", "optional outputfilename (in .svg) for scalable version of view")
 	pNohw := flag.Bool("nohw", false, "e-paper is not available")
 	pCacheDirName := flag.String("cache", "/tmp/vattenfallcache", "download cache dirname for downloaded price data. (prefer non-volatile location if possible)")
 
@@ -250,17 +557,43 @@ func main() {
 	pDataModePinName := flag.String("pindc", "GPIO25", " data mode pin name (pin6 D/C on display)")
 
 	pNumberOfExpensiveHours := flag.Int("e", 6, "number of expensive hours per 24h highlighted in red")
+	pWindow := flag.Int("window", 0, "show cheapest contiguous window of this many hours as bracket, 0 disables")
+	pAllowed := flag.String("allowed", "", "allowed hour ranges of window like 22-07,10-14 (default all day)")
+	pConfigFileName := flag.String("config", "", "optional configuration file, tariff with stack is drawn on bars")
+	getVatMode := vatFlags(flag.CommandLine)
 
 	flag.Parse()
 
+	allowed, errAllowed := ParseHourRanges(*pAllowed)
+	if errAllowed != nil {
+		fmt.Printf("%v\n", errAllowed.Error())
+		os.Exit(-1)
+	}
+	conf, errConf := LoadConfig(*pConfigFileName)
+	if errConf != nil {
+		fmt.Printf("%v\n", errConf.Error())
+		os.Exit(-1)
+	}
+	vatMode, errVat := getVatMode()
+	if errVat != nil {
+		fmt.Printf("%v\n", errVat.Error())
+		os.Exit(-1)
+	}
+
 	//Waiting clock. Needed in case of appliance
 	waitClock()
 
-	pw, errGet := GetPriceViewVattenfall(time.Now(), *pCacheDirName)
+	store := NewPriceStore(*pCacheDirName)
+	store.Vat = vatMode
+	pw, errGet := store.PriceView(time.Now())
 	if errGet != nil {
 		fmt.Printf("Error getting data %v\n", errGet.Error())
 		os.Exit(-1)
 	}
+	store.ShowWindow(&pw, time.Now(), *pWindow, allowed)
+	if conf.Tariff != nil && conf.Tariff.Stack {
+		store.ShowTariff(&pw, *conf.Tariff)
+	}
 
 	testBlack, testRed, genErr := pw.CreateBlackRedView(*pNumberOfExpensiveHours)
 	if genErr != nil {
@@ -276,6 +609,12 @@ func main() {
 	}
 	fmt.Printf("wrote output %v\n", *pOutputFileName)
 
+	errSvg := createSvgOutput(*pSvgFileName, &pw, *pNumberOfExpensiveHours)
+	if errSvg != nil {
+		fmt.Printf("%v\n", errSvg.Error())
+		os.Exit(-1)
+	}
+
 	if !*pNohw {
 		lowLevel, errLowLevel := InitEPD0213LowLevel(*pSpiName, *pReadyPinName, *pResetPin, *pDataModePinName)
 
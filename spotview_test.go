@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/hjkoskel/gomonochromebitmap"
+)
+
+//stackedTestView has tariff like segments on all bars, spot of hour 3 is negative
+func stackedTestView() PriceView {
+	pw := PriceView{FirstName: "Ma", LastName: "Ti", Stack: &ChartStack{Labels: hourCostLabels}}
+	for i := range pw.Stack.Segments {
+		spot := float64(5)
+		if i == 3 {
+			spot = -4
+		}
+		pw.Stack.Segments[i] = []float64{spot, 1, 2, 2.25, 0}
+	}
+	return pw
+}
+
+func TestStackedLayout(t *testing.T) {
+	pw := stackedTestView()
+	lay := pw.layout(EXPENSIVEHOURCOUNT)
+	if lay.PlotMin != -4 || lay.PlotMax != PRICEINCREMENT {
+		t.Fatalf("y-axis %v - %v, wanted -4 - %v", lay.PlotMin, lay.PlotMax, PRICEINCREMENT)
+	}
+	if lay.Bars[3].Price != 1.25 || lay.Bars[4].Price != 10.25 {
+		t.Fatalf("bar totals %v and %v", lay.Bars[3].Price, lay.Bars[4].Price)
+	}
+	if lay.SmallTicks[0] != 0 {
+		t.Fatalf("first tick %v, no tick fits under zero", lay.SmallTicks[0])
+	}
+}
+
+func TestFillStackedBar(t *testing.T) {
+	black := gomonochromebitmap.NewMonoBitmap(DISP_WIDTH, DISP_HEIGHT, false)
+	red := gomonochromebitmap.NewMonoBitmap(DISP_WIDTH, DISP_HEIGHT, false)
+	zeroY := 50
+	fillStackedBar(&black, &red, 10, 12, zeroY, chartBar{Index: 3, Price: -1, Segments: []float64{-4, 1, 2}}, 1)
+	drawn := func(y int) bool { //Segments have patterns, some pixel of row is set
+		return black.GetPix(10, y) || black.GetPix(11, y) || black.GetPix(12, y)
+	}
+
+	for y := zeroY + 1; y <= zeroY+4; y++ {
+		if !drawn(y) {
+			t.Errorf("negative segment not drawn at y=%d", y)
+		}
+	}
+	if !drawn(zeroY) || !drawn(zeroY-1) {
+		t.Errorf("positive stack does not start from zero line")
+	}
+	for _, y := range []int{zeroY + 5, zeroY - 4} {
+		if drawn(y) {
+			t.Errorf("pixel outside bar at y=%d", y)
+		}
+	}
+	if red.GetPix(10, zeroY) {
+		t.Errorf("cheap bar drawn red")
+	}
+}
+
+func TestStackedSvg(t *testing.T) {
+	pw := stackedTestView()
+	var buf bytes.Buffer
+	errSvg := pw.WriteSvg(&buf, EXPENSIVEHOURCOUNT)
+	if errSvg != nil {
+		t.Fatal(errSvg)
+	}
+	svg := buf.String()
+	if !strings.Contains(svg, "03:00 spot -4.00 c/kWh, total 1.25") {
+		t.Fatalf("negative spot segment missing")
+	}
+	if strings.Contains(svg, `height="-`) {
+		t.Fatalf("negative height in svg")
+	}
+}
@@ -0,0 +1,174 @@
+/*
+Server-Sent Events. Watcher checks same clock and store that feeds PriceView and pushes events
+hour: at every hour boundary, current price
+tomorrow: when tomorrow prices get published
+expensive: when expensive/cheap state changes
+*/
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	SSECHECK_INTERVAL     = time.Minute
+	SSEHEARTBEAT_INTERVAL = 30 * time.Second
+	SSECLIENT_BUFFER      = 16
+)
+
+type SseEvent struct {
+	Name string
+	Data []byte
+}
+
+type EventHub struct {
+	mutex   sync.Mutex
+	clients map[chan SseEvent]bool
+	latest  map[string]SseEvent //Latest of each event, sent to new clients
+}
+
+func NewEventHub() *EventHub {
+	return &EventHub{clients: make(map[chan SseEvent]bool), latest: make(map[string]SseEvent)}
+}
+
+func (p *EventHub) Subscribe() chan SseEvent {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	ch := make(chan SseEvent, SSECLIENT_BUFFER)
+	for _, ev := range p.latest {
+		ch <- ev
+	}
+	p.clients[ch] = true
+	return ch
+}
+
+func (p *EventHub) Unsubscribe(ch chan SseEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	delete(p.clients, ch)
+}
+
+//Broadcast drops event for clients that are not keeping up
+func (p *EventHub) Broadcast(ev SseEvent) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.latest[ev.Name] = ev
+	for ch := range p.clients {
+		select {
+		case ch <- ev:
+		default:
+		}
+	}
+}
+
+type ApiExpensiveChange struct {
+	Time      string  `json:"time"`
+	Price     float64 `json:"price"`
+	Expensive bool    `json:"expensive"`
+}
+
+//eventWatcher keeps state between checks
+type eventWatcher struct {
+	hourStart     time.Time
+	tomorrowDate  string //Date of tomorrow when its prices were seen
+	haveExpensive bool
+	expensive     bool
+}
+
+//check produces events that happened since previous check
+func (p *eventWatcher) check(store *PriceStore, expensiveHourCount int, tNow time.Time) []SseEvent {
+	result := []SseEvent{}
+	today, errToday := store.ShownDayPrices(tNow)
+	if errToday != nil {
+		return result
+	}
+	expensive := expensiveFlags(today, expensiveHourCount)
+	for i, hp := range today {
+		if tNow.Before(hp.Start) || !tNow.Before(hp.Start.Add(time.Hour)) {
+			continue
+		}
+		if !hp.Start.Equal(p.hourStart) {
+			p.hourStart = hp.Start
+			data, _ := json.Marshal(ApiNow{
+				Time:      hp.Start.Format(time.RFC3339),
+				Until:     hp.Start.Add(time.Hour).Format(time.RFC3339),
+				Price:     hp.Price,
+				Unit:      VATTENFALLEXPECTED_UNIT,
+				Expensive: expensive[i],
+			})
+			result = append(result, SseEvent{Name: "hour", Data: data})
+		}
+		if !p.haveExpensive || p.expensive != expensive[i] {
+			p.haveExpensive = true
+			p.expensive = expensive[i]
+			data, _ := json.Marshal(ApiExpensiveChange{Time: hp.Start.Format(time.RFC3339), Price: hp.Price, Expensive: expensive[i]})
+			result = append(result, SseEvent{Name: "expensive", Data: data})
+		}
+	}
+
+	tTomorrow := tNow.Add(time.Hour * 24)
+	tomorrow, errTomorrow := store.ShownDayPrices(tTomorrow)
+	if errTomorrow == nil && 0 < len(tomorrow) {
+		date := tomorrow[0].Start.Format("2006-01-02")
+		if date != p.tomorrowDate {
+			p.tomorrowDate = date
+			data, _ := json.Marshal(ApiPrices{
+				Date:   date,
+				Unit:   VATTENFALLEXPECTED_UNIT,
+				Prices: toApiHourPrices(tomorrow, expensiveFlags(tomorrow, expensiveHourCount)),
+			})
+			result = append(result, SseEvent{Name: "tomorrow", Data: data})
+		}
+	}
+	return result
+}
+
+//RunEvents checks for events at hour boundaries and between. Never returns
+func (p *SpotServer) RunEvents() {
+	watcher := eventWatcher{}
+	for {
+		for _, ev := range watcher.check(p.Store, p.ExpensiveHourCount, p.Now()) {
+			p.Events.Broadcast(ev)
+		}
+		wait := untilNextHour(p.Now())
+		if SSECHECK_INTERVAL < wait {
+			wait = SSECHECK_INTERVAL
+		}
+		time.Sleep(wait + 100*time.Millisecond)
+	}
+}
+
+func (p *SpotServer) handleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, canFlush := w.(http.Flusher)
+	if !canFlush {
+		http.Error(w, "streaming not supported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ch := p.Events.Subscribe()
+	defer p.Events.Unsubscribe(ch)
+	heartbeat := time.NewTicker(SSEHEARTBEAT_INTERVAL)
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case ev := <-ch:
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Name, ev.Data)
+			flusher.Flush()
+		case <-heartbeat.C:
+			fmt.Fprintf(w, ": heartbeat\n\n")
+			flusher.Flush()
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestEventWatcher(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1)) //Hours 18-23 are expensive
+	watcher := eventWatcher{}
+	publishTomorrow := func() {
+		tTomorrow := testDate(t, "2026-10-20")
+		writeTestDay(t, store.CacheDir, tTomorrow, rampPrices(30, -1))
+		key, _ := vattenfallCacheFileName(tTomorrow)
+		delete(store.failed, key)
+	}
+
+	steps := []struct {
+		clock   string
+		publish bool
+		wanted  []string
+	}{
+		{"17:50", false, []string{"hour", "expensive"}}, //Initial state
+		{"17:55", false, []string{}},
+		{"18:00", false, []string{"hour", "expensive"}}, //Turns expensive
+		{"18:30", false, []string{}},
+		{"18:40", true, []string{"tomorrow"}},
+		{"18:50", false, []string{}},
+		{"19:00", false, []string{"hour"}}, //Still expensive
+	}
+	for _, step := range steps {
+		if step.publish {
+			publishTomorrow()
+		}
+		events := watcher.check(store, 6, testClock(t, step.clock))
+		names := []string{}
+		for _, ev := range events {
+			names = append(names, ev.Name)
+		}
+		if !reflect.DeepEqual(names, step.wanted) {
+			t.Errorf("at %s events %v, wanted %v", step.clock, names, step.wanted)
+		}
+
+		if step.clock == "18:00" {
+			now := ApiNow{}
+			json.Unmarshal(events[0].Data, &now)
+			change := ApiExpensiveChange{}
+			json.Unmarshal(events[1].Data, &change)
+			if now.Price != 19 || !now.Expensive || !change.Expensive {
+				t.Errorf("unexpected 18:00 events %s %s", events[0].Data, events[1].Data)
+			}
+		}
+		if step.publish && len(events) == 1 {
+			prices := ApiPrices{}
+			json.Unmarshal(events[0].Data, &prices)
+			if prices.Date != "2026-10-20" || len(prices.Prices) != 24 {
+				t.Errorf("unexpected tomorrow event %s", events[0].Data)
+			}
+		}
+	}
+}
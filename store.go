@@ -0,0 +1,157 @@
+/*
+In-memory layer over vattenfall cache for long running modes (server etc..)
+Keeps parsed days in memory and prevents hammering vattenfall when tomorrow prices are not published yet.
+Prices are kept as source gives them, so control thresholds do not depend on VAT options. Shown* getters convert for chart and apis
+*/
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const STOREFAILRETRY = 10 * time.Minute //How often day that failed is tried again
+
+type PriceStore struct {
+	CacheDir string
+	Vat      *VatMode //Conversion of shown prices (Shown* getters), nil keeps prices of source
+
+	mutex  sync.Mutex
+	days   map[string]VattenfallData
+	failed map[string]time.Time //When fetching day failed last time
+}
+
+func NewPriceStore(cacheDir string) *PriceStore {
+	return &PriceStore{
+		CacheDir: cacheDir,
+		days:     make(map[string]VattenfallData),
+		failed:   make(map[string]time.Time),
+	}
+}
+
+//Day gets source data for day from memory, cache file or vattenfall
+func (p *PriceStore) Day(t time.Time) (VattenfallData, error) {
+	key, errKey := vattenfallCacheFileName(t)
+	if errKey != nil {
+		return VattenfallData{}, errKey
+	}
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	data, haveData := p.days[key]
+	if haveData {
+		return data, nil
+	}
+	tFail, haveFail := p.failed[key]
+	if haveFail && time.Since(tFail) < STOREFAILRETRY {
+		return VattenfallData{}, fmt.Errorf("data for %s not available, retry after %v", key, tFail.Add(STOREFAILRETRY).Format(time.RFC3339))
+	}
+
+	data, errGet := GetVattenfallData(t, p.CacheDir)
+	if errGet == nil {
+		errGet = data.CheckErr(t) //GetVattenfallData returns also invalid data if download fails
+	}
+	if errGet != nil {
+		p.failed[key] = time.Now()
+		return VattenfallData{}, errGet
+	}
+	delete(p.failed, key)
+	p.days[key] = data
+	return data, nil
+}
+
+//ShownDay is day converted to shown prices by VAT mode
+func (p *PriceStore) ShownDay(t time.Time) (VattenfallData, error) {
+	data, errData := p.Day(t)
+	if errData != nil {
+		return data, errData
+	}
+	return p.Vat.Convert(data), nil
+}
+
+//PriceView is chart of shown prices
+func (p *PriceStore) PriceView(tNow time.Time) (PriceView, error) {
+	return getPriceView(tNow, p.ShownDay)
+}
+
+//DayPrices gets timestamped source prices of day
+func (p *PriceStore) DayPrices(t time.Time) ([]HourPrice, error) {
+	return dayPrices(p.Day, t)
+}
+
+//ShownDayPrices gets timestamped shown prices of day
+func (p *PriceStore) ShownDayPrices(t time.Time) ([]HourPrice, error) {
+	return dayPrices(p.ShownDay, t)
+}
+
+func dayPrices(getData func(t time.Time) (VattenfallData, error), t time.Time) ([]HourPrice, error) {
+	data, errData := getData(t)
+	if errData != nil {
+		return nil, errData
+	}
+	return data.HourPrices()
+}
+
+//Horizon returns all known source prices of today and tomorrow (if published)
+func (p *PriceStore) Horizon(tNow time.Time) ([]HourPrice, error) {
+	return horizonOf(p.DayPrices, tNow)
+}
+
+//ShownHorizon is horizon of shown prices
+func (p *PriceStore) ShownHorizon(tNow time.Time) ([]HourPrice, error) {
+	return horizonOf(p.ShownDayPrices, tNow)
+}
+
+func horizonOf(getDay func(t time.Time) ([]HourPrice, error), tNow time.Time) ([]HourPrice, error) {
+	result, errToday := getDay(tNow)
+	if errToday != nil {
+		return nil, errToday
+	}
+	tomorrow, errTomorrow := getDay(tNow.Add(time.Hour * 24))
+	if errTomorrow == nil {
+		result = append(result, tomorrow...)
+	}
+	return result, nil
+}
+
+//Cheapest hours, result is sorted by time
+func cheapestHours(prices []HourPrice, n int) []HourPrice {
+	sorted := make([]HourPrice, len(prices))
+	copy(sorted, prices)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Price < sorted[j].Price })
+	if n < len(sorted) {
+		sorted = sorted[:n]
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Start.Before(sorted[j].Start) })
+	return sorted
+}
+
+//Prices only as float array. Needed for maxNvaluesOnThreshold etc..
+func priceValues(prices []HourPrice) []float64 {
+	result := make([]float64, len(prices))
+	for i, hp := range prices {
+		result[i] = hp.Price
+	}
+	return result
+}
+
+//expensiveFlags marks n most expensive hours of each day like on chart
+func expensiveFlags(prices []HourPrice, n int) []bool {
+	dayValues := make(map[string][]float64)
+	for _, hp := range prices {
+		day := hp.Start.Format("2006-01-02")
+		dayValues[day] = append(dayValues[day], hp.Price)
+	}
+	thresholds := make(map[string]float64)
+	for day, values := range dayValues {
+		thresholds[day] = maxNvaluesOnThreshold(values, n)
+	}
+	result := make([]bool, len(prices))
+	for i, hp := range prices {
+		result[i] = thresholds[hp.Start.Format("2006-01-02")] <= hp.Price
+	}
+	return result
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"testing"
+	"time"
+)
+
+//writeTestDay writes cache file of finnish day, like downloaded from vattenfall
+func writeTestDay(t *testing.T, cacheDir string, day time.Time, prices []float64) {
+	t.Helper()
+	lt, _ := TimeInFinland(day)
+	date := lt.Format("2006-01-02")
+	data := VattenfallData{}
+	for hour, price := range prices {
+		data = append(data, VattenfallItem{
+			TimeStamp:     fmt.Sprintf("%sT%02d:00:00", date, hour),
+			TimeStampDay:  date,
+			TimeStampHour: fmt.Sprintf("%02d:00", hour),
+			Value:         price,
+			Unit:          VATTENFALLEXPECTED_UNIT,
+		})
+	}
+	content, _ := json.Marshal(data)
+	errWrite := os.WriteFile(path.Join(cacheDir, date+".json"), content, 0666)
+	if errWrite != nil {
+		t.Fatal(errWrite)
+	}
+}
+
+//testDate is noon of YYYY-MM-DD in finnish time
+func testDate(t *testing.T, s string) time.Time {
+	t.Helper()
+	result, errParse := ParseDateInFinland(s)
+	if errParse != nil {
+		t.Fatal(errParse)
+	}
+	return result
+}
+
+//testStore has consecutive days starting from first in cache. Days after them fail without downloading
+func testStore(t *testing.T, first string, days ...[]float64) *PriceStore {
+	t.Helper()
+	dir := t.TempDir()
+	tFirst := testDate(t, first)
+	for i, prices := range days {
+		writeTestDay(t, dir, tFirst.AddDate(0, 0, i), prices)
+	}
+	result := NewPriceStore(dir)
+	for d := -7; d < 0; d++ {
+		key, _ := vattenfallCacheFileName(tFirst.AddDate(0, 0, d))
+		result.failed[key] = time.Now()
+	}
+	for d := len(days); d < len(days)+7; d++ {
+		key, _ := vattenfallCacheFileName(tFirst.AddDate(0, 0, d))
+		result.failed[key] = time.Now()
+	}
+	return result
+}
+
+//rampPrices is 24 prices from start with step
+func rampPrices(start float64, step float64) []float64 {
+	result := make([]float64, 24)
+	for i := range result {
+		result[i] = start + float64(i)*step
+	}
+	return result
+}
+
+func TestStoreHorizon(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	tNow := testDate(t, "2026-10-19")
+	horizon, errHorizon := store.Horizon(tNow)
+	if errHorizon != nil {
+		t.Fatal(errHorizon)
+	}
+	if len(horizon) != 24 {
+		t.Fatalf("horizon has %d hours without tomorrow, wanted 24", len(horizon))
+	}
+	lt, _ := TimeInFinland(horizon[0].Start)
+	if lt.Hour() != 0 || lt.Day() != 19 || horizon[23].Price != 24 {
+		t.Fatalf("unexpected horizon %v ... %v", horizon[0], horizon[23])
+	}
+}
+
+func TestStoreVat(t *testing.T) {
+	store := testStore(t, "2026-10-19", rampPrices(1, 1), rampPrices(30, -1))
+	store.Vat = &VatMode{Table: DEFAULTVATTABLE, SourceIncludesVat: true, ShowVat: false}
+	tNow := testDate(t, "2026-10-19")
+	shown, errShown := store.ShownDayPrices(tNow)
+	if errShown != nil {
+		t.Fatal(errShown)
+	}
+	if math.Abs(shown[9].Price-10/1.255) > 1e-9 {
+		t.Fatalf("shown price %v, wanted net", shown[9].Price)
+	}
+	source, errSource := store.DayPrices(tNow)
+	if errSource != nil {
+		t.Fatal(errSource)
+	}
+	if source[9].Price != 10 {
+		t.Fatalf("source price %v changed by vat mode", source[9].Price)
+	}
+	pw, errView := store.PriceView(tNow)
+	if errView != nil {
+		t.Fatal(errView)
+	}
+	if math.Abs(pw.FirstData[9]-10/1.255) > 1e-9 {
+		t.Fatalf("view price %v, wanted net", pw.FirstData[9])
+	}
+}
@@ -0,0 +1,199 @@
+/*
+Vector (svg) rendering of price view. Uses same layout as e-paper bitmap but with real text
+so it scales on phones, wikis and dashboards
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+const (
+	SVG_WIDTH          = 848
+	SVG_HEIGHT         = 440
+	SVG_MARGINLEFT     = 56
+	SVG_MARGINRIGHT    = 16
+	SVG_TITLEHEIGHT    = 36
+	SVG_XAXISHEIGHT    = 36
+	SVG_LEGENDHEIGHT   = 28
+	SVG_BARGAPFACTOR   = 0.2 //Part of bar slot left empty
+	SVG_FONT           = "DejaVu Sans, Helvetica, Arial, sans-serif"
+	SVG_COLORNORMAL    = "#000000"
+	SVG_COLOREXPENSIVE = "#e00000"
+	SVG_COLORGRID      = "#d0d0d0"
+	SVG_COLORBRACKET   = "#0050c0"
+	SVG_COLORSTRIPLOW  = "#909090"
+	SVG_STRIPHEIGHT    = 12
+	SVG_STRIPGAP       = 4
+)
+
+//svgStackColors are fills of stacked segments, bottom first
+var svgStackColors = []string{"#000000", "#505050", "#808080", "#a8a8a8", "#cccccc"}
+var svgStackExpensiveColors = []string{"#e00000", "#e84848", "#ef7878", "#f4a4a4", "#f8c8c8"}
+
+//svgStripColors are fill colors of strip marks, STRIPNONE is not drawn
+var svgStripColors = map[StripMark]string{
+	STRIPLOW:   SVG_COLORSTRIPLOW,
+	STRIPHIGH:  SVG_COLORNORMAL,
+	STRIPALERT: SVG_COLOREXPENSIVE,
+}
+
+func svgEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+//WriteSvg renders view as svg document
+func (p *PriceView) WriteSvg(w io.Writer, expensiveHourCount int) error {
+	lay := p.layout(expensiveHourCount)
+
+	plotLeft := float64(SVG_MARGINLEFT)
+	plotWidth := float64(SVG_WIDTH - SVG_MARGINLEFT - SVG_MARGINRIGHT)
+	plotTop := float64(SVG_TITLEHEIGHT)
+	stripsHeight := float64(len(lay.Strips) * (SVG_STRIPHEIGHT + SVG_STRIPGAP))
+	plotHeight := float64(SVG_HEIGHT-SVG_TITLEHEIGHT-SVG_XAXISHEIGHT-SVG_LEGENDHEIGHT) - stripsHeight
+	plotBottom := plotTop + plotHeight
+	axisBottom := plotBottom + stripsHeight
+	slotWidth := plotWidth / 48
+	yConv := plotHeight / (lay.PlotMax - lay.PlotMin)
+	zeroY := plotBottom + lay.PlotMin*yConv //Negative prices are drawn below
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, `<svg xmlns="http://www.w3.org/2000/svg" viewBox="0 0 %d %d" width="%d" height="%d" font-family="%s">`+"\n",
+		SVG_WIDTH, SVG_HEIGHT, SVG_WIDTH, SVG_HEIGHT, SVG_FONT)
+	fmt.Fprintf(&buf, `<rect x="0" y="0" width="%d" height="%d" fill="#ffffff"/>`+"\n", SVG_WIDTH, SVG_HEIGHT)
+
+	//Titles, centered on both days
+	fmt.Fprintf(&buf, `<text x="%.1f" y="24" font-size="18" text-anchor="middle">%s</text>`+"\n", plotLeft+plotWidth/4, svgEscape(lay.FirstTitle))
+	fmt.Fprintf(&buf, `<text x="%.1f" y="24" font-size="18" text-anchor="middle">%s</text>`+"\n", plotLeft+3*plotWidth/4, svgEscape(lay.LastTitle))
+
+	//Y-axis grid and labels
+	for _, v := range lay.SmallTicks {
+		y := zeroY - v*yConv
+		fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="%s" stroke-width="1"/>`+"\n", plotLeft, y, plotLeft+plotWidth, y, SVG_COLORGRID)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="12" text-anchor="end">%.0f</text>`+"\n", plotLeft-6, y+4, v)
+	}
+	fmt.Fprintf(&buf, `<text x="14" y="%.1f" font-size="12" text-anchor="middle" transform="rotate(-90 14 %.1f)">c/kWh</text>`+"\n", plotTop+plotHeight/2, plotTop+plotHeight/2)
+
+	//Bars
+	for _, b := range lay.Bars {
+		if 0 < len(b.Segments) {
+			colors := svgStackColors
+			if b.Expensive {
+				colors = svgStackExpensiveColors
+			}
+			above, below := zeroY, zeroY //Positive segments are stacked up from zero line, negative down
+			for i, v := range b.Segments {
+				if v == 0 {
+					continue
+				}
+				y := below
+				if 0 < v {
+					above -= v * yConv
+					y = above
+				} else {
+					below -= v * yConv
+				}
+				fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%02d:00 %s %.2f c/kWh, total %.2f</title></rect>`+"\n",
+					plotLeft+float64(b.Index)*slotWidth, y, slotWidth*(1-SVG_BARGAPFACTOR), math.Abs(v)*yConv, colors[i%len(colors)], b.Index%24, svgEscape(lay.Stack[i]), v, b.Price)
+			}
+			continue
+		}
+		h := b.Price * yConv
+		y := zeroY - h
+		if h < 0 {
+			y, h = zeroY, -h
+		}
+		color := SVG_COLORNORMAL
+		if b.Expensive {
+			color = SVG_COLOREXPENSIVE
+		}
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%.1f" fill="%s"><title>%02d:00 %.2f c/kWh</title></rect>`+"\n",
+			plotLeft+float64(b.Index)*slotWidth, y, slotWidth*(1-SVG_BARGAPFACTOR), h, color, b.Index%24, b.Price)
+	}
+
+	if lay.Bracket != nil {
+		x0 := plotLeft + float64(lay.Bracket.First)*slotWidth
+		x1 := plotLeft + float64(lay.Bracket.First+lay.Bracket.Count)*slotWidth - slotWidth*SVG_BARGAPFACTOR
+		y := zeroY - lay.BracketTop*yConv - 10
+		fmt.Fprintf(&buf, `<path d="M%.1f %.1f V%.1f H%.1f V%.1f" fill="none" stroke="%s" stroke-width="2"/>`+"\n", x0, y+6, y, x1, y+6, SVG_COLORBRACKET)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="12" text-anchor="middle" fill="%s">%s</text>`+"\n", (x0+x1)/2, y-4, SVG_COLORBRACKET, svgEscape(lay.Bracket.Label))
+	}
+
+	for i, strip := range lay.Strips {
+		y := plotBottom + float64(SVG_STRIPGAP+i*(SVG_STRIPHEIGHT+SVG_STRIPGAP))
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="10" text-anchor="end">%s</text>`+"\n", plotLeft-6, y+SVG_STRIPHEIGHT-2, svgEscape(strip.Label))
+		for index, mark := range strip.Marks {
+			x := plotLeft + float64(index)*slotWidth
+			w := slotWidth * (1 - SVG_BARGAPFACTOR)
+			switch mark {
+			case STRIPUP:
+				fmt.Fprintf(&buf, `<path d="M%.1f %.1f L%.1f %.1f L%.1f %.1f Z" fill="%s"/>`+"\n", x, y+SVG_STRIPHEIGHT, x+w/2, y, x+w, y+SVG_STRIPHEIGHT, SVG_COLORNORMAL)
+				continue
+			case STRIPDOWN:
+				fmt.Fprintf(&buf, `<path d="M%.1f %.1f L%.1f %.1f L%.1f %.1f Z" fill="%s"/>`+"\n", x, y, x+w/2, y+SVG_STRIPHEIGHT, x+w, y, SVG_COLOREXPENSIVE)
+				continue
+			}
+			color, drawn := svgStripColors[mark]
+			if !drawn {
+				continue
+			}
+			fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="%.1f" height="%d" fill="%s"/>`+"\n", x, y, w, SVG_STRIPHEIGHT, color)
+		}
+	}
+
+	//Axis lines
+	fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#000000" stroke-width="1.5"/>`+"\n", plotLeft, plotTop, plotLeft, plotBottom)
+	fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#000000" stroke-width="1.5"/>`+"\n", plotLeft, zeroY, plotLeft+plotWidth, zeroY)
+	//Day separator
+	fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#000000" stroke-dasharray="4 3"/>`+"\n", plotLeft+24*slotWidth, plotTop, plotLeft+24*slotWidth, plotBottom)
+
+	//X-axis labels
+	for _, n := range lay.HourLabels {
+		x := plotLeft + float64(n)*slotWidth
+		fmt.Fprintf(&buf, `<line x1="%.1f" y1="%.1f" x2="%.1f" y2="%.1f" stroke="#000000"/>`+"\n", x, axisBottom, x, axisBottom+5)
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="12" text-anchor="middle">%d</text>`+"\n", x, axisBottom+18, n%24)
+	}
+
+	//Legend
+	legendY := float64(SVG_HEIGHT - SVG_LEGENDHEIGHT + 6)
+	fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="12" height="12" fill="%s"/>`+"\n", plotLeft, legendY, SVG_COLORNORMAL)
+	fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="13">hour price</text>`+"\n", plotLeft+18, legendY+11)
+	fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="12" height="12" fill="%s"/>`+"\n", plotLeft+120, legendY, SVG_COLOREXPENSIVE)
+	fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="13">%d most expensive hours per day</text>`+"\n", plotLeft+138, legendY+11, expensiveHourCount)
+	for i, label := range lay.Stack {
+		x := plotLeft + 380 + float64(i)*80
+		fmt.Fprintf(&buf, `<rect x="%.1f" y="%.1f" width="12" height="12" fill="%s"/>`+"\n", x, legendY, svgStackColors[i%len(svgStackColors)])
+		fmt.Fprintf(&buf, `<text x="%.1f" y="%.1f" font-size="13">%s</text>`+"\n", x+18, legendY+11, svgEscape(label))
+	}
+
+	buf.WriteString("</svg>\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func createSvgOutput(filename string, pw *PriceView, expensiveHourCount int) error {
+	if len(filename) == 0 {
+		return nil
+	}
+	out, errCreateOut := os.Create(filename)
+	if errCreateOut != nil {
+		return fmt.Errorf("err creating %v svg file %v", filename, errCreateOut.Error())
+	}
+	errWrite := pw.WriteSvg(out, expensiveHourCount)
+	if errWrite != nil {
+		out.Close()
+		return fmt.Errorf("error writing svg %v err=%v", filename, errWrite.Error())
+	}
+	closeErr := out.Close()
+	if closeErr != nil {
+		return fmt.Errorf("closing %v error %v", filename, closeErr.Error())
+	}
+	return nil
+}
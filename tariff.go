@@ -0,0 +1,172 @@
+/*
+Tariff engine. Total price of hour is spot price + retailer margin + transfer (distribution energy fee) + electricity tax
++ optional share of monthly fixed fees. Transfer fee follows time-of-use rules like day/night or winter weekday.
+Tariff prices are configured without VAT, VAT is added by same VAT mode as spot prices
+*/
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+//Electricity tax including security of supply fee, c/kWh without VAT
+const (
+	ELECTRICITYTAX_CLASS1 = 2.24 + 0.013 //Households and most consumers
+	ELECTRICITYTAX_CLASS2 = 0.05 + 0.013 //Industry, data centers, greenhouses
+)
+
+var electricityTaxes = map[int]float64{0: 0, 1: ELECTRICITYTAX_CLASS1, 2: ELECTRICITYTAX_CLASS2}
+
+//TransferRule sets transfer fee when all given conditions match. Empty condition matches always
+type TransferRule struct {
+	Name     string  `json:"name"`
+	Price    float64 `json:"price"`              //c/kWh without VAT
+	Hours    string  `json:"hours,omitempty"`    //Hour ranges like 07-22
+	Months   []int   `json:"months,omitempty"`   //1-12
+	Weekdays []int   `json:"weekdays,omitempty"` //1 is monday, 7 is sunday
+}
+
+func (p *TransferRule) CheckErr() error {
+	_, errHours := ParseHourRanges(p.Hours)
+	if errHours != nil {
+		return fmt.Errorf("%s %v", p.Name, errHours.Error())
+	}
+	for _, month := range p.Months {
+		if month < 1 || 12 < month {
+			return fmt.Errorf("%s month must be 1-12", p.Name)
+		}
+	}
+	for _, weekday := range p.Weekdays {
+		if weekday < 1 || 7 < weekday {
+			return fmt.Errorf("%s weekday must be 1-7", p.Name)
+		}
+	}
+	return nil
+}
+
+func containsInt(list []int, v int) bool {
+	for _, item := range list {
+		if item == v {
+			return true
+		}
+	}
+	return false
+}
+
+//Matches hour starting at t (finnish time)
+func (p *TransferRule) Matches(t time.Time) bool {
+	lt, _ := TimeInFinland(t)
+	if 0 < len(p.Months) && !containsInt(p.Months, int(lt.Month())) {
+		return false
+	}
+	weekday := int(lt.Weekday())
+	if weekday == 0 {
+		weekday = 7
+	}
+	if 0 < len(p.Weekdays) && !containsInt(p.Weekdays, weekday) {
+		return false
+	}
+	ranges, _ := ParseHourRanges(p.Hours)
+	return hourAllowed(t, ranges)
+}
+
+type TariffConfig struct {
+	Transfer        float64        `json:"transfer"`                //Transfer fee c/kWh when no rule matches
+	TransferRules   []TransferRule `json:"transferRules,omitempty"` //First matching rule sets transfer fee
+	TaxClass        int            `json:"taxClass"`                //Electricity tax class 1 or 2, 0 leaves tax out
+	Margin          float64        `json:"margin"`                  //Retailer margin c/kWh
+	TransferMonthly float64        `json:"transferMonthly"`         //Fixed fee of distribution operator, EUR/month
+	RetailMonthly   float64        `json:"retailMonthly"`           //Fixed fee of retailer, EUR/month
+	MonthlyKWh      float64        `json:"monthlyKWh,omitempty"`    //Expected consumption, spreads fixed fees to hour prices. 0 leaves them out
+	Stack           bool           `json:"stack"`                   //Draw components as stacked bar segments on chart
+}
+
+func (p *TariffConfig) CheckErr() error {
+	_, knownClass := electricityTaxes[p.TaxClass]
+	if !knownClass {
+		return fmt.Errorf("taxClass must be 1 or 2 (0 without tax)")
+	}
+	if p.Transfer < 0 || p.Margin < 0 || p.TransferMonthly < 0 || p.RetailMonthly < 0 || p.MonthlyKWh < 0 {
+		return fmt.Errorf("fees must not be negative")
+	}
+	for i, rule := range p.TransferRules {
+		errRule := rule.CheckErr()
+		if errRule != nil {
+			return fmt.Errorf("transfer rule #%d %v", i, errRule.Error())
+		}
+	}
+	return nil
+}
+
+//TransferFee of hour without VAT
+func (p *TariffConfig) TransferFee(t time.Time) float64 {
+	for _, rule := range p.TransferRules {
+		if rule.Matches(t) {
+			return rule.Price
+		}
+	}
+	return p.Transfer
+}
+
+//HourCost is price of hour split to components, c/kWh. Total is sum of components
+type HourCost struct {
+	Start    time.Time
+	Spot     float64
+	Margin   float64
+	Transfer float64
+	Tax      float64
+	Fixed    float64 //Share of monthly fees
+	Total    float64
+}
+
+//Components in stacking order, bottom first
+func (p *HourCost) Components() []float64 {
+	return []float64{p.Spot, p.Margin, p.Transfer, p.Tax, p.Fixed}
+}
+
+var hourCostLabels = []string{"spot", "margin", "transfer", "tax", "fixed"}
+
+//HourCost computes components. Spot price is already shown price, other components get VAT by vat mode
+func (p *TariffConfig) HourCost(hp HourPrice, vat *VatMode) HourCost {
+	result := HourCost{
+		Start:    hp.Start,
+		Spot:     hp.Price,
+		Margin:   vat.FromNet(p.Margin, hp.Start),
+		Transfer: vat.FromNet(p.TransferFee(hp.Start), hp.Start),
+		Tax:      vat.FromNet(electricityTaxes[p.TaxClass], hp.Start),
+	}
+	if 0 < p.MonthlyKWh {
+		result.Fixed = vat.FromNet(100*(p.TransferMonthly+p.RetailMonthly)/p.MonthlyKWh, hp.Start)
+	}
+	for _, v := range result.Components() {
+		result.Total += v
+	}
+	return result
+}
+
+//HourCosts of prices with VAT mode of store
+func (p *PriceStore) HourCosts(conf TariffConfig, prices []HourPrice) []HourCost {
+	result := make([]HourCost, len(prices))
+	for i, hp := range prices {
+		result[i] = conf.HourCost(hp, p.Vat)
+	}
+	return result
+}
+
+//ShowTariff stacks components on bars of chart. Expensive hours are then classified by total price
+func (p *PriceStore) ShowTariff(pw *PriceView, conf TariffConfig) {
+	stack := ChartStack{Labels: hourCostLabels}
+	for dayIndex, day := range []time.Time{pw.FirstDay, pw.LastDay} {
+		prices, errPrices := p.ShownDayPrices(day)
+		if errPrices != nil {
+			fmt.Printf("tariff view failed %v\n", errPrices.Error())
+			return
+		}
+		for _, cost := range p.HourCosts(conf, prices) {
+			lt, _ := TimeInFinland(cost.Start)
+			stack.Segments[dayIndex*24+lt.Hour()] = cost.Components()
+		}
+	}
+	pw.Stack = &stack
+}
@@ -0,0 +1,158 @@
+/*
+Value added tax of electricity in Finland. VAT rate is date-effective table (default below, or json file),
+rate change happens at midnight finnish time. Vattenfall spot prices are consumer prices including VAT,
+so net price is derived with rate of that day
+*/
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+)
+
+const VATTENFALL_INCLUDES_VAT = true //Spot price api gives consumer prices
+
+type VatPeriod struct {
+	From    string  `json:"from"` //First day YYYY-MM-DD in Finland
+	Percent float64 `json:"percent"`
+}
+
+type VatTable []VatPeriod
+
+//DEFAULTVATTABLE is finnish general rate, with temporary reduced rate on electricity during winter 2022-2023
+var DEFAULTVATTABLE = VatTable{
+	{From: "2010-07-01", Percent: 23},
+	{From: "2013-01-01", Percent: 24},
+	{From: "2022-12-01", Percent: 10},
+	{From: "2023-05-01", Percent: 24},
+	{From: "2024-09-01", Percent: 25.5},
+}
+
+func (p VatTable) CheckErr() error {
+	if len(p) == 0 {
+		return fmt.Errorf("vat table is empty")
+	}
+	for i, period := range p {
+		_, errDate := time.Parse("2006-01-02", period.From)
+		if errDate != nil {
+			return fmt.Errorf("vat period #%d invalid date %s, use YYYY-MM-DD", i, period.From)
+		}
+		if period.Percent < 0 || 100 < period.Percent {
+			return fmt.Errorf("vat period #%d percent must be 0-100", i)
+		}
+		if 0 < i && period.From <= p[i-1].From {
+			return fmt.Errorf("vat period #%d is not after previous", i)
+		}
+	}
+	return nil
+}
+
+//LoadVatTable reads json array of {"from","percent"}. Empty filename is default table
+func LoadVatTable(filename string) (VatTable, error) {
+	if filename == "" {
+		return DEFAULTVATTABLE, nil
+	}
+	content, errRead := os.ReadFile(filename)
+	if errRead != nil {
+		return nil, fmt.Errorf("vat table read error %v", errRead.Error())
+	}
+	result := VatTable{}
+	errUnmarshal := json.Unmarshal(content, &result)
+	if errUnmarshal != nil {
+		return nil, fmt.Errorf("vat table %v parse error %v", filename, errUnmarshal.Error())
+	}
+	errCheck := result.CheckErr()
+	if errCheck != nil {
+		return nil, fmt.Errorf("vat table %v %v", filename, errCheck.Error())
+	}
+	return result, nil
+}
+
+//Percent of day YYYY-MM-DD. Before first period rate is 0
+func (p VatTable) Percent(date string) float64 {
+	result := float64(0)
+	for _, period := range p {
+		if date < period.From {
+			break
+		}
+		result = period.Percent
+	}
+	return result
+}
+
+//PercentAt is rate of finnish day of t
+func (p VatTable) PercentAt(t time.Time) float64 {
+	lt, _ := TimeInFinland(t)
+	return p.Percent(lt.Format("2006-01-02"))
+}
+
+func (p VatTable) Gross(net float64, t time.Time) float64 {
+	return net * (1 + p.PercentAt(t)/100)
+}
+
+func (p VatTable) Net(gross float64, t time.Time) float64 {
+	return gross / (1 + p.PercentAt(t)/100)
+}
+
+//VatMode converts source prices to shown prices. Only chart, apis and subcommand output are converted
+type VatMode struct {
+	Table             VatTable
+	SourceIncludesVat bool
+	ShowVat           bool //Chart and apis show prices with VAT
+}
+
+//factor converts source price of day to shown price
+func (p *VatMode) factor(date string) float64 {
+	if p.SourceIncludesVat == p.ShowVat {
+		return 1
+	}
+	k := 1 + p.Table.Percent(date)/100
+	if p.ShowVat {
+		return k
+	}
+	return 1 / k
+}
+
+//Convert returns copy of data with shown prices. Nil mode keeps prices as they are
+func (p *VatMode) Convert(data VattenfallData) VattenfallData {
+	result := make(VattenfallData, len(data))
+	copy(result, data)
+	if p == nil {
+		return result
+	}
+	for i := range result {
+		result[i].Value *= p.factor(result[i].TimeStampDay)
+	}
+	return result
+}
+
+//FromNet converts net price (like tariff fees) to shown price. Nil mode shows prices of source
+func (p *VatMode) FromNet(net float64, t time.Time) float64 {
+	if p == nil {
+		if VATTENFALL_INCLUDES_VAT {
+			return DEFAULTVATTABLE.Gross(net, t)
+		}
+		return net
+	}
+	if p.ShowVat {
+		return p.Table.Gross(net, t)
+	}
+	return net
+}
+
+//vatFlags registers vat options, returned function gives mode after parsing
+func vatFlags(fs *flag.FlagSet) func() (*VatMode, error) {
+	pVat := fs.Bool("vat", true, "show prices with VAT, -vat=false shows net prices. Control thresholds use downloaded prices")
+	pVatFile := fs.String("vatfile", "", "optional VAT table (json array of {\"from\":\"YYYY-MM-DD\",\"percent\":24}), default finnish rates")
+	pSourceVat := fs.Bool("sourcevat", VATTENFALL_INCLUDES_VAT, "downloaded prices include VAT")
+	return func() (*VatMode, error) {
+		table, errTable := LoadVatTable(*pVatFile)
+		if errTable != nil {
+			return nil, errTable
+		}
+		return &VatMode{Table: table, SourceIncludesVat: *pSourceVat, ShowVat: *pVat}, nil
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"time"
 )
@@ -25,3 +26,30 @@ func TimeInFinland(t time.Time) (time.Time, error) {
 func FinnishWeekDayName(t time.Time) string {
 	return map[int]string{0: "Su", 1: "Ma", 2: "Ti", 3: "Ke", 4: "To", 5: "Pe", 6: "La"}[int(t.Weekday())]
 }
+
+//untilNextHour is duration to next full hour
+func untilNextHour(t time.Time) time.Duration {
+	return t.Truncate(time.Hour).Add(time.Hour).Sub(t)
+}
+
+//ParseDateInFinland parses YYYY-MM-DD, result is noon in finnish time so adding days does not cross DST edges
+func ParseDateInFinland(s string) (time.Time, error) {
+	loc, errloc := time.LoadLocation("Europe/Helsinki")
+	if errloc != nil {
+		return time.Time{}, errloc
+	}
+	t, errParse := time.ParseInLocation("2006-01-02", s, loc)
+	if errParse != nil {
+		return t, fmt.Errorf("invalid date %s, use YYYY-MM-DD", s)
+	}
+	return NoonInFinland(t)
+}
+
+//NoonInFinland is noon of same day in finnish time
+func NoonInFinland(t time.Time) (time.Time, error) {
+	lt, ltErr := TimeInFinland(t)
+	if ltErr != nil {
+		return t, ltErr
+	}
+	return time.Date(lt.Year(), lt.Month(), lt.Day(), 12, 0, 0, 0, lt.Location()), nil
+}
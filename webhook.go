@@ -0,0 +1,398 @@
+/*
+Outgoing webhooks. Generic glue for Node-RED and scripts. Hook is fired when expensive period starts or ends,
+when tomorrow prices are published and when price crosses threshold. Body is rendered from JSON template.
+Fired events are appended to log file, so restart does not fire same event again
+*/
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	WEBHOOKRETRIES    = 3
+	WEBHOOKRETRYDELAY = 2 * time.Second //Doubled after every failed try
+	WEBHOOKTIMEOUT    = 10 * time.Second
+	WEBHOOKLATE       = 30 * time.Minute   //Event is not fired if it is older than this (like after long downtime)
+	WEBHOOKLOG_KEEP   = 7 * 24 * time.Hour //Fired events older than this are dropped from log at startup
+)
+
+const (
+	WEBHOOK_EXPENSIVESTART = "expensiveStart"
+	WEBHOOK_EXPENSIVEEND   = "expensiveEnd"
+	WEBHOOK_TOMORROW       = "tomorrow"
+	WEBHOOK_PRICEABOVE     = "priceAbove" //Price rises to threshold or over
+	WEBHOOK_PRICEBELOW     = "priceBelow" //Price drops under threshold
+)
+
+var webhookEventNames = []string{WEBHOOK_EXPENSIVESTART, WEBHOOK_EXPENSIVEEND, WEBHOOK_TOMORROW, WEBHOOK_PRICEABOVE, WEBHOOK_PRICEBELOW}
+
+//WEBHOOKDEFAULT_TEMPLATE is used when hook has no template
+const WEBHOOKDEFAULT_TEMPLATE = `{"hook":{{json .Hook}},"event":{{json .Event}},"time":{{json .Time}},"price":{{json .Price}},"unit":{{json .Unit}}}`
+
+type WebhookConfig struct {
+	Name           string            `json:"name"`
+	Url            string            `json:"url"`
+	Method         string            `json:"method,omitempty"`   //Default POST. GET sends no body
+	Template       string            `json:"template,omitempty"` //Go text/template producing JSON, fields of WebhookData. json function quotes values
+	Headers        map[string]string `json:"headers,omitempty"`
+	Events         []string          `json:"events"`                   //expensiveStart, expensiveEnd, tomorrow, priceAbove, priceBelow
+	Threshold      *float64          `json:"threshold,omitempty"`      //c/kWh, needed by priceAbove and priceBelow
+	ExpensiveHours int               `json:"expensiveHours,omitempty"` //Expensive hours per day, default same as chart (-e)
+	Retries        int               `json:"retries,omitempty"`        //Default WEBHOOKRETRIES
+}
+
+func (p *WebhookConfig) CheckErr() error {
+	if p.Name == "" {
+		return fmt.Errorf("name missing")
+	}
+	if !strings.HasPrefix(p.Url, "http://") && !strings.HasPrefix(p.Url, "https://") {
+		return fmt.Errorf("%s url must start with http:// or https://", p.Name)
+	}
+	if len(p.Events) == 0 {
+		return fmt.Errorf("%s no events", p.Name)
+	}
+	for _, event := range p.Events {
+		known := false
+		for _, name := range webhookEventNames {
+			known = known || event == name
+		}
+		if !known {
+			return fmt.Errorf("%s unknown event %s, use %s", p.Name, event, strings.Join(webhookEventNames, ", "))
+		}
+		if (event == WEBHOOK_PRICEABOVE || event == WEBHOOK_PRICEBELOW) && p.Threshold == nil {
+			return fmt.Errorf("%s event %s needs threshold", p.Name, event)
+		}
+	}
+	if p.Retries < 0 {
+		return fmt.Errorf("%s retries must not be negative", p.Name)
+	}
+	_, errTemplate := p.parseTemplate()
+	if errTemplate != nil {
+		return fmt.Errorf("%s template err %v", p.Name, errTemplate.Error())
+	}
+	return nil
+}
+
+func (p *WebhookConfig) method() string {
+	if p.Method == "" {
+		return http.MethodPost
+	}
+	return strings.ToUpper(p.Method)
+}
+
+func (p *WebhookConfig) hasEvent(event string) bool {
+	for _, e := range p.Events {
+		if e == event {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *WebhookConfig) parseTemplate() (*template.Template, error) {
+	text := p.Template
+	if text == "" {
+		text = WEBHOOKDEFAULT_TEMPLATE
+	}
+	return template.New(p.Name).Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			content, errMarshal := json.Marshal(v)
+			return string(content), errMarshal
+		},
+	}).Parse(text)
+}
+
+//WebhookData is given to template
+type WebhookData struct {
+	Hook      string
+	Event     string
+	Time      string  //RFC3339, start of hour when event happens. Publish time for tomorrow event
+	Date      string  //YYYY-MM-DD in Finland
+	Price     float64 //Price of hour, average of day in tomorrow event
+	Unit      string
+	Threshold float64
+}
+
+//Body renders template, result must be valid JSON
+func (p *WebhookConfig) Body(data WebhookData) ([]byte, error) {
+	tmpl, errTemplate := p.parseTemplate()
+	if errTemplate != nil {
+		return nil, errTemplate
+	}
+	var buf bytes.Buffer
+	errExecute := tmpl.Execute(&buf, data)
+	if errExecute != nil {
+		return nil, fmt.Errorf("template err %v", errExecute.Error())
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("template did not produce valid json: %s", buf.String())
+	}
+	return buf.Bytes(), nil
+}
+
+//Send does request with retries
+func (p *WebhookConfig) Send(body []byte) error {
+	retries := p.Retries
+	if retries == 0 {
+		retries = WEBHOOKRETRIES
+	}
+	client := &http.Client{Timeout: WEBHOOKTIMEOUT}
+	delay := WEBHOOKRETRYDELAY
+	var errSend error
+	for try := 0; try < retries; try++ {
+		if 0 < try {
+			time.Sleep(delay)
+			delay *= 2
+		}
+		var reqBody io.Reader
+		if p.method() != http.MethodGet {
+			reqBody = bytes.NewReader(body)
+		}
+		req, errReq := http.NewRequest(p.method(), p.Url, reqBody)
+		if errReq != nil {
+			return fmt.Errorf("webhook %s request err %v", p.Name, errReq.Error())
+		}
+		if reqBody != nil {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		for key, value := range p.Headers {
+			req.Header.Set(key, value)
+		}
+		resp, errDo := client.Do(req)
+		if errDo != nil {
+			errSend = fmt.Errorf("webhook %s err %v", p.Name, errDo.Error())
+			continue
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode < 200 || 299 < resp.StatusCode {
+			errSend = fmt.Errorf("webhook %s %v", p.Name, resp.Status)
+			continue
+		}
+		return nil
+	}
+	return errSend
+}
+
+//WebhookOccurrence is scheduled point, key identifies it in fired log
+type WebhookOccurrence struct {
+	Key   string
+	Event string
+	Time  time.Time
+	Date  string //YYYY-MM-DD in Finland, day of prices
+	Price float64
+}
+
+/*
+WebhookOccurrences finds events of hook from consecutive days of prices. Expensive hours are classified
+per day like on chart. Transitions are searched only between consecutive hours
+*/
+func (p *WebhookConfig) WebhookOccurrences(days [][]HourPrice, expensiveN int) []WebhookOccurrence {
+	if 0 < p.ExpensiveHours {
+		expensiveN = p.ExpensiveHours
+	}
+	hours := []HourPrice{}
+	flags := []bool{}
+	for _, day := range days {
+		hours = append(hours, day...)
+		flags = append(flags, expensiveFlags(day, expensiveN)...)
+	}
+	result := []WebhookOccurrence{}
+	add := func(event string, hp HourPrice) {
+		if p.hasEvent(event) {
+			lt, _ := TimeInFinland(hp.Start)
+			result = append(result, WebhookOccurrence{Key: event + "/" + hp.Start.UTC().Format(time.RFC3339), Event: event, Time: hp.Start, Date: lt.Format("2006-01-02"), Price: hp.Price})
+		}
+	}
+	for i := 1; i < len(hours); i++ {
+		if !hours[i].Start.Equal(hours[i-1].Start.Add(time.Hour)) {
+			continue
+		}
+		if flags[i] && !flags[i-1] {
+			add(WEBHOOK_EXPENSIVESTART, hours[i])
+		}
+		if !flags[i] && flags[i-1] {
+			add(WEBHOOK_EXPENSIVEEND, hours[i])
+		}
+		if p.Threshold != nil {
+			if *p.Threshold <= hours[i].Price && hours[i-1].Price < *p.Threshold {
+				add(WEBHOOK_PRICEABOVE, hours[i])
+			}
+			if hours[i].Price < *p.Threshold && *p.Threshold <= hours[i-1].Price {
+				add(WEBHOOK_PRICEBELOW, hours[i])
+			}
+		}
+	}
+	return result
+}
+
+//WebhookLog is persistent log of fired events, one json line per event
+type WebhookLog struct {
+	Filename string
+	mutex    sync.Mutex
+	fired    map[string]time.Time
+}
+
+type webhookLogLine struct {
+	Key   string    `json:"key"`
+	Fired time.Time `json:"fired"`
+}
+
+//OpenWebhookLog reads log and rewrites it without old entries. Missing file is empty log
+func OpenWebhookLog(filename string, tNow time.Time) (*WebhookLog, error) {
+	result := WebhookLog{Filename: filename, fired: make(map[string]time.Time)}
+	content, errRead := os.ReadFile(filename)
+	if errRead != nil && !os.IsNotExist(errRead) {
+		return nil, fmt.Errorf("log %s read err %v", filename, errRead.Error())
+	}
+	var buf bytes.Buffer
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := webhookLogLine{}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || tNow.Sub(line.Fired) > WEBHOOKLOG_KEEP {
+			continue
+		}
+		result.fired[line.Key] = line.Fired
+		buf.Write(scanner.Bytes())
+		buf.WriteString("\n")
+	}
+	errWrite := os.WriteFile(filename, buf.Bytes(), 0644)
+	if errWrite != nil {
+		return nil, fmt.Errorf("log %s write err %v", filename, errWrite.Error())
+	}
+	return &result, nil
+}
+
+func (p *WebhookLog) Fired(key string) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	_, haveKey := p.fired[key]
+	return haveKey
+}
+
+//Mark appends key to log file
+func (p *WebhookLog) Mark(key string, tNow time.Time) error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.fired[key] = tNow
+	content, _ := json.Marshal(webhookLogLine{Key: key, Fired: tNow})
+	f, errOpen := os.OpenFile(p.Filename, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if errOpen != nil {
+		return fmt.Errorf("log %s open err %v", p.Filename, errOpen.Error())
+	}
+	_, errWrite := f.Write(append(content, '\n'))
+	if errWrite != nil {
+		f.Close()
+		return fmt.Errorf("log %s write err %v", p.Filename, errWrite.Error())
+	}
+	return f.Close()
+}
+
+type WebhookStatus struct {
+	LastEvent string
+	LastFired time.Time
+	LastError string
+}
+
+type WebhookDispatcher struct {
+	Hooks              []WebhookConfig
+	Log                *WebhookLog
+	ExpensiveHourCount int
+
+	mutex  sync.Mutex
+	status []WebhookStatus
+}
+
+func NewWebhookDispatcher(hooks []WebhookConfig, log *WebhookLog, expensiveHourCount int) *WebhookDispatcher {
+	return &WebhookDispatcher{Hooks: hooks, Log: log, ExpensiveHourCount: expensiveHourCount, status: make([]WebhookStatus, len(hooks))}
+}
+
+func (p *WebhookDispatcher) State(index int) WebhookStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.status[index]
+}
+
+func (p *WebhookDispatcher) fire(index int, occ WebhookOccurrence, tNow time.Time) error {
+	hook := p.Hooks[index]
+	logKey := hook.Name + "/" + occ.Key
+	if p.Log.Fired(logKey) {
+		return nil
+	}
+	data := WebhookData{Hook: hook.Name, Event: occ.Event, Time: occ.Time.Format(time.RFC3339), Date: occ.Date, Price: occ.Price, Unit: VATTENFALLEXPECTED_UNIT}
+	if hook.Threshold != nil {
+		data.Threshold = *hook.Threshold
+	}
+	body, errBody := hook.Body(data)
+	errSend := errBody
+	if errBody == nil {
+		errSend = hook.Send(body)
+	}
+
+	p.mutex.Lock()
+	if errSend != nil {
+		p.status[index].LastError = errSend.Error()
+	} else {
+		p.status[index] = WebhookStatus{LastEvent: occ.Event, LastFired: tNow}
+	}
+	p.mutex.Unlock()
+	if errSend != nil {
+		return errSend //Not marked, so tried again on next check while event is not too old
+	}
+	fmt.Printf("webhook %s fired %s\n", hook.Name, occ.Key)
+	return p.Log.Mark(logKey, tNow)
+}
+
+//Check fires due events of all hooks
+func (p *WebhookDispatcher) Check(store *PriceStore, tNow time.Time) {
+	days := [][]HourPrice{}
+	for d := -1; d <= 1; d++ {
+		day, errDay := store.DayPrices(tNow.AddDate(0, 0, d))
+		if errDay == nil {
+			days = append(days, day)
+		}
+	}
+	tomorrow, errTomorrow := store.DayPrices(tNow.AddDate(0, 0, 1))
+	for i, hook := range p.Hooks {
+		due := []WebhookOccurrence{}
+		for _, occ := range hook.WebhookOccurrences(days, p.ExpensiveHourCount) {
+			if !tNow.Before(occ.Time) && tNow.Sub(occ.Time) < WEBHOOKLATE {
+				due = append(due, occ)
+			}
+		}
+		if errTomorrow == nil && 0 < len(tomorrow) && hook.hasEvent(WEBHOOK_TOMORROW) {
+			lt, _ := TimeInFinland(tomorrow[0].Start)
+			date := lt.Format("2006-01-02")
+			due = append(due, WebhookOccurrence{Key: WEBHOOK_TOMORROW + "/" + date, Event: WEBHOOK_TOMORROW, Time: tNow, Date: date, Price: averagePrice(tomorrow)})
+		}
+		for _, occ := range due {
+			errFire := p.fire(i, occ, tNow)
+			if errFire != nil {
+				fmt.Printf("%v\n", errFire.Error())
+			}
+		}
+	}
+}
+
+//Run checks at hour boundaries and between. Never returns
+func (p *WebhookDispatcher) Run(store *PriceStore) {
+	for {
+		p.Check(store, time.Now())
+		wait := untilNextHour(time.Now())
+		if RELAYCHECK_INTERVAL < wait {
+			wait = RELAYCHECK_INTERVAL
+		}
+		time.Sleep(wait + 100*time.Millisecond)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+//hookStandIn records requests and fails first failCount of them
+type hookStandIn struct {
+	mutex     sync.Mutex
+	failCount int
+	bodies    []string
+	headers   []http.Header
+}
+
+func startHookStandIn(t *testing.T, failCount int) (*hookStandIn, *httptest.Server) {
+	t.Helper()
+	result := &hookStandIn{failCount: failCount}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		result.mutex.Lock()
+		defer result.mutex.Unlock()
+		result.bodies = append(result.bodies, string(body))
+		result.headers = append(result.headers, r.Header.Clone())
+		if len(result.bodies) <= result.failCount {
+			http.Error(w, "not now", http.StatusServiceUnavailable)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return result, server
+}
+
+func (p *hookStandIn) requests() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.bodies)
+}
+
+func TestWebhookSendRetries(t *testing.T) {
+	standIn, server := startHookStandIn(t, 1)
+	hook := WebhookConfig{Name: "test", Url: server.URL, Events: []string{WEBHOOK_TOMORROW}, Retries: 2, Headers: map[string]string{"Authorization": "Bearer secret"}}
+	errSend := hook.Send([]byte(`{"a":1}`))
+	if errSend != nil {
+		t.Fatal(errSend)
+	}
+	if standIn.requests() != 2 {
+		t.Fatalf("%d requests, wanted failed and retried", standIn.requests())
+	}
+	if standIn.bodies[1] != `{"a":1}` || standIn.headers[1].Get("Authorization") != "Bearer secret" || standIn.headers[1].Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected retry %s %v", standIn.bodies[1], standIn.headers[1])
+	}
+
+	failing, failServer := startHookStandIn(t, 100)
+	hook = WebhookConfig{Name: "failing", Url: failServer.URL, Events: []string{WEBHOOK_TOMORROW}, Retries: 1}
+	errSend = hook.Send([]byte(`{}`))
+	if errSend == nil || !strings.Contains(errSend.Error(), "503") {
+		t.Fatalf("expected status error, got %v", errSend)
+	}
+	if failing.requests() != 1 {
+		t.Fatalf("%d requests with one try", failing.requests())
+	}
+}
+
+func TestWebhookBody(t *testing.T) {
+	data := WebhookData{Hook: "test", Event: WEBHOOK_PRICEABOVE, Time: "2026-10-19T09:00:00+03:00", Date: "2026-10-19", Price: 10.5, Unit: VATTENFALLEXPECTED_UNIT, Threshold: 10}
+	hook := WebhookConfig{Name: "test", Template: `{"text":{{json (printf "%s \"%s\"" .Event .Date)}},"price":{{.Price}},"threshold":{{.Threshold}}}`}
+	body, errBody := hook.Body(data)
+	if errBody != nil {
+		t.Fatal(errBody)
+	}
+	got := map[string]interface{}{}
+	errParse := json.Unmarshal(body, &got)
+	if errParse != nil {
+		t.Fatalf("body %s err %v", body, errParse)
+	}
+	if got["text"] != `priceAbove "2026-10-19"` || got["price"] != 10.5 || got["threshold"] != 10.0 {
+		t.Fatalf("unexpected body %s", body)
+	}
+
+	defaultHook := WebhookConfig{Name: "test"}
+	body, errBody = defaultHook.Body(data)
+	if errBody != nil || !strings.Contains(string(body), `"event":"priceAbove"`) {
+		t.Fatalf("default template %s err %v", body, errBody)
+	}
+
+	invalid := WebhookConfig{Name: "test", Template: `{"event":{{.Event}}}`} //Not quoted
+	_, errBody = invalid.Body(data)
+	if errBody == nil {
+		t.Fatal("invalid json was accepted")
+	}
+}
+
+func TestWebhookDispatcherFiresOnce(t *testing.T) {
+	standIn, server := startHookStandIn(t, 0)
+	store := testStore(t, "2026-10-19", rampPrices(1, 1))
+	threshold := 10.0
+	hooks := []WebhookConfig{{Name: "above", Url: server.URL, Events: []string{WEBHOOK_PRICEABOVE}, Threshold: &threshold}}
+	logName := path.Join(t.TempDir(), "webhooks.log")
+	tNow := testDate(t, "2026-10-19").Add(-3*time.Hour + 5*time.Minute) //09:05, price rises from 9 to 10 at 09:00
+
+	hookLog, errLog := OpenWebhookLog(logName, tNow)
+	if errLog != nil {
+		t.Fatal(errLog)
+	}
+	dispatcher := NewWebhookDispatcher(hooks, hookLog, EXPENSIVEHOURCOUNT)
+	dispatcher.Check(store, tNow)
+	if standIn.requests() != 1 || !strings.Contains(standIn.bodies[0], `"price":10`) {
+		t.Fatalf("%d requests %v, wanted one priceAbove", standIn.requests(), standIn.bodies)
+	}
+	if dispatcher.State(0).LastEvent != WEBHOOK_PRICEABOVE {
+		t.Fatalf("unexpected state %#v", dispatcher.State(0))
+	}
+	dispatcher.Check(store, tNow.Add(time.Minute))
+	if standIn.requests() != 1 {
+		t.Fatalf("fired again on next check")
+	}
+
+	//Like restart
+	reopened, errReopen := OpenWebhookLog(logName, tNow.Add(2*time.Minute))
+	if errReopen != nil {
+		t.Fatal(errReopen)
+	}
+	NewWebhookDispatcher(hooks, reopened, EXPENSIVEHOURCOUNT).Check(store, tNow.Add(2*time.Minute))
+	if standIn.requests() != 1 {
+		t.Fatalf("fired again after reopening log")
+	}
+
+	//Old entries are dropped from log
+	_, errReopen = OpenWebhookLog(logName, tNow.Add(WEBHOOKLOG_KEEP+time.Hour))
+	if errReopen != nil {
+		t.Fatal(errReopen)
+	}
+	content, _ := os.ReadFile(logName)
+	if len(content) != 0 {
+		t.Fatalf("old entries kept: %s", content)
+	}
+}
+
+func TestLoadConfigDuplicateWebhook(t *testing.T) {
+	filename := path.Join(t.TempDir(), "config.json")
+	content := `{"webhooks":[
+		{"name":"a","url":"http://127.0.0.1:1880/a","events":["tomorrow"]},
+		{"name":"a","url":"http://127.0.0.1:1880/b","events":["tomorrow"]}]}`
+	os.WriteFile(filename, []byte(content), 0644)
+	_, errLoad := LoadConfig(filename)
+	if errLoad == nil || !strings.Contains(errLoad.Error(), "used twice") {
+		t.Fatalf("duplicate name accepted, err %v", errLoad)
+	}
+}
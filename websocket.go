@@ -0,0 +1,202 @@
+/*
+Minimal server side WebSocket (RFC 6455). Only what OCPP-J needs: text messages, ping/pong and close.
+No extensions or compression
+*/
+package main
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	WS_GUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+	WS_CONTINUATION byte = 0x0
+	WS_TEXT         byte = 0x1
+	WS_BINARY       byte = 0x2
+	WS_CLOSE        byte = 0x8
+	WS_PING         byte = 0x9
+	WS_PONG         byte = 0xA
+
+	WS_MAXMESSAGE = 1 << 20
+)
+
+type WsConn struct {
+	conn       net.Conn
+	reader     *bufio.Reader
+	writeMutex sync.Mutex
+}
+
+//headerHasToken checks comma separated header like "Connection: keep-alive, Upgrade"
+func headerHasToken(h http.Header, name string, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//UpgradeWebsocket does handshake. Protocol must be one of offered subprotocols, empty accepts any
+func UpgradeWebsocket(w http.ResponseWriter, r *http.Request, protocol string) (*WsConn, error) {
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		http.Error(w, "websocket upgrade expected", http.StatusBadRequest)
+		return nil, fmt.Errorf("not websocket upgrade request")
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" || r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "unsupported websocket version", http.StatusBadRequest)
+		return nil, fmt.Errorf("unsupported websocket version")
+	}
+	if protocol != "" && !headerHasToken(r.Header, "Sec-WebSocket-Protocol", protocol) {
+		http.Error(w, "subprotocol "+protocol+" required", http.StatusBadRequest)
+		return nil, fmt.Errorf("subprotocol %s not offered", protocol)
+	}
+	hijacker, canHijack := w.(http.Hijacker)
+	if !canHijack {
+		http.Error(w, "hijack not supported", http.StatusInternalServerError)
+		return nil, fmt.Errorf("hijack not supported")
+	}
+	conn, rw, errHijack := hijacker.Hijack()
+	if errHijack != nil {
+		return nil, fmt.Errorf("hijack err %v", errHijack.Error())
+	}
+
+	sum := sha1.Sum([]byte(key + WS_GUID))
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n"
+	if protocol != "" {
+		response += "Sec-WebSocket-Protocol: " + protocol + "\r\n"
+	}
+	response += "\r\n"
+	conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, errWrite := conn.Write([]byte(response))
+	if errWrite != nil {
+		conn.Close()
+		return nil, fmt.Errorf("websocket handshake write err %v", errWrite.Error())
+	}
+	return &WsConn{conn: conn, reader: rw.Reader}, nil
+}
+
+func (p *WsConn) writeFrame(opcode byte, payload []byte) error {
+	p.writeMutex.Lock()
+	defer p.writeMutex.Unlock()
+	header := []byte{0x80 | opcode} //FIN, server frames are not masked
+	switch {
+	case len(payload) < 126:
+		header = append(header, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		header = append(header, 126, byte(len(payload)>>8), byte(len(payload)))
+	default:
+		ext := make([]byte, 8)
+		binary.BigEndian.PutUint64(ext, uint64(len(payload)))
+		header = append(append(header, 127), ext...)
+	}
+	p.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	_, errWrite := p.conn.Write(append(header, payload...))
+	return errWrite
+}
+
+func (p *WsConn) WriteText(s string) error {
+	return p.writeFrame(WS_TEXT, []byte(s))
+}
+
+//readFrame reads one frame and unmasks it
+func (p *WsConn) readFrame() (bool, byte, []byte, error) {
+	head := make([]byte, 2)
+	_, errHead := io.ReadFull(p.reader, head)
+	if errHead != nil {
+		return false, 0, nil, errHead
+	}
+	fin := head[0]&0x80 != 0
+	opcode := head[0] & 0x0F
+	masked := head[1]&0x80 != 0
+	length := uint64(head[1] & 0x7F)
+	switch length {
+	case 126:
+		ext := make([]byte, 2)
+		_, errExt := io.ReadFull(p.reader, ext)
+		if errExt != nil {
+			return false, 0, nil, errExt
+		}
+		length = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		_, errExt := io.ReadFull(p.reader, ext)
+		if errExt != nil {
+			return false, 0, nil, errExt
+		}
+		length = binary.BigEndian.Uint64(ext)
+	}
+	if WS_MAXMESSAGE < length {
+		return false, 0, nil, fmt.Errorf("websocket frame too large %v", length)
+	}
+	if !masked {
+		return false, 0, nil, fmt.Errorf("websocket client frame not masked")
+	}
+	mask := make([]byte, 4)
+	_, errMask := io.ReadFull(p.reader, mask)
+	if errMask != nil {
+		return false, 0, nil, errMask
+	}
+	payload := make([]byte, length)
+	_, errPayload := io.ReadFull(p.reader, payload)
+	if errPayload != nil {
+		return false, 0, nil, errPayload
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+//ReadText returns next text message. Pings are answered, close is answered and returned as io.EOF
+func (p *WsConn) ReadText() (string, error) {
+	message := []byte{}
+	for {
+		fin, opcode, payload, errFrame := p.readFrame()
+		if errFrame != nil {
+			return "", errFrame
+		}
+		switch opcode {
+		case WS_PING:
+			errPong := p.writeFrame(WS_PONG, payload)
+			if errPong != nil {
+				return "", errPong
+			}
+			continue
+		case WS_PONG:
+			continue
+		case WS_CLOSE:
+			p.writeFrame(WS_CLOSE, payload)
+			return "", io.EOF
+		case WS_TEXT, WS_CONTINUATION:
+			message = append(message, payload...)
+		default:
+			return "", fmt.Errorf("websocket unsupported opcode %d", opcode)
+		}
+		if WS_MAXMESSAGE < len(message) {
+			return "", fmt.Errorf("websocket message too large")
+		}
+		if fin {
+			return string(message), nil
+		}
+	}
+}
+
+func (p *WsConn) Close() error {
+	return p.conn.Close()
+}